@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/private/process"
+)
+
+var (
+	adminsCmd = &cobra.Command{
+		Use:   "admins",
+		Short: "Manage the users with the admin role in the database",
+	}
+
+	adminsAddCmd = &cobra.Command{
+		Use:   "add <user>",
+		Short: "Give a user the admin role",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAdminsAdd,
+	}
+
+	adminsRmCmd = &cobra.Command{
+		Use:   "rm <user>",
+		Short: "Take the admin role from a user",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAdminsRm,
+	}
+
+	adminsLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List users with the admin role",
+		Args:  cobra.NoArgs,
+		RunE:  cmdAdminsLs,
+	}
+
+	adminsConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(adminsCmd)
+	for _, cmd := range []*cobra.Command{adminsAddCmd, adminsRmCmd, adminsLsCmd} {
+		adminsCmd.AddCommand(cmd)
+		process.Bind(cmd, &adminsConfig)
+	}
+}
+
+func cmdAdminsAdd(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, adminsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.AddAdmin(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to add admin: %v", err)
+	}
+
+	return nil
+}
+
+func cmdAdminsRm(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, adminsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.RemoveAdmin(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to remove admin: %v", err)
+	}
+
+	return nil
+}
+
+func cmdAdminsLs(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, adminsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	admins, err := db.ListAdmins(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list admins: %v", err)
+	}
+
+	for _, admin := range admins {
+		fmt.Printf("%s\t%s\n", admin.Name, admin.Created.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/common/uuid"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	apiKeysCmd = &cobra.Command{
+		Use:   "api-keys",
+		Short: "Manage the scoped API keys of users",
+	}
+
+	apiKeysCreateCmd = &cobra.Command{
+		Use:   "create <user>",
+		Short: "Create an API key for a user and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAPIKeysCreate,
+	}
+
+	apiKeysLsCmd = &cobra.Command{
+		Use:   "ls [user]",
+		Short: "List the API keys of a user, or of all users",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cmdAPIKeysLs,
+	}
+
+	apiKeysRevokeCmd = &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAPIKeysRevoke,
+	}
+
+	apiKeysConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+	}
+
+	apiKeysCreateConfig struct {
+		DatabaseURL string        `help:"database url to store user to content mappings"`
+		Scopes      []string      `help:"scopes of the key: add, pin:read, pin:rm, files, keys, pins or admin" default:"add,pin:read,pin:rm"`
+		Expires     time.Duration `help:"time until the key expires, or 0 for never" default:"0"`
+	}
+
+	apiKeysLsConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		Output      string `help:"output format: table or json" default:"table"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(apiKeysCmd)
+	apiKeysCmd.AddCommand(apiKeysCreateCmd, apiKeysLsCmd, apiKeysRevokeCmd)
+	process.Bind(apiKeysCreateCmd, &apiKeysCreateConfig)
+	process.Bind(apiKeysLsCmd, &apiKeysLsConfig)
+	process.Bind(apiKeysRevokeCmd, &apiKeysConfig)
+}
+
+func cmdAPIKeysCreate(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	scopes, err := auth.ParseScopes(apiKeysCreateConfig.Scopes)
+	if err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	id, err := uuid.New()
+	if err != nil {
+		return fmt.Errorf("failed to generate key id: %v", err)
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	key := db.APIKey{
+		ID:   id.String(),
+		User: args[0],
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, string(scope))
+	}
+	if apiKeysCreateConfig.Expires > 0 {
+		expires := time.Now().Add(apiKeysCreateConfig.Expires)
+		key.Expires = &expires
+	}
+
+	db, err := openDB(ctx, apiKeysCreateConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.AddAPIKey(ctx, key, hash)
+	if err != nil {
+		return fmt.Errorf("failed to add api key: %v", err)
+	}
+
+	fmt.Printf("ID:  %s\nKey: %s\n", key.ID, token)
+
+	return nil
+}
+
+func cmdAPIKeysLs(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	switch apiKeysLsConfig.Output {
+	case "table", "json":
+	default:
+		return fmt.Errorf("unsupported output format: %q", apiKeysLsConfig.Output)
+	}
+
+	db, err := openDB(ctx, apiKeysLsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	var user string
+	if len(args) > 0 {
+		user = args[0]
+	}
+
+	keys, err := db.ListAPIKeys(ctx, user)
+	if err != nil {
+		return fmt.Errorf("failed to list api keys: %v", err)
+	}
+
+	return printAPIKeys(keys, apiKeysLsConfig.Output)
+}
+
+func printAPIKeys(keys []db.APIKey, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if keys == nil {
+			keys = []db.APIKey{}
+		}
+		return enc.Encode(keys)
+	default:
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return "-"
+			}
+			return t.Format(time.RFC3339)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tSCOPES\tCREATED\tEXPIRES\tLAST USED")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.User, strings.Join(key.Scopes, ","),
+				key.Created.Format(time.RFC3339), formatTime(key.Expires), formatTime(key.LastUsed))
+		}
+		return w.Flush()
+	}
+}
+
+func cmdAPIKeysRevoke(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, apiKeysConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.RevokeAPIKey(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for authentication errors.
+var Error = errs.Class("auth")
+
+// ErrInvalidCredentials is returned when the provided credentials do not
+// match the stored ones or the user is unknown.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Verifier verifies the credentials of a user.
+type Verifier interface {
+	// Verify returns ErrInvalidCredentials if password is not valid for user.
+	Verify(ctx context.Context, user, password string) error
+}
+
+// Verifiers is a list of verifiers that are tried in order.
+type Verifiers []Verifier
+
+// Verify returns nil if any of the verifiers accepts the credentials.
+//
+// It returns ErrInvalidCredentials if all verifiers rejected them.
+func (verifiers Verifiers) Verify(ctx context.Context, user, password string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, verifier := range verifiers {
+		err := verifier.Verify(ctx, user, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		return err
+	}
+
+	return ErrInvalidCredentials
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// DBVerifier verifies credentials against the users table in the database.
+type DBVerifier struct {
+	db *db.DB
+}
+
+// NewDBVerifier creates a new DBVerifier.
+func NewDBVerifier(db *db.DB) *DBVerifier {
+	return &DBVerifier{db: db}
+}
+
+// Verify verifies password against the hash stored in the database for user.
+func (verifier *DBVerifier) Verify(ctx context.Context, user, password string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	hash, err := verifier.db.GetUserPasswordHash(ctx, user)
+	if err != nil {
+		if db.ErrNotFound.Has(err) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return ComparePassword(hash, password)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+)
+
+// Htpasswd verifies credentials against the entries of an htpasswd file.
+//
+// Only bcrypt and argon2 hashes are supported.
+type Htpasswd struct {
+	hashes map[string]string
+}
+
+// LoadHtpasswd loads the htpasswd file at path.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	htpasswd := &Htpasswd{hashes: make(map[string]string)}
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, Error.New("%s:%d: invalid entry", path, lineNo)
+		}
+
+		err := ValidateHash(hash)
+		if err != nil {
+			return nil, Error.New("%s:%d: %v", path, lineNo, err)
+		}
+
+		htpasswd.hashes[user] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return htpasswd, nil
+}
+
+// Verify verifies password against the hash stored for user.
+func (htpasswd *Htpasswd) Verify(ctx context.Context, user, password string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	hash, ok := htpasswd.hashes[user]
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	return ComparePassword(hash, password)
+}
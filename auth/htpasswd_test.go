@@ -0,0 +1,103 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+)
+
+func TestComparePassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name     string
+		hash     string
+		password string
+		valid    bool
+	}{
+		{name: "bcrypt", hash: string(bcryptHash), password: "secret", valid: true},
+		{name: "bcrypt wrong", hash: string(bcryptHash), password: "wrong", valid: false},
+		{name: "argon2id", hash: argon2Hash("argon2id", "secret"), password: "secret", valid: true},
+		{name: "argon2id wrong", hash: argon2Hash("argon2id", "secret"), password: "wrong", valid: false},
+		{name: "argon2i", hash: argon2Hash("argon2i", "secret"), password: "secret", valid: true},
+		{name: "argon2i wrong", hash: argon2Hash("argon2i", "secret"), password: "wrong", valid: false},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.ComparePassword(tt.hash, tt.password)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			}
+		})
+	}
+
+	// Unsupported hashes must not be treated as invalid credentials.
+	err = auth.ComparePassword("$apr1$salt$hash", "secret")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestHtpasswd(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("john-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	path := filepath.Join(ctx.Dir(), "htpasswd")
+	err = os.WriteFile(path, []byte(fmt.Sprintf("# comment\njohn:%s\n\nshawn:%s\n",
+		bcryptHash, argon2Hash("argon2id", "shawn-secret"))), 0600)
+	require.NoError(t, err)
+
+	htpasswd, err := auth.LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	assert.NoError(t, htpasswd.Verify(ctx, "john", "john-secret"))
+	assert.NoError(t, htpasswd.Verify(ctx, "shawn", "shawn-secret"))
+	assert.ErrorIs(t, htpasswd.Verify(ctx, "john", "shawn-secret"), auth.ErrInvalidCredentials)
+	assert.ErrorIs(t, htpasswd.Verify(ctx, "unknown", "john-secret"), auth.ErrInvalidCredentials)
+
+	// Verifiers accepts the credentials if any of the verifiers accepts them.
+	verifiers := auth.Verifiers{htpasswd}
+	assert.NoError(t, verifiers.Verify(ctx, "shawn", "shawn-secret"))
+	assert.ErrorIs(t, verifiers.Verify(ctx, "shawn", "john-secret"), auth.ErrInvalidCredentials)
+}
+
+func TestHtpasswd_UnsupportedHash(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	path := filepath.Join(ctx.Dir(), "htpasswd")
+	err := os.WriteFile(path, []byte("john:$apr1$salt$hash\n"), 0600)
+	require.NoError(t, err)
+
+	_, err = auth.LoadHtpasswd(path)
+	require.Error(t, err)
+}
+
+func argon2Hash(variant, password string) string {
+	salt := []byte("somesaltsomesalt")
+
+	var key []byte
+	switch variant {
+	case "argon2id":
+		key = argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	case "argon2i":
+		key = argon2.Key([]byte(password), salt, 1, 64, 1, 32)
+	}
+
+	return fmt.Sprintf("$%s$v=%d$m=64,t=1,p=1$%s$%s", variant, argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
@@ -0,0 +1,660 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// ErrInvalidToken is the error class for JWTs that are malformed, have an
+// invalid signature or claims that are not accepted.
+var ErrInvalidToken = errs.Class("invalid token")
+
+const (
+	// DefaultUserClaim is the default JWT claim with the user name.
+	DefaultUserClaim = "sub"
+	// DefaultScopeClaim is the default JWT claim with the scopes.
+	DefaultScopeClaim = "scope"
+	// DefaultQuotaBytesClaim is the default JWT claim with the storage quota.
+	DefaultQuotaBytesClaim = "quota_bytes"
+	// DefaultQuotaPinsClaim is the default JWT claim with the pin quota.
+	DefaultQuotaPinsClaim = "quota_pins"
+)
+
+const (
+	// jwksMaxAge is the time after which a JWKS fetched from a URL is
+	// fetched again.
+	jwksMaxAge = time.Hour
+	// jwksMinRefreshInterval is the minimum time between two fetches of a
+	// JWKS from a URL, so tokens with unknown key IDs cannot make the
+	// verifier fetch it on every request.
+	jwksMinRefreshInterval = time.Minute
+	// maxJWKSSize is the maximum size of a JWKS fetched from a URL.
+	maxJWKSSize = 1 << 20
+	// jwksFetchTimeout is the timeout of the default HTTP client to fetch
+	// a JWKS from a URL.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// JWTConfig configures a JWTVerifier.
+//
+// Tokens are verified with the keys of the JWKS file or URL, or with the
+// HMAC secret. At least one of them must be set.
+type JWTConfig struct {
+	// JWKSFile is the path to a JWKS file with the public keys.
+	JWKSFile string
+	// JWKSURL is the URL of a JWKS with the public keys. It is fetched again
+	// when it is older than an hour or a token has an unknown key ID.
+	JWKSURL string
+	// HMACSecret is the secret of HMAC-signed tokens.
+	HMACSecret string
+	// Audience, if set, must be one of the audiences of the token.
+	Audience string
+	// UserClaim is the claim with the user name. Defaults to DefaultUserClaim.
+	UserClaim string
+	// ScopeClaim is the claim with the scopes of the token, either a
+	// space-separated string or an array. Defaults to DefaultScopeClaim.
+	ScopeClaim string
+	// QuotaBytesClaim is the claim with the storage quota of the user in
+	// bytes. Defaults to DefaultQuotaBytesClaim.
+	QuotaBytesClaim string
+	// QuotaPinsClaim is the claim with the pin quota of the user. Defaults to
+	// DefaultQuotaPinsClaim.
+	QuotaPinsClaim string
+	// Leeway is the allowed clock skew when validating exp and nbf.
+	Leeway time.Duration
+	// Client is the HTTP client to fetch the JWKS URL. Defaults to a client
+	// with a timeout of 10 seconds.
+	Client *http.Client
+}
+
+// JWTClaims are the claims of a verified JWT.
+type JWTClaims struct {
+	// User is the user name from the user claim.
+	User string
+	// Scopes are the scopes from the scope claim. It is nil if the token has
+	// no scope claim, in which case the token is not restricted.
+	Scopes []string
+	// MaxBytes and MaxPins are the quota hints from the quota claims. They
+	// are nil if the token does not have the claim.
+	MaxBytes *int64
+	MaxPins  *int64
+}
+
+// JWTVerifier verifies JWTs signed with RS256, ES256 or EdDSA keys from a
+// JWKS, or with HS256, HS384 or HS512 and a static secret.
+type JWTVerifier struct {
+	config JWTConfig
+
+	mu      sync.Mutex
+	keys    []jwk
+	fetched time.Time
+	// refreshing is closed when the JWKS fetch in flight is done. It is nil
+	// if no fetch is in flight.
+	refreshing chan struct{}
+}
+
+// NewJWTVerifier creates a JWTVerifier and loads its JWKS.
+func NewJWTVerifier(ctx context.Context, config JWTConfig) (_ *JWTVerifier, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if config.JWKSFile == "" && config.JWKSURL == "" && config.HMACSecret == "" {
+		return nil, Error.New("no JWKS or HMAC secret to verify JWTs")
+	}
+
+	if config.UserClaim == "" {
+		config.UserClaim = DefaultUserClaim
+	}
+	if config.ScopeClaim == "" {
+		config.ScopeClaim = DefaultScopeClaim
+	}
+	if config.QuotaBytesClaim == "" {
+		config.QuotaBytesClaim = DefaultQuotaBytesClaim
+	}
+	if config.QuotaPinsClaim == "" {
+		config.QuotaPinsClaim = DefaultQuotaPinsClaim
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: jwksFetchTimeout}
+	}
+
+	verifier := &JWTVerifier{config: config}
+
+	if config.JWKSFile != "" {
+		data, err := os.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+
+		verifier.keys, err = parseJWKS(data)
+		if err != nil {
+			return nil, Error.New("%s: %v", config.JWKSFile, err)
+		}
+	}
+
+	if config.JWKSURL != "" {
+		keys, err := verifier.fetchJWKS(ctx)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keys = append(verifier.keys, keys...)
+		verifier.fetched = time.Now()
+	}
+
+	return verifier, nil
+}
+
+// IsJWT returns whether token looks like a JWT rather than an opaque token.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify verifies the signature and the exp, nbf and aud claims of token
+// and returns its claims.
+//
+// It returns an ErrInvalidToken error if the token is not accepted.
+func (verifier *JWTVerifier) Verify(ctx context.Context, token string) (claims JWTClaims, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JWTClaims{}, ErrInvalidToken.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return JWTClaims{}, ErrInvalidToken.New("malformed header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return JWTClaims{}, ErrInvalidToken.New("malformed signature: %v", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	err = verifier.verifySignature(ctx, header.Alg, header.Kid, signed, signature)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	var payload map[string]interface{}
+	err = decodeJWTPart(parts[1], &payload)
+	if err != nil {
+		return JWTClaims{}, ErrInvalidToken.New("malformed claims: %v", err)
+	}
+
+	return verifier.validateClaims(payload)
+}
+
+// verifySignature verifies the signature of signed with the key for alg
+// and kid.
+func (verifier *JWTVerifier) verifySignature(ctx context.Context, alg, kid string, signed, signature []byte) error {
+	switch alg {
+	case "HS256":
+		return verifier.verifyHMAC(sha256.New, signed, signature)
+	case "HS384":
+		return verifier.verifyHMAC(sha512.New384, signed, signature)
+	case "HS512":
+		return verifier.verifyHMAC(sha512.New, signed, signature)
+	case "RS256", "ES256", "EdDSA":
+	default:
+		return ErrInvalidToken.New("unsupported algorithm %q", alg)
+	}
+
+	keys := verifier.lookupKeys(ctx, alg, kid)
+	if len(keys) == 0 {
+		return ErrInvalidToken.New("no %s key with ID %q", alg, kid)
+	}
+
+	for _, key := range keys {
+		if key.verify(alg, signed, signature) {
+			return nil
+		}
+	}
+
+	return ErrInvalidToken.New("invalid signature")
+}
+
+// verifyHMAC verifies the HMAC signature of signed with the static secret.
+func (verifier *JWTVerifier) verifyHMAC(hash func() hash.Hash, signed, signature []byte) error {
+	if verifier.config.HMACSecret == "" {
+		return ErrInvalidToken.New("HMAC-signed tokens are not accepted")
+	}
+
+	mac := hmac.New(hash, []byte(verifier.config.HMACSecret))
+	_, _ = mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrInvalidToken.New("invalid signature")
+	}
+
+	return nil
+}
+
+// lookupKeys returns the keys that can verify alg with kid. Tokens without
+// a key ID are checked against all keys for alg.
+//
+// The JWKS URL is fetched again if it is too old or has no key for kid.
+func (verifier *JWTVerifier) lookupKeys(ctx context.Context, alg, kid string) []jwk {
+	if verifier.config.JWKSURL == "" {
+		verifier.mu.Lock()
+		defer verifier.mu.Unlock()
+		return matchingKeys(verifier.keys, alg, kid)
+	}
+
+	verifier.refresh(ctx, jwksMaxAge)
+
+	verifier.mu.Lock()
+	keys := matchingKeys(verifier.keys, alg, kid)
+	verifier.mu.Unlock()
+
+	if len(keys) == 0 {
+		verifier.refresh(ctx, jwksMinRefreshInterval)
+
+		verifier.mu.Lock()
+		keys = matchingKeys(verifier.keys, alg, kid)
+		verifier.mu.Unlock()
+	}
+
+	return keys
+}
+
+// refresh fetches the JWKS URL again if it was fetched more than maxAge ago.
+// Concurrent callers share a single fetch, which runs without holding the
+// lock, so the other requests can still verify tokens with the current keys.
+// The fetched keys are swapped in under the lock. The keys of the JWKS file
+// are kept. If the fetch fails, the previous keys are kept.
+func (verifier *JWTVerifier) refresh(ctx context.Context, maxAge time.Duration) {
+	verifier.mu.Lock()
+	if done := verifier.refreshing; done != nil {
+		verifier.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	if time.Since(verifier.fetched) <= maxAge {
+		verifier.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	verifier.refreshing = done
+	verifier.fetched = time.Now()
+	verifier.mu.Unlock()
+
+	// The fetch is not canceled with ctx, as other callers may wait for it.
+	// It is limited by the timeout of the client instead.
+	fetched, err := verifier.fetchJWKS(context.Background())
+
+	verifier.mu.Lock()
+	defer verifier.mu.Unlock()
+	defer close(done)
+	verifier.refreshing = nil
+
+	if err != nil {
+		mon.Event("jwks_refresh_failed")
+		return
+	}
+
+	keys := make([]jwk, 0, len(verifier.keys)+len(fetched))
+	for _, key := range verifier.keys {
+		if !key.remote {
+			keys = append(keys, key)
+		}
+	}
+	verifier.keys = append(keys, fetched...)
+}
+
+// fetchJWKS fetches and parses the JWKS URL.
+func (verifier *JWTVerifier) fetchJWKS(ctx context.Context) (_ []jwk, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, verifier.config.JWKSURL, nil)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	resp, err := verifier.config.Client.Do(req)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(resp.Body.Close())) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, Error.New("fetching JWKS: unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, Error.New("%s: %v", verifier.config.JWKSURL, err)
+	}
+
+	for i := range keys {
+		keys[i].remote = true
+	}
+
+	return keys, nil
+}
+
+// validateClaims validates the exp, nbf and aud claims of payload and
+// extracts the user, scopes and quota hints.
+func (verifier *JWTVerifier) validateClaims(payload map[string]interface{}) (claims JWTClaims, err error) {
+	now := time.Now()
+	leeway := verifier.config.Leeway
+
+	exp, ok, err := numericDate(payload, "exp")
+	if err != nil {
+		return JWTClaims{}, err
+	}
+	if !ok {
+		return JWTClaims{}, ErrInvalidToken.New("missing exp claim")
+	}
+	if !now.Before(exp.Add(leeway)) {
+		return JWTClaims{}, ErrInvalidToken.New("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+
+	nbf, ok, err := numericDate(payload, "nbf")
+	if err != nil {
+		return JWTClaims{}, err
+	}
+	if ok && now.Add(leeway).Before(nbf) {
+		return JWTClaims{}, ErrInvalidToken.New("token not valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+
+	if verifier.config.Audience != "" {
+		audiences, err := stringList(payload, "aud", false)
+		if err != nil {
+			return JWTClaims{}, err
+		}
+		if !containsString(audiences, verifier.config.Audience) {
+			return JWTClaims{}, ErrInvalidToken.New("token not issued for audience %q", verifier.config.Audience)
+		}
+	}
+
+	user, _ := payload[verifier.config.UserClaim].(string)
+	if user == "" {
+		return JWTClaims{}, ErrInvalidToken.New("missing %s claim", verifier.config.UserClaim)
+	}
+	claims.User = user
+
+	if _, ok := payload[verifier.config.ScopeClaim]; ok {
+		claims.Scopes, err = stringList(payload, verifier.config.ScopeClaim, true)
+		if err != nil {
+			return JWTClaims{}, err
+		}
+		if claims.Scopes == nil {
+			claims.Scopes = []string{}
+		}
+	}
+
+	claims.MaxBytes, err = integerClaim(payload, verifier.config.QuotaBytesClaim)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	claims.MaxPins, err = integerClaim(payload, verifier.config.QuotaPinsClaim)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	return claims, nil
+}
+
+// decodeJWTPart decodes the base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numericDate returns the time of the NumericDate claim name of payload.
+func numericDate(payload map[string]interface{}, name string) (_ time.Time, ok bool, err error) {
+	value, ok := payload[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, ErrInvalidToken.New("invalid %s claim", name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, ErrInvalidToken.New("invalid %s claim", name)
+	}
+
+	return time.Unix(0, 0).Add(time.Duration(seconds * float64(time.Second))), true, nil
+}
+
+// integerClaim returns the integer claim name of payload, or nil if payload
+// does not have it.
+func integerClaim(payload map[string]interface{}, name string) (*int64, error) {
+	value, ok := payload[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, ErrInvalidToken.New("invalid %s claim", name)
+	}
+
+	n, err := number.Int64()
+	if err != nil || n < 0 {
+		return nil, ErrInvalidToken.New("invalid %s claim", name)
+	}
+
+	return &n, nil
+}
+
+// stringList returns the claim name of payload, which is either a string or
+// an array of strings. If split is set, a string is split at spaces.
+func stringList(payload map[string]interface{}, name string, split bool) ([]string, error) {
+	switch value := payload[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if split {
+			return strings.Fields(value), nil
+		}
+		return []string{value}, nil
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, ErrInvalidToken.New("invalid %s claim", name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, ErrInvalidToken.New("invalid %s claim", name)
+	}
+}
+
+// containsString returns whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jwk is a public key of a JWKS.
+type jwk struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+	// remote is set for keys fetched from the JWKS URL.
+	remote bool
+}
+
+// verify returns whether signature is a valid alg signature of signed by
+// the key.
+func (key jwk) verify(alg string, signed, signature []byte) bool {
+	switch alg {
+	case "RS256":
+		pub, ok := key.key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		pub, ok := key.key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case "EdDSA":
+		pub, ok := key.key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, signed, signature)
+	default:
+		return false
+	}
+}
+
+// matchingKeys returns the keys of keys that can verify alg with kid.
+func matchingKeys(keys []jwk, alg, kid string) []jwk {
+	var matching []jwk
+	for _, key := range keys {
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		if key.alg != alg {
+			continue
+		}
+		matching = append(matching, key)
+	}
+	return matching
+}
+
+// parseJWKS parses the RSA, P-256 and Ed25519 signing keys of a JWKS.
+// Other keys are ignored.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+
+	err := json.Unmarshal(data, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for i, raw := range jwks.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+
+		var key jwk
+		switch {
+		case raw.Kty == "RSA":
+			n, err := decodeBigInt(raw.N)
+			if err != nil {
+				return nil, errs.New("key %d: invalid n: %v", i, err)
+			}
+			e, err := decodeBigInt(raw.E)
+			if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+				return nil, errs.New("key %d: invalid e", i)
+			}
+			key = jwk{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}
+		case raw.Kty == "EC" && raw.Crv == "P-256":
+			x, err := decodeBigInt(raw.X)
+			if err != nil {
+				return nil, errs.New("key %d: invalid x: %v", i, err)
+			}
+			y, err := decodeBigInt(raw.Y)
+			if err != nil {
+				return nil, errs.New("key %d: invalid y: %v", i, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, errs.New("key %d: point is not on curve P-256", i)
+			}
+			key = jwk{alg: "ES256", key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}
+		case raw.Kty == "OKP" && raw.Crv == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(raw.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, errs.New("key %d: invalid x", i)
+			}
+			key = jwk{alg: "EdDSA", key: ed25519.PublicKey(x)}
+		default:
+			continue
+		}
+
+		if raw.Alg != "" && raw.Alg != key.alg {
+			continue
+		}
+
+		key.kid = raw.Kid
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errs.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,316 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+)
+
+func TestJWTVerifier_Algorithms(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	keys := newTestKeySet(t)
+	verifier, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{
+		JWKSFile:   keys.writeJWKS(t),
+		HMACSecret: "secret",
+	})
+	require.NoError(t, err)
+
+	claims := map[string]interface{}{
+		"sub": "john",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA", "HS256"} {
+		token := keys.sign(t, alg, "secret", claims)
+
+		verified, err := verifier.Verify(ctx, token)
+		require.NoError(t, err, alg)
+		assert.Equal(t, auth.JWTClaims{User: "john"}, verified, alg)
+
+		// Tampered claims invalidate the signature.
+		parts := strings.Split(token, ".")
+		tampered := strings.Split(keys.sign(t, alg, "secret", map[string]interface{}{"sub": "shawn", "exp": claims["exp"]}), ".")
+		_, err = verifier.Verify(ctx, parts[0]+"."+tampered[1]+"."+parts[2])
+		assert.True(t, auth.ErrInvalidToken.Has(err), alg)
+	}
+
+	// Tokens signed with other keys or secrets are rejected.
+	_, err = verifier.Verify(ctx, newTestKeySet(t).sign(t, "EdDSA", "", claims))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+
+	_, err = verifier.Verify(ctx, keys.sign(t, "HS256", "wrong", claims))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+
+	// Unsigned tokens are rejected.
+	_, err = verifier.Verify(ctx, keys.sign(t, "none", "", claims))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+
+	// HMAC is only accepted with a secret.
+	noSecret, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{JWKSFile: keys.writeJWKS(t)})
+	require.NoError(t, err)
+	_, err = noSecret.Verify(ctx, keys.sign(t, "HS256", "", claims))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+}
+
+func TestJWTVerifier_Claims(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	keys := newTestKeySet(t)
+	verifier, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{
+		JWKSFile:  keys.writeJWKS(t),
+		Audience:  "ipfs",
+		UserClaim: "email",
+		Leeway:    time.Minute,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	for _, tt := range []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{name: "valid", claims: map[string]interface{}{"email": "john", "aud": "ipfs", "exp": now.Add(time.Hour).Unix()}, valid: true},
+		{name: "audience list", claims: map[string]interface{}{"email": "john", "aud": []string{"web", "ipfs"}, "exp": now.Add(time.Hour).Unix()}, valid: true},
+		{name: "expired within leeway", claims: map[string]interface{}{"email": "john", "aud": "ipfs", "exp": now.Add(-30 * time.Second).Unix()}, valid: true},
+		{name: "expired", claims: map[string]interface{}{"email": "john", "aud": "ipfs", "exp": now.Add(-time.Hour).Unix()}},
+		{name: "no exp", claims: map[string]interface{}{"email": "john", "aud": "ipfs"}},
+		{name: "nbf passed", claims: map[string]interface{}{"email": "john", "aud": "ipfs", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(-time.Hour).Unix()}, valid: true},
+		{name: "nbf in future", claims: map[string]interface{}{"email": "john", "aud": "ipfs", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Hour).Unix()}},
+		{name: "wrong audience", claims: map[string]interface{}{"email": "john", "aud": "web", "exp": now.Add(time.Hour).Unix()}},
+		{name: "no audience", claims: map[string]interface{}{"email": "john", "exp": now.Add(time.Hour).Unix()}},
+		{name: "no user", claims: map[string]interface{}{"sub": "john", "aud": "ipfs", "exp": now.Add(time.Hour).Unix()}},
+	} {
+		claims, err := verifier.Verify(ctx, keys.sign(t, "ES256", "", tt.claims))
+		if tt.valid {
+			require.NoError(t, err, tt.name)
+			assert.Equal(t, "john", claims.User, tt.name)
+		} else {
+			assert.True(t, auth.ErrInvalidToken.Has(err), tt.name)
+		}
+	}
+}
+
+func TestJWTVerifier_Hints(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	verifier, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{HMACSecret: "secret"})
+	require.NoError(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	claims, err := verifier.Verify(ctx, signHS256(t, "secret", map[string]interface{}{
+		"sub": "john", "exp": exp, "scope": "add pin:read", "quota_bytes": 1024, "quota_pins": 10,
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"add", "pin:read"}, claims.Scopes)
+	require.NotNil(t, claims.MaxBytes)
+	assert.EqualValues(t, 1024, *claims.MaxBytes)
+	require.NotNil(t, claims.MaxPins)
+	assert.EqualValues(t, 10, *claims.MaxPins)
+
+	claims, err = verifier.Verify(ctx, signHS256(t, "secret", map[string]interface{}{
+		"sub": "john", "exp": exp, "scope": []string{"pin:rm"},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pin:rm"}, claims.Scopes)
+	assert.Nil(t, claims.MaxBytes)
+	assert.Nil(t, claims.MaxPins)
+
+	// An empty scope claim grants no scopes, while a missing one is not
+	// restricted.
+	claims, err = verifier.Verify(ctx, signHS256(t, "secret", map[string]interface{}{"sub": "john", "exp": exp, "scope": ""}))
+	require.NoError(t, err)
+	assert.NotNil(t, claims.Scopes)
+	assert.Empty(t, claims.Scopes)
+
+	claims, err = verifier.Verify(ctx, signHS256(t, "secret", map[string]interface{}{"sub": "john", "exp": exp}))
+	require.NoError(t, err)
+	assert.Nil(t, claims.Scopes)
+
+	_, err = verifier.Verify(ctx, signHS256(t, "secret", map[string]interface{}{"sub": "john", "exp": exp, "quota_bytes": "lots"}))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+}
+
+func TestJWTVerifier_JWKSURL(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	keys := newTestKeySet(t)
+	var jwks atomic.Value
+	jwks.Store(keys.jwks(t))
+
+	var fetches int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		_, _ = w.Write(jwks.Load().([]byte))
+	}))
+	defer server.Close()
+
+	verifier, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{JWKSURL: server.URL})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&fetches))
+
+	claims := map[string]interface{}{"sub": "john", "exp": time.Now().Add(time.Hour).Unix()}
+
+	_, err = verifier.Verify(ctx, keys.sign(t, "RS256", "", claims))
+	require.NoError(t, err)
+
+	// A token with an unknown key ID doesn't refetch the JWKS right away.
+	rotated := newTestKeySet(t)
+	jwks.Store(rotated.jwks(t))
+
+	_, err = verifier.Verify(ctx, rotated.sign(t, "RS256", "", claims))
+	assert.True(t, auth.ErrInvalidToken.Has(err))
+	assert.EqualValues(t, 1, atomic.LoadInt64(&fetches))
+}
+
+func TestJWTVerifier_JWKSURLTimeout(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	// A JWKS URL that doesn't respond fails instead of hanging.
+	_, err := auth.NewJWTVerifier(ctx, auth.JWTConfig{
+		JWKSURL: server.URL,
+		Client:  &http.Client{Timeout: 100 * time.Millisecond},
+	})
+	require.Error(t, err)
+}
+
+// testKeySet is a locally generated set of signing keys.
+type testKeySet struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+	// kid is the prefix of the key IDs of the set.
+	kid string
+}
+
+func newTestKeySet(t *testing.T) *testKeySet {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var kid [8]byte
+	_, err = rand.Read(kid[:])
+	require.NoError(t, err)
+
+	return &testKeySet{
+		rsa:     rsaKey,
+		ecdsa:   ecdsaKey,
+		ed25519: ed25519Key,
+		kid:     base64.RawURLEncoding.EncodeToString(kid[:]),
+	}
+}
+
+func (keys *testKeySet) jwks(t *testing.T) []byte {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": keys.kid + "-rsa", "use": "sig", "alg": "RS256",
+				"n": encode(keys.rsa.N.Bytes()),
+				"e": encode(big.NewInt(int64(keys.rsa.E)).Bytes()),
+			},
+			{
+				"kty": "EC", "kid": keys.kid + "-ec", "crv": "P-256",
+				"x": encode(keys.ecdsa.X.FillBytes(make([]byte, 32))),
+				"y": encode(keys.ecdsa.Y.FillBytes(make([]byte, 32))),
+			},
+			{
+				"kty": "OKP", "kid": keys.kid + "-ed", "crv": "Ed25519",
+				"x": encode(keys.ed25519.Public().(ed25519.PublicKey)),
+			},
+			// Encryption keys are ignored.
+			{"kty": "RSA", "kid": keys.kid + "-enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		},
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+func (keys *testKeySet) writeJWKS(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(t), 0o600))
+	return path
+}
+
+// sign returns a JWT with claims signed by the key of the set for alg, or
+// with secret for HS256.
+func (keys *testKeySet) sign(t *testing.T, alg, secret string, claims map[string]interface{}) string {
+	if alg == "HS256" {
+		return signHS256(t, secret, claims)
+	}
+
+	kid := map[string]string{"RS256": "-rsa", "ES256": "-ec", "EdDSA": "-ed"}[alg]
+	signed := encodeJWT(t, map[string]string{"alg": alg, "typ": "JWT", "kid": keys.kid + kid}, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, keys.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, keys.ecdsa, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "EdDSA":
+		signature = ed25519.Sign(keys.ed25519, []byte(signed))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	signed := encodeJWT(t, map[string]string{"alg": "HS256", "typ": "JWT"}, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeJWT(t *testing.T, header map[string]string, claims map[string]interface{}) string {
+	headerJSON, err := json.Marshal(header)
+	require.NoError(t, err)
+
+	claimsJSON, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", Error.Wrap(err)
+	}
+	return string(hash), nil
+}
+
+// ComparePassword compares password with hash.
+//
+// The hash can be either in bcrypt format ($2a$, $2b$, $2y$) or
+// in argon2 PHC string format ($argon2id$, $argon2i$). It returns
+// ErrInvalidCredentials if password does not match hash.
+func ComparePassword(hash, password string) error {
+	switch {
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrInvalidCredentials
+		}
+		return Error.Wrap(err)
+	case isArgon2(hash):
+		return compareArgon2(hash, password)
+	default:
+		return Error.New("unsupported password hash format")
+	}
+}
+
+// ValidateHash checks if hash is in a format supported by ComparePassword.
+func ValidateHash(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return Error.Wrap(err)
+	case isArgon2(hash):
+		_, err := parseArgon2(hash)
+		return err
+	default:
+		return Error.New("unsupported password hash format")
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func isArgon2(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$") ||
+		strings.HasPrefix(hash, "$argon2i$")
+}
+
+// argon2Hash is a parsed argon2 PHC string.
+type argon2Hash struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2 parses a PHC string like
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, Error.New("invalid argon2 hash: unexpected number of fields")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return nil, Error.New("invalid argon2 hash version: %v", err)
+	}
+	if version != argon2.Version {
+		return nil, Error.New("unsupported argon2 version: %d", version)
+	}
+
+	h := &argon2Hash{variant: parts[1]}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil {
+		return nil, Error.New("invalid argon2 hash parameters: %v", err)
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, Error.New("invalid argon2 salt: %v", err)
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, Error.New("invalid argon2 key: %v", err)
+	}
+
+	return h, nil
+}
+
+func compareArgon2(hash, password string) error {
+	h, err := parseArgon2(hash)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	switch h.variant {
+	case "argon2id":
+		key = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	case "argon2i":
+		key = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	default:
+		return Error.New("unsupported argon2 variant: %s", h.variant)
+	}
+
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeAdd allows adding content with add, dag/import, pin/add and
+	// pin/update.
+	ScopeAdd Scope = "add"
+	// ScopePinRead allows listing and reading the content of the user.
+	ScopePinRead Scope = "pin:read"
+	// ScopePinRm allows removing the content of the user with pin/rm and
+	// pin/update.
+	ScopePinRm Scope = "pin:rm"
+	// ScopeFiles allows using the MFS root of the user.
+	ScopeFiles Scope = "files"
+	// ScopeKeys allows managing the IPNS keys of the user and publishing
+	// names.
+	ScopeKeys Scope = "keys"
+	// ScopePins allows managing the pin requests of the user with the
+	// Pinning Service API.
+	ScopePins Scope = "pins"
+	// ScopeAdmin allows calling the admin endpoints if the user has the
+	// admin role.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes are all known scopes.
+var Scopes = []Scope{ScopeAdd, ScopePinRead, ScopePinRm, ScopeFiles, ScopeKeys, ScopePins, ScopeAdmin}
+
+// OrganizationScopePrefix is the prefix of the scopes that let an API key or
+// a JWT act on behalf of an organization, like org:acme. The user of the
+// credentials must still be a member of the organization.
+const OrganizationScopePrefix = "org:"
+
+// OrganizationScope returns the scope to act on behalf of org.
+func OrganizationScope(org string) Scope {
+	return Scope(OrganizationScopePrefix + org)
+}
+
+// Organizations returns the organizations of the organization scopes in
+// granted.
+func Organizations(granted []string) []string {
+	var orgs []string
+	for _, name := range granted {
+		if org := strings.TrimPrefix(name, OrganizationScopePrefix); org != name && org != "" {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+// ParseScopes parses scope names. It returns an error if any of them is
+// unknown. Organization scopes are accepted for any organization.
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope, ok := parseScope(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func parseScope(name string) (Scope, bool) {
+	if org := strings.TrimPrefix(name, OrganizationScopePrefix); org != name {
+		return OrganizationScope(org), org != ""
+	}
+
+	for _, scope := range Scopes {
+		if string(scope) == name {
+			return scope, true
+		}
+	}
+	return "", false
+}
+
+// HasScopes returns whether granted includes all required scopes.
+func HasScopes(granted []string, required ...Scope) bool {
+	for _, scope := range required {
+		found := false
+		for _, name := range granted {
+			if name == string(scope) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// tokenSize is the number of random bytes in a bearer token.
+const tokenSize = 32
+
+// NewToken generates a new random bearer token and returns it together
+// with the hash to store in the database.
+func NewToken() (token string, hash []byte, err error) {
+	var secret [tokenSize]byte
+	_, err = rand.Read(secret[:])
+	if err != nil {
+		return "", nil, Error.Wrap(err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(secret[:])
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash of token as stored in the database.
+func HashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// BearerToken returns the bearer token from the Authorization header of r.
+func BearerToken(r *http.Request) (token string, ok bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	token = strings.TrimSpace(header[len(prefix):])
+	return token, token != ""
+}
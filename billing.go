@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/billing"
+	"storj.io/private/process"
+)
+
+var (
+	billingCmd = &cobra.Command{
+		Use:   "billing",
+		Short: "Report the storage consumed by users in byte-hours over a billing period",
+		Args:  cobra.NoArgs,
+		RunE:  cmdBilling,
+	}
+
+	billingConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		From        string `help:"RFC 3339 timestamp of the start of the billing period, inclusive" default:""`
+		To          string `help:"RFC 3339 timestamp of the end of the billing period, exclusive" default:""`
+		Output      string `help:"output format: table, csv or json" default:"table"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(billingCmd)
+	process.Bind(billingCmd, &billingConfig)
+}
+
+func cmdBilling(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	from, err := time.Parse(time.RFC3339, billingConfig.From)
+	if err != nil {
+		return fmt.Errorf("failed to parse from timestamp: %v", err)
+	}
+
+	to, err := time.Parse(time.RFC3339, billingConfig.To)
+	if err != nil {
+		return fmt.Errorf("failed to parse to timestamp: %v", err)
+	}
+
+	switch billingConfig.Output {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unsupported output format: %q", billingConfig.Output)
+	}
+
+	db, err := openDB(ctx, billingConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	entries, err := billing.Ledger(ctx, db, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to calculate billing ledger: %v", err)
+	}
+
+	switch billingConfig.Output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []billing.Entry{}
+		}
+		return enc.Encode(entries)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"user", "byte_hours", "intervals"})
+		for _, entry := range entries {
+			_ = w.Write([]string{
+				entry.User,
+				strconv.FormatFloat(entry.ByteHours, 'f', 3, 64),
+				strconv.Itoa(entry.Intervals),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tBYTE-HOURS\tINTERVALS")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%.3f\t%d\n", entry.User, entry.ByteHours, entry.Intervals)
+		}
+		return w.Flush()
+	}
+}
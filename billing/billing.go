@@ -0,0 +1,81 @@
+// Package billing calculates the storage consumed by users over a billing
+// period from the content interval history.
+package billing
+
+import (
+	"context"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for billing.
+var Error = errs.Class("billing")
+
+// Entry is the storage consumed by a user over a billing period.
+type Entry struct {
+	// User is the user who consumed the storage.
+	User string
+
+	// ByteHours is the storage consumed over the period in byte-hours.
+	ByteHours float64
+
+	// Intervals is the number of content intervals that overlap the period.
+	Intervals int
+}
+
+// Ledger calculates the byte-hours of every user over the [from, to) period.
+//
+// Each interval when a user had a content active is clipped to the period
+// and counted with the size of the content. Content that was added, removed
+// and added again is counted only for the time it was active. Users without
+// content in the period are not included. The entries are ordered by user.
+func Ledger(ctx context.Context, contentDB *db.DB, from, to time.Time) (entries []Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !from.Before(to) {
+		return nil, Error.New("invalid period: %s is not before %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	err = contentDB.IterateContentIntervals(ctx, from, to, func(interval db.ContentInterval) error {
+		if len(entries) == 0 || entries[len(entries)-1].User != interval.User {
+			entries = append(entries, Entry{User: interval.User})
+		}
+
+		entry := &entries[len(entries)-1]
+		entry.ByteHours += ByteHours(interval, from, to)
+		entry.Intervals++
+
+		return nil
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return entries, nil
+}
+
+// ByteHours returns the byte-hours of interval clipped to the [from, to)
+// period.
+func ByteHours(interval db.ContentInterval, from, to time.Time) float64 {
+	start := interval.Created
+	if start.Before(from) {
+		start = from
+	}
+
+	end := to
+	if interval.Removed != nil && interval.Removed.Before(to) {
+		end = *interval.Removed
+	}
+
+	if !start.Before(end) {
+		return 0
+	}
+
+	return float64(interval.Size) * end.Sub(start).Hours()
+}
@@ -0,0 +1,45 @@
+package billing_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"storj.io/ipfs-user-mapping-proxy/billing"
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+func TestByteHours(t *testing.T) {
+	from := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+
+	at := func(hours float64) *time.Time {
+		t := from.Add(time.Duration(hours * float64(time.Hour)))
+		return &t
+	}
+
+	for _, tt := range []struct {
+		name     string
+		created  time.Time
+		removed  *time.Time
+		expected float64
+	}{
+		{name: "whole period", created: *at(-5), expected: 10 * 100},
+		{name: "added in period", created: *at(2), expected: 8 * 100},
+		{name: "removed in period", created: *at(-5), removed: at(4), expected: 4 * 100},
+		{name: "added and removed in period", created: *at(2), removed: at(2.5), expected: 0.5 * 100},
+		{name: "removed before period", created: *at(-5), removed: at(-1), expected: 0},
+		{name: "added after period", created: *at(11), expected: 0},
+		{name: "removed at period end", created: *at(-5), removed: at(10), expected: 10 * 100},
+	} {
+		interval := db.ContentInterval{
+			User:    "john",
+			Hash:    "pin-hash-1",
+			Size:    100,
+			Created: tt.created,
+			Removed: tt.removed,
+		}
+		assert.InDelta(t, tt.expected, billing.ByteHours(interval, from, to), 1e-9, tt.name)
+	}
+}
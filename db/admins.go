@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Admin represents a user with the admin role in the database.
+type Admin struct {
+	// Name is the user name.
+	Name string
+
+	// Created is when the user got the admin role.
+	Created time.Time
+}
+
+// AddAdmin gives user the admin role. It does nothing if the user is
+// already an admin.
+func (db *DB) AddAdmin(ctx context.Context, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO admins (username)
+		VALUES ($1)
+		ON CONFLICT (username) DO NOTHING
+	`, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// IsAdmin returns whether user has the admin role.
+func (db *DB) IsAdmin(ctx context.Context, user string) (admin bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = db.QueryRowContext(ctx, `
+		SELECT true
+		FROM admins
+		WHERE username = $1
+	`, user).Scan(&admin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, Error.Wrap(err)
+	}
+
+	return admin, nil
+}
+
+// RemoveAdmin takes the admin role from user.
+//
+// It returns an ErrNotFound error if the user is not an admin.
+func (db *DB) RemoveAdmin(ctx context.Context, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM admins
+		WHERE username = $1
+	`, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("admin %q", user)
+	}
+
+	return nil
+}
+
+// ListAdmins returns all users with the admin role ordered by name.
+func (db *DB) ListAdmins(ctx context.Context) (result []Admin, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, created
+		FROM admins
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var admin Admin
+		err := rows.Scan(&admin.Name, &admin.Created)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, admin)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
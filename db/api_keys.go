@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APIKey represents an API key of a user in the database. The secret of the
+// key is stored only as a hash.
+type APIKey struct {
+	// ID identifies the key for listing and revoking.
+	ID string
+
+	// User is the user the key authenticates as.
+	User string
+
+	// Scopes are the permissions granted to the key.
+	Scopes []string
+
+	// Created is when the key was created.
+	Created time.Time
+
+	// Expires is when the key expires. Nil if it never expires.
+	Expires *time.Time
+
+	// LastUsed is when the key was last used. Nil if never used.
+	LastUsed *time.Time
+}
+
+// AddAPIKey stores a new API key with the hash of its secret.
+//
+// The key's created and last used times are ignored as they are set by the
+// database.
+func (db *DB) AddAPIKey(ctx context.Context, key APIKey, keyHash []byte) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var expires *time.Time
+	if key.Expires != nil {
+		// The timestamps in the database are in UTC without time zone.
+		utc := key.Expires.UTC()
+		expires = &utc
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, key_hash, username, scopes, expires)
+		VALUES ($1, $2, $3, $4, $5)
+	`, key.ID, keyHash, key.User, strings.Join(key.Scopes, " "), expires)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// apiKeyLastUsedInterval is how stale the last used time of an API key may
+// get before UseAPIKey updates it, so that not every request writes to the
+// database.
+const apiKeyLastUsedInterval = time.Minute
+
+// UseAPIKey returns the API key with keyHash and updates its last used time
+// if it is older than apiKeyLastUsedInterval. The returned key has the last
+// used time before the update.
+//
+// It returns an ErrNotFound error if there is no such key or it has expired.
+func (db *DB) UseAPIKey(ctx context.Context, keyHash []byte) (key APIKey, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var scopes string
+	var stale bool
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			id, username, scopes, created, expires, last_used,
+			last_used IS NULL OR last_used < NOW() - $2::FLOAT8 * INTERVAL '1 second'
+		FROM api_keys
+		WHERE
+			key_hash = $1 AND
+			(expires IS NULL OR expires > NOW())
+	`, keyHash, apiKeyLastUsedInterval.Seconds()).Scan(&key.ID, &key.User, &scopes, &key.Created, &key.Expires, &key.LastUsed, &stale)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrNotFound.New("api key")
+		}
+		return APIKey{}, Error.Wrap(err)
+	}
+	key.Scopes = strings.Fields(scopes)
+
+	if stale {
+		_, err = db.ExecContext(ctx, `
+			UPDATE api_keys
+			SET last_used = NOW()
+			WHERE key_hash = $1
+		`, keyHash)
+		if err != nil {
+			return APIKey{}, Error.Wrap(err)
+		}
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys returns the API keys of user, or of all users if empty, ordered
+// by user and created time.
+func (db *DB) ListAPIKeys(ctx context.Context, user string) (result []APIKey, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, username, scopes, created, expires, last_used
+		FROM api_keys
+		WHERE $1 = '' OR username = $1
+		ORDER BY username, created
+	`, user)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key APIKey
+		var scopes string
+		err := rows.Scan(&key.ID, &key.User, &scopes, &key.Created, &key.Expires, &key.LastUsed)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		key.Scopes = strings.Fields(scopes)
+		result = append(result, key)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// RevokeAPIKey deletes the API key with id.
+//
+// It returns an ErrNotFound error if there is no such key.
+func (db *DB) RevokeAPIKey(ctx context.Context, id string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM api_keys
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("api key %q", id)
+	}
+
+	return nil
+}
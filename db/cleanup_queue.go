@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"storj.io/private/tagsql"
+)
+
+// CleanupKind is the kind of state on the IPFS node that is queued for
+// removal after a user was deleted.
+type CleanupKind string
+
+const (
+	// CleanupMFSRoot removes the MFS root of the user with the name of the
+	// queued cleanup.
+	CleanupMFSRoot CleanupKind = "mfs_root"
+	// CleanupKey removes the key with the name of the queued cleanup from
+	// the keystore of the IPFS node.
+	CleanupKey CleanupKind = "key"
+)
+
+// QueuedCleanup represents state of a deleted user queued for removal from
+// the IPFS node.
+type QueuedCleanup struct {
+	// Kind is the kind of state to remove.
+	Kind CleanupKind
+
+	// Name is the user of an MFS root or the node name of a key.
+	Name string
+
+	// Created is when the cleanup was queued.
+	Created time.Time
+
+	// Attempts is the number of failed cleanup attempts.
+	Attempts int
+
+	// NextAttempt is when the cleanup should be attempted next.
+	NextAttempt time.Time
+
+	// LastError is the error of the last failed attempt.
+	LastError string
+}
+
+// queueCleanups queues cleanups in tx. Cleanups already in the queue are
+// left as they are.
+func queueCleanups(ctx context.Context, tx tagsql.Tx, cleanups []QueuedCleanup) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, cleanup := range cleanups {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO cleanup_queue (kind, name)
+			VALUES ($1, $2)
+			ON CONFLICT (kind, name) DO NOTHING
+		`, string(cleanup.Kind), cleanup.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListDueCleanups returns up to limit queued cleanups whose next attempt is
+// due, the most overdue first.
+func (db *DB) ListDueCleanups(ctx context.Context, limit int) (result []QueuedCleanup, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT kind, name, created, attempts, next_attempt, last_error
+		FROM cleanup_queue
+		WHERE next_attempt <= NOW()
+		ORDER BY next_attempt
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cleanup QueuedCleanup
+		err := rows.Scan(&cleanup.Kind, &cleanup.Name, &cleanup.Created, &cleanup.Attempts, &cleanup.NextAttempt, &cleanup.LastError)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, cleanup)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// DeleteQueuedCleanup removes a done cleanup from the queue.
+func (db *DB) DeleteQueuedCleanup(ctx context.Context, kind CleanupKind, name string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM cleanup_queue
+		WHERE kind = $1 AND name = $2
+	`, string(kind), name)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// RetryQueuedCleanup records a failed cleanup attempt with its error and
+// postpones the next attempt by delay.
+func (db *DB) RetryQueuedCleanup(ctx context.Context, kind CleanupKind, name string, delay time.Duration, lastError string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE cleanup_queue
+		SET
+			attempts = attempts + 1,
+			next_attempt = NOW() + $3::FLOAT8 * INTERVAL '1 second',
+			last_error = $4
+		WHERE kind = $1 AND name = $2
+	`, string(kind), name, delay.Seconds(), lastError)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"strconv"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"storj.io/private/dbutil"
 	"storj.io/private/dbutil/cockroachutil" // registers cockroach as a tagsql driver.
 	"storj.io/private/dbutil/pgutil"
+	"storj.io/private/dbutil/txutil"
 	"storj.io/private/migrate"
 	"storj.io/private/tagsql"
 )
@@ -22,6 +25,9 @@ var mon = monkit.Package()
 // Error is the error class for datastore database.
 var Error = errs.Class("db")
 
+// ErrNotFound is the error class for records not found in the database.
+var ErrNotFound = errs.Class("not found")
+
 // DB is the database for mapping pinned IPFS content to users.
 type DB struct {
 	tagsql.DB
@@ -47,6 +53,12 @@ type Content struct {
 
 	// Size is the size in bytes of the uploaded content.
 	Size int64
+
+	// PinRequestsOnly is whether the content is mapped to the user only by
+	// pin requests of the Pinning Service API. Such content is removed with
+	// the last pin request of the user for it, see RemovePinRequestContent.
+	// Adding the content in any other way clears it.
+	PinRequestsOnly bool
 }
 
 // UserHashPair represents the user and hash values of a content record in the database.
@@ -163,6 +175,232 @@ func (db *DB) Migration() *migrate.Migration {
 					`ALTER TABLE content ADD COLUMN removed TIMESTAMP;`,
 				},
 			},
+			{
+				DB:          &db.DB,
+				Description: "Add users table to verify user credentials.",
+				Version:     5,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS users (
+						username TEXT PRIMARY KEY,
+						password_hash TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW()
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add tokens and pin_requests tables for the Pinning Service API.",
+				Version:     6,
+				Action: migrate.SQL{
+					`CREATE TABLE IF NOT EXISTS tokens (
+						token_hash BYTEA PRIMARY KEY,
+						username TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW()
+					)`,
+					`CREATE TABLE IF NOT EXISTS pin_requests (
+						id TEXT PRIMARY KEY,
+						username TEXT NOT NULL,
+						cid TEXT NOT NULL,
+						name TEXT NOT NULL,
+						status TEXT NOT NULL,
+						meta JSONB NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW()
+					)`,
+					`CREATE INDEX IF NOT EXISTS pin_requests_username_created_idx ON pin_requests (username, created)`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add quotas table to limit the content of users.",
+				Version:     7,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS quotas (
+						username TEXT PRIMARY KEY,
+						max_bytes BIGINT,
+						max_pins BIGINT,
+						updated TIMESTAMP NOT NULL DEFAULT NOW()
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add unpin_queue table to retry failed unpins on the IPFS node.",
+				Version:     8,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS unpin_queue (
+						hash TEXT PRIMARY KEY,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						attempts INTEGER NOT NULL DEFAULT 0,
+						next_attempt TIMESTAMP NOT NULL DEFAULT NOW(),
+						last_error TEXT NOT NULL DEFAULT ''
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add content_intervals table to keep the history of active content.",
+				Version:     9,
+				Action: migrate.SQL{
+					`CREATE TABLE IF NOT EXISTS content_intervals (
+						username TEXT NOT NULL,
+						hash TEXT NOT NULL,
+						size BIGINT NOT NULL,
+						created TIMESTAMP NOT NULL,
+						removed TIMESTAMP,
+						PRIMARY KEY (username, hash, created)
+					)`,
+					`INSERT INTO content_intervals (username, hash, size, created, removed)
+						SELECT username, hash, size, created, removed
+						FROM content
+						ON CONFLICT DO NOTHING`,
+					`CREATE INDEX IF NOT EXISTS content_intervals_created_removed_idx ON content_intervals (created, removed)`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add content_events table to keep the history of content changes.",
+				Version:     10,
+				Action: migrate.SQL{
+					`CREATE TABLE IF NOT EXISTS content_events (
+						id BIGSERIAL PRIMARY KEY,
+						username TEXT NOT NULL,
+						hash TEXT NOT NULL,
+						type TEXT NOT NULL,
+						size BIGINT NOT NULL,
+						name TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						request_id TEXT NOT NULL DEFAULT ''
+					)`,
+					`INSERT INTO content_events (username, hash, type, size, name, created)
+						SELECT username, hash, 'add', size, name, created
+						FROM content`,
+					`INSERT INTO content_events (username, hash, type, size, name, created)
+						SELECT username, hash, 'remove', size, name, removed
+						FROM content
+						WHERE removed IS NOT NULL`,
+					`CREATE INDEX IF NOT EXISTS content_events_username_created_idx ON content_events (username, created)`,
+					`CREATE INDEX IF NOT EXISTS content_events_hash_created_idx ON content_events (hash, created)`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add indexes for the keyset pagination of content.",
+				Version:     11,
+				Action: migrate.SQL{
+					`CREATE INDEX IF NOT EXISTS content_username_created_hash_idx ON content (username, created, hash)`,
+					`CREATE INDEX IF NOT EXISTS content_created_hash_idx ON content (created, hash)`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add ipns_keys table to map IPNS keys to users.",
+				Version:     12,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS ipns_keys (
+						username TEXT NOT NULL,
+						name TEXT NOT NULL,
+						node_name TEXT UNIQUE NOT NULL,
+						id TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						PRIMARY KEY (username, name)
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add admins table for the users with the admin role.",
+				Version:     13,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS admins (
+						username TEXT PRIMARY KEY,
+						created TIMESTAMP NOT NULL DEFAULT NOW()
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add api_keys table for the scoped API keys of users.",
+				Version:     14,
+				Action: migrate.SQL{
+					`CREATE TABLE IF NOT EXISTS api_keys (
+						id TEXT PRIMARY KEY,
+						key_hash BYTEA UNIQUE NOT NULL,
+						username TEXT NOT NULL,
+						scopes TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						expires TIMESTAMP,
+						last_used TIMESTAMP
+					)`,
+					`CREATE INDEX IF NOT EXISTS api_keys_username_idx ON api_keys (username)`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add organizations and memberships tables, and the actor of content events.",
+				Version:     15,
+				Action: migrate.SQL{
+					`CREATE TABLE IF NOT EXISTS organizations (
+						name TEXT PRIMARY KEY,
+						created TIMESTAMP NOT NULL DEFAULT NOW()
+					)`,
+					`CREATE TABLE IF NOT EXISTS memberships (
+						organization TEXT NOT NULL,
+						username TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						PRIMARY KEY (organization, username)
+					)`,
+					`CREATE INDEX IF NOT EXISTS memberships_username_idx ON memberships (username)`,
+					`ALTER TABLE content_events ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT ''`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add the queue columns of pin requests, and the content mapped only by pin requests.",
+				Version:     16,
+				Action: migrate.SQL{
+					`ALTER TABLE pin_requests ADD COLUMN IF NOT EXISTS replaces TEXT NOT NULL DEFAULT ''`,
+					`ALTER TABLE pin_requests ADD COLUMN IF NOT EXISTS claimed TIMESTAMP`,
+					`CREATE INDEX IF NOT EXISTS pin_requests_status_created_idx ON pin_requests (status, created)`,
+					`ALTER TABLE content ADD COLUMN IF NOT EXISTS pin_requests_only BOOLEAN NOT NULL DEFAULT false`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add the user to the index for the keyset pagination of all content.",
+				Version:     17,
+				Action: migrate.SQL{
+					`CREATE INDEX IF NOT EXISTS content_created_hash_username_idx ON content (created, hash, username)`,
+					`DROP INDEX IF EXISTS content_created_hash_idx`,
+				},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Add cleanup_queue table to remove the MFS roots and keys of deleted users from the IPFS node.",
+				Version:     18,
+				Action: migrate.SQL{`
+					CREATE TABLE IF NOT EXISTS cleanup_queue (
+						kind TEXT NOT NULL,
+						name TEXT NOT NULL,
+						created TIMESTAMP NOT NULL DEFAULT NOW(),
+						attempts INTEGER NOT NULL DEFAULT 0,
+						next_attempt TIMESTAMP NOT NULL DEFAULT NOW(),
+						last_error TEXT NOT NULL DEFAULT '',
+						PRIMARY KEY (kind, name)
+					)
+				`},
+			},
+			{
+				DB:          &db.DB,
+				Description: "Move the bearer tokens of the Pinning Service API to API keys with the pins scope.",
+				Version:     19,
+				Action: migrate.SQL{
+					`INSERT INTO api_keys (id, key_hash, username, scopes, created)
+					SELECT 'token-' || substr(encode(token_hash, 'hex'), 1, 16), token_hash, username, 'pins', created
+					FROM tokens
+					ON CONFLICT DO NOTHING`,
+					`DROP TABLE IF EXISTS tokens`,
+				},
+			},
 		},
 	}
 }
@@ -170,27 +408,118 @@ func (db *DB) Migration() *migrate.Migration {
 // Add adds a content record to the database.
 //
 // The content's created time is ignored as it is automatically set by the database.
-func (db *DB) Add(ctx context.Context, content Content) (err error) {
+// If the user had the content removed, it is active again and a new interval
+// of the content is started. An add or re-add event of source is recorded
+// unless the content is already active. A queued unpin of the hash is
+// dropped.
+func (db *DB) Add(ctx context.Context, content Content, source EventSource) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	result, err := db.ExecContext(ctx, `
-		INSERT INTO content (username, hash, name, size)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (username, hash)
-		DO UPDATE SET removed = NULL
-	`, content.User, content.Hash, content.Name, content.Size)
+	var affected int64
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		affected, err = addContent(ctx, tx, content, source)
+		return err
+	})
 	if err != nil {
 		return Error.Wrap(err)
 	}
 
-	affected, err := result.RowsAffected()
+	mon.Counter("add_db_affected_rows", monkit.NewSeriesTag("rows", strconv.FormatInt(affected, 10))).Inc(1)
+
+	return nil
+}
+
+// addContent adds a content record in tx. See Add.
+func addContent(ctx context.Context, tx tagsql.Tx, content Content, source EventSource) (affected int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var removed *time.Time
+	eventType := ContentEventAdd
+	err = tx.QueryRowContext(ctx, `
+		SELECT removed
+		FROM content
+		WHERE
+			username = $1 AND
+			hash = $2
+		FOR UPDATE
+	`, content.User, content.Hash).Scan(&removed)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return 0, err
+	case removed != nil:
+		eventType = ContentEventReAdd
+	default:
+		// The content is already active.
+		eventType = ""
+	}
+
+	// Content that is already active stays mapped by other means than pin
+	// requests if it was before.
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO content (username, hash, name, size, pin_requests_only)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, hash)
+		DO UPDATE SET
+			removed = NULL,
+			pin_requests_only = EXCLUDED.pin_requests_only AND (content.removed IS NOT NULL OR content.pin_requests_only)
+	`, content.User, content.Hash, content.Name, content.Size, content.PinRequestsOnly)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err = result.RowsAffected()
 	if err != nil {
-		return Error.Wrap(err)
+		return 0, err
 	}
 
-	mon.Counter("add_db_affected_rows", monkit.NewSeriesTag("rows", strconv.FormatInt(affected, 10))).Inc(1)
+	if eventType == "" {
+		return affected, nil
+	}
 
-	return nil
+	err = dequeueUnpin(ctx, tx, content.Hash)
+	if err != nil {
+		return 0, err
+	}
+
+	err = openContentInterval(ctx, tx, content)
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, insertContentEvent(ctx, tx, ContentEvent{
+		User:      content.User,
+		Hash:      content.Hash,
+		Type:      eventType,
+		Size:      content.Size,
+		Name:      content.Name,
+		RequestID: source.RequestID,
+		Actor:     source.Actor,
+	})
+}
+
+// GetContent returns the active content record of user that matches hash.
+//
+// It returns an ErrNotFound error if the user doesn't have the content active.
+func (db *DB) GetContent(ctx context.Context, user, hash string) (content Content, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = db.QueryRowContext(ctx, `
+		SELECT username, created, removed, hash, name, size
+		FROM content
+		WHERE
+			username = $1 AND
+			hash = $2 AND
+			removed IS NULL
+	`, user, hash).Scan(&content.User, &content.Created, &content.Removed, &content.Hash, &content.Name, &content.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Content{}, ErrNotFound.New("content %q of user %q", hash, user)
+	}
+	if err != nil {
+		return Content{}, Error.Wrap(err)
+	}
+
+	return content, nil
 }
 
 // ListAll returns all content records from the database.
@@ -246,10 +575,68 @@ func (db *DB) ListActiveContentByHash(ctx context.Context, hashes []string) (res
 	return result, nil
 }
 
+// ListContentByHash returns all content records of hash, active or removed,
+// ordered by user.
+func (db *DB) ListContentByHash(ctx context.Context, hash string) (result []Content, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, created, removed, hash, name, size
+		FROM content
+		WHERE hash = $1
+		ORDER BY username
+	`, hash)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content Content
+		err := rows.Scan(&content.User, &content.Created, &content.Removed, &content.Hash, &content.Name, &content.Size)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, content)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
 // ListActiveContentByUser returns all active (not removed) content records that match user.
+//
+// The user can be the owner of the content of an organization, see
+// OrganizationOwner, to list the content shared by its members. The content
+// is read in pages of MaxListLimit records, in the order of ListContent.
 func (db *DB) ListActiveContentByUser(ctx context.Context, user string) (hashes []string, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	opts := ListOptions{
+		User:   user,
+		Status: ActiveStatus,
+		Limit:  MaxListLimit,
+	}
+	for {
+		page, err := db.ListContent(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range page.Content {
+			hashes = append(hashes, content.Hash)
+		}
+		if page.Next == nil {
+			return hashes, nil
+		}
+		opts.Cursor = page.Next
+	}
+}
+
+// IterateActiveContentByUser calls fn for each active (not removed) content
+// hash of user, reading them from the database as a cursor instead of loading
+// them all in memory. Iteration stops at the first error returned by fn.
+func (db *DB) IterateActiveContentByUser(ctx context.Context, user string, fn func(hash string) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
 	rows, err := db.QueryContext(ctx, `
 		SELECT hash
 		FROM content
@@ -258,45 +645,269 @@ func (db *DB) ListActiveContentByUser(ctx context.Context, user string) (hashes
 			removed IS NULL;
 	`, user)
 	if err != nil {
-		return nil, Error.Wrap(err)
+		return Error.Wrap(err)
 	}
-	defer rows.Close()
+	defer func() { err = errs.Combine(err, Error.Wrap(rows.Close())) }()
 
 	for rows.Next() {
 		var hash string
 		err := rows.Scan(&hash)
 		if err != nil {
-			return nil, Error.Wrap(err)
+			return Error.Wrap(err)
+		}
+		err = fn(hash)
+		if err != nil {
+			return err
 		}
-		hashes = append(hashes, hash)
 	}
 
-	return hashes, nil
+	return Error.Wrap(rows.Err())
 }
 
 // RemoveContentByHashForUser updates the remove column for all content that matches user and hashes.
-func (db *DB) RemoveContentByHashForUser(ctx context.Context, user string, hashes []string) (err error) {
+//
+// A remove event of source is recorded for each removed content. The user can
+// be the owner of the content of an organization, see OrganizationOwner, and
+// the actor of source the member who removed it. The hashes that are no
+// longer active for any user are queued for unpinning from the IPFS node in
+// the same transaction.
+func (db *DB) RemoveContentByHashForUser(ctx context.Context, user string, hashes []string, source EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var affected int64
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		affected, err = removeContentForUser(ctx, tx, user, hashes, source)
+		return err
+	})
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	mon.Counter("remove_content_by_hash_for_user_db_affected_rows", monkit.NewSeriesTag("rows", strconv.FormatInt(affected, 10))).Inc(1)
+
+	return nil
+}
+
+// removeContentForUser removes the content of user that matches hashes in tx.
+// See RemoveContentByHashForUser.
+func removeContentForUser(ctx context.Context, tx tagsql.Tx, user string, hashes []string, source EventSource) (affected int64, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	result, err := db.ExecContext(ctx, `
+	rows, err := tx.QueryContext(ctx, `
 		UPDATE content
 		SET
 			removed = NOW()
 		WHERE
 			username = $1 AND
 			hash = ANY($2) AND
-			removed IS NULL;
+			removed IS NULL
+		RETURNING username, hash, size, name;
 	`, user, pgutil.TextArray(hashes))
 	if err != nil {
+		return 0, err
+	}
+
+	events, err := scanRemoveEvents(rows, source)
+	if err != nil {
+		return 0, err
+	}
+
+	affected = int64(len(events))
+	if affected == 0 {
+		return 0, nil
+	}
+
+	var removed []string
+	for _, event := range events {
+		removed = append(removed, event.Hash)
+		err = insertContentEvent(ctx, tx, event)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = closeContentIntervalsForUser(ctx, tx, user, removed)
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, queueUnpins(ctx, tx, removed)
+}
+
+// UpdateContentWithinQuota replaces the active content from of user with the
+// content to in a single transaction, as if from was removed and to was
+// added, if check accepts it. The events are recorded with source.
+//
+// Check is called like by AddWithinQuota with the current usage of the user
+// and the usage that the update adds to it. If the user doesn't have to
+// active yet, that is the size of to minus the size of from, without a pin,
+// as to replaces from. Otherwise, nothing is added. If check returns an
+// error, nothing is changed and the error is returned as is. A nil check
+// accepts any update.
+//
+// It returns an ErrNotFound error if the user doesn't have from active.
+func (db *DB) UpdateContentWithinQuota(ctx context.Context, user, from string, to Content, source EventSource, check func(usage, added Usage) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	to.User = user
+	var checkErr error
+	err = txutil.WithTx(ctx, db.DB, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context, tx tagsql.Tx) error {
+		checkErr = nil
+
+		if check != nil {
+			usage, added, err := addedUsage(ctx, tx, user, []Content{to})
+			if err != nil {
+				return err
+			}
+
+			if added.Pins > 0 {
+				var fromSize int64
+				err = tx.QueryRowContext(ctx, `
+					SELECT COALESCE(SUM(size), 0)
+					FROM content
+					WHERE
+						username = $1 AND
+						hash = $2 AND
+						removed IS NULL
+				`, user, from).Scan(&fromSize)
+				if err != nil {
+					return err
+				}
+				added.Bytes -= fromSize
+				added.Pins = 0
+			}
+
+			checkErr = check(usage, added)
+			if checkErr != nil {
+				return checkErr
+			}
+		}
+
+		affected, err := removeContentForUser(ctx, tx, user, []string{from}, source)
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound.New("content %q of user %q", from, user)
+		}
+
+		_, err = addContent(ctx, tx, to, source)
+		return err
+	})
+	if checkErr != nil {
+		return checkErr
+	}
+	if err != nil {
+		if ErrNotFound.Has(err) {
+			return err
+		}
 		return Error.Wrap(err)
 	}
 
-	affected, err := result.RowsAffected()
+	return nil
+}
+
+// ListHashes returns all hashes in the content table. The value of each hash
+// is true if at least one user has it active (not removed).
+func (db *DB) ListHashes(ctx context.Context) (result map[string]bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT hash, bool_or(removed IS NULL)
+		FROM content
+		GROUP BY hash
+	`)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	result = make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		var active bool
+		err := rows.Scan(&hash, &active)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result[hash] = active
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// ListHashesChangedSince returns the hashes that a user added or removed
+// since the given time.
+func (db *DB) ListHashesChangedSince(ctx context.Context, since time.Time) (result map[string]bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// The timestamps in the database are in UTC without time zone.
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT hash
+		FROM content_intervals
+		WHERE
+			created >= $1 OR
+			removed >= $1
+	`, since.UTC())
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	result = make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		err := rows.Scan(&hash)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result[hash] = true
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// RemoveContentByHash updates the remove column for all content that matches hashes, regardless of user.
+//
+// A remove event, not made by a request, is recorded for each removed content.
+func (db *DB) RemoveContentByHash(ctx context.Context, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var affected int64
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE content
+			SET
+				removed = NOW()
+			WHERE
+				hash = ANY($1) AND
+				removed IS NULL
+			RETURNING username, hash, size, name;
+		`, pgutil.TextArray(hashes))
+		if err != nil {
+			return err
+		}
+
+		events, err := scanRemoveEvents(rows, EventSource{})
+		if err != nil {
+			return err
+		}
+
+		affected = int64(len(events))
+		for _, event := range events {
+			err = insertContentEvent(ctx, tx, event)
+			if err != nil {
+				return err
+			}
+		}
+
+		return closeContentIntervals(ctx, tx, hashes)
+	})
 	if err != nil {
 		return Error.Wrap(err)
 	}
 
-	mon.Counter("remove_content_by_hash_for_user_db_affected_rows", monkit.NewSeriesTag("rows", strconv.FormatInt(affected, 10))).Inc(1)
+	mon.Counter("remove_content_by_hash_db_affected_rows", monkit.NewSeriesTag("rows", strconv.FormatInt(affected, 10))).Inc(1)
 
 	return nil
 }
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/private/dbutil/txutil"
+	"storj.io/private/tagsql"
+)
+
+// UserDeletion is the summary of the deletion of a user.
+type UserDeletion struct {
+	// User is the deleted user.
+	User string
+
+	// Removed is the number of active content records of the user that were
+	// removed.
+	Removed int
+
+	// Exclusive is the content that no other user has active. It is queued
+	// for unpinning from the IPFS node.
+	Exclusive []Content
+
+	// BytesFreed is the total size of the exclusive content.
+	BytesFreed int64
+
+	// Keys is the number of IPNS keys of the user that were removed.
+	Keys int
+
+	// Organizations are the organizations that the user was a member of.
+	Organizations []string
+
+	// Admin is whether the user had the admin role in the database.
+	Admin bool
+
+	// PinRequests is the number of pin requests of the user that were
+	// deleted, so the pin worker does not map their content to the user.
+	PinRequests int
+
+	// Quota is whether the user had a quota.
+	Quota bool
+
+	// Cleanup is the state of the user on the IPFS node, the MFS root and the
+	// keys, queued for removal.
+	Cleanup []QueuedCleanup
+}
+
+// ListExclusiveContentByUser returns the active content records of user whose
+// hash no other user has active, ordered by hash.
+func (db *DB) ListExclusiveContentByUser(ctx context.Context, user string) (result []Content, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, exclusiveContentQuery, user)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	result, err = scanContent(rows)
+	return result, Error.Wrap(err)
+}
+
+const exclusiveContentQuery = `
+	SELECT username, created, removed, hash, name, size
+	FROM content AS c
+	WHERE
+		username = $1 AND
+		removed IS NULL AND
+		NOT EXISTS (
+			SELECT 1
+			FROM content AS o
+			WHERE
+				o.hash = c.hash AND
+				o.username <> c.username AND
+				o.removed IS NULL
+		)
+	ORDER BY hash
+`
+
+// RemoveAllContentByUser removes all active content of user with the remove
+// events of source, and queues the exclusive content, which no other user has
+// active, for unpinning, in a single transaction. It returns the number of
+// removed content records and the exclusive content.
+func (db *DB) RemoveAllContentByUser(ctx context.Context, user string, source EventSource) (removed int, exclusive []Content, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		removed, exclusive, err = removeAllContent(ctx, tx, user, source, false)
+		return err
+	})
+	if err != nil {
+		return 0, nil, Error.Wrap(err)
+	}
+
+	return removed, exclusive, nil
+}
+
+// DeleteUser removes all active content of user, queues the exclusive content
+// of the user for unpinning, and deletes the user's password, API keys, IPNS
+// keys, admin role, organization memberships, pin requests and quota, in a
+// single transaction. The MFS root and the IPNS keys of the user on the IPFS
+// node are queued for cleanup. The remove events are recorded with source.
+//
+// If dryRun is true, nothing is changed, but the returned summary is the same.
+func (db *DB) DeleteUser(ctx context.Context, user string, source EventSource, dryRun bool) (deletion UserDeletion, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		deletion = UserDeletion{User: user}
+
+		deletion.Removed, deletion.Exclusive, err = removeAllContent(ctx, tx, user, source, dryRun)
+		if err != nil {
+			return err
+		}
+		for _, content := range deletion.Exclusive {
+			deletion.BytesFreed += content.Size
+		}
+
+		deletion.Cleanup = append(deletion.Cleanup, QueuedCleanup{Kind: CleanupMFSRoot, Name: user})
+		nodeNames, err := queryStrings(ctx, tx, `
+			SELECT node_name FROM ipns_keys WHERE username = $1 ORDER BY name
+		`, user)
+		if err != nil {
+			return err
+		}
+		deletion.Keys = len(nodeNames)
+		for _, nodeName := range nodeNames {
+			deletion.Cleanup = append(deletion.Cleanup, QueuedCleanup{Kind: CleanupKey, Name: nodeName})
+		}
+
+		deletion.Organizations, err = queryStrings(ctx, tx, `
+			SELECT organization FROM memberships WHERE username = $1 ORDER BY organization
+		`, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM admins WHERE username = $1)
+		`, user).Scan(&deletion.Admin)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM pin_requests WHERE username = $1
+		`, user).Scan(&deletion.PinRequests)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM quotas WHERE username = $1)
+		`, user).Scan(&deletion.Quota)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM ipns_keys WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM memberships WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM admins WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM pin_requests WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM quotas WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE username = $1`, user)
+		if err != nil {
+			return err
+		}
+
+		return queueCleanups(ctx, tx, deletion.Cleanup)
+	})
+	if err != nil {
+		return UserDeletion{}, Error.Wrap(err)
+	}
+
+	return deletion, nil
+}
+
+// removeAllContent removes all active content of owner in tx, unless dryRun,
+// with the remove events of source. It returns the number of removed content
+// records and the exclusive content, which no other user has active. The
+// exclusive hashes are queued for unpinning while removing.
+func removeAllContent(ctx context.Context, tx tagsql.Tx, owner string, source EventSource, dryRun bool) (removed int, exclusive []Content, err error) {
+	rows, err := tx.QueryContext(ctx, exclusiveContentQuery, owner)
+	if err != nil {
+		return 0, nil, err
+	}
+	exclusive, err = scanContent(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hashes, err := queryStrings(ctx, tx, `
+		SELECT hash
+		FROM content
+		WHERE
+			username = $1 AND
+			removed IS NULL
+	`, owner)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !dryRun {
+		_, err = removeContentForUser(ctx, tx, owner, hashes, source)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return len(hashes), exclusive, nil
+}
+
+// scanContent reads content records from rows of username, created, removed,
+// hash, name and size, and closes rows.
+func scanContent(rows tagsql.Rows) (result []Content, err error) {
+	defer func() { err = errs.Combine(err, rows.Close()) }()
+
+	for rows.Next() {
+		var content Content
+		err := rows.Scan(&content.User, &content.Created, &content.Removed, &content.Hash, &content.Name, &content.Size)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, content)
+	}
+
+	return result, rows.Err()
+}
+
+// queryStrings returns the single text column of the rows of query in tx.
+func queryStrings(ctx context.Context, tx tagsql.Tx, query string, args ...interface{}) (result []string, err error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, rows.Close()) }()
+
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/private/tagsql"
+)
+
+// Content event types.
+const (
+	// ContentEventAdd is recorded when a user adds a content for the first time.
+	ContentEventAdd = "add"
+	// ContentEventReAdd is recorded when a user adds a removed content again.
+	ContentEventReAdd = "readd"
+	// ContentEventRemove is recorded when a content is removed for a user.
+	ContentEventRemove = "remove"
+)
+
+// ContentEvent represents a change of a content record in the database.
+type ContentEvent struct {
+	// User is the user whose content changed.
+	User string
+
+	// Hash is the IPFS hash of the content.
+	Hash string
+
+	// Type is the type of the change: add, readd or remove.
+	Type string
+
+	// Size is the size in bytes of the content.
+	Size int64
+
+	// Name is the name associated with the content.
+	Name string
+
+	// Created is when the change happened.
+	Created time.Time
+
+	// RequestID is the ID of the request that made the change. Empty if the
+	// change was not made by a request.
+	RequestID string
+
+	// Actor is the user who made the change, like the member of an
+	// organization that owns the content. Empty if the change was not made
+	// by a request.
+	Actor string
+}
+
+// EventSource is the origin of a content change, recorded with its content
+// events. The zero value is a change that was not made by a request.
+type EventSource struct {
+	// RequestID is the ID of the request that made the change.
+	RequestID string
+
+	// Actor is the user who made the change, like the member of an
+	// organization that owns the content.
+	Actor string
+}
+
+// insertContentEvent appends event to the content events.
+//
+// The event's created time is ignored as it is automatically set by the database.
+func insertContentEvent(ctx context.Context, tx tagsql.Tx, event ContentEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO content_events (username, hash, type, size, name, request_id, actor)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, event.User, event.Hash, event.Type, event.Size, event.Name, event.RequestID, event.Actor)
+	return err
+}
+
+// scanRemoveEvents reads remove events of source from rows of username,
+// hash, size and name, and closes rows.
+func scanRemoveEvents(rows tagsql.Rows, source EventSource) (events []ContentEvent, err error) {
+	defer func() { err = errs.Combine(err, rows.Close()) }()
+
+	for rows.Next() {
+		event := ContentEvent{
+			Type:      ContentEventRemove,
+			RequestID: source.RequestID,
+			Actor:     source.Actor,
+		}
+		err := rows.Scan(&event.User, &event.Hash, &event.Size, &event.Name)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// ListContentEventsByUser returns all content events of user in the order
+// they happened.
+func (db *DB) ListContentEventsByUser(ctx context.Context, user string) (result []ContentEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, hash, type, size, name, created, request_id, actor
+		FROM content_events
+		WHERE username = $1
+		ORDER BY created, id
+	`, user)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return scanContentEvents(rows)
+}
+
+// ListContentEventsByHash returns all content events of hash for any user in
+// the order they happened.
+func (db *DB) ListContentEventsByHash(ctx context.Context, hash string) (result []ContentEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, hash, type, size, name, created, request_id, actor
+		FROM content_events
+		WHERE hash = $1
+		ORDER BY created, id
+	`, hash)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return scanContentEvents(rows)
+}
+
+func scanContentEvents(rows tagsql.Rows) (result []ContentEvent, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var event ContentEvent
+		err := rows.Scan(&event.User, &event.Hash, &event.Type, &event.Size, &event.Name, &event.Created, &event.RequestID, &event.Actor)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, event)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"storj.io/private/dbutil/pgutil"
+	"storj.io/private/tagsql"
+)
+
+// ContentInterval represents a period when a user had a content active.
+//
+// Unlike the content table, which keeps only the first created and the last
+// removed time, the intervals keep the full history of a content that was
+// removed and added again.
+type ContentInterval struct {
+	// User is the user who had the content.
+	User string
+
+	// Hash is the IPFS hash of the content.
+	Hash string
+
+	// Size is the size in bytes of the content.
+	Size int64
+
+	// Created is when the interval started.
+	Created time.Time
+
+	// Removed is when the interval ended. Nil if the content is still active.
+	Removed *time.Time
+}
+
+// openContentInterval starts a new interval of content unless the user
+// already has an open one.
+func openContentInterval(ctx context.Context, tx tagsql.Tx, content Content) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO content_intervals (username, hash, size, created)
+		SELECT $1, $2, $3, NOW()
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM content_intervals
+			WHERE
+				username = $1 AND
+				hash = $2 AND
+				removed IS NULL
+		)
+	`, content.User, content.Hash, content.Size)
+	return err
+}
+
+// closeContentIntervalsForUser ends the open intervals of user for hashes.
+func closeContentIntervalsForUser(ctx context.Context, tx tagsql.Tx, user string, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE content_intervals
+		SET
+			removed = NOW()
+		WHERE
+			username = $1 AND
+			hash = ANY($2) AND
+			removed IS NULL
+	`, user, pgutil.TextArray(hashes))
+	return err
+}
+
+// closeContentIntervals ends the open intervals of all users for hashes.
+func closeContentIntervals(ctx context.Context, tx tagsql.Tx, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE content_intervals
+		SET
+			removed = NOW()
+		WHERE
+			hash = ANY($1) AND
+			removed IS NULL
+	`, pgutil.TextArray(hashes))
+	return err
+}
+
+// IterateContentIntervals calls fn for each content interval that overlaps
+// the [from, to) period, ordered by user.
+func (db *DB) IterateContentIntervals(ctx context.Context, from, to time.Time, fn func(ContentInterval) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// The timestamps in the database are in UTC without time zone.
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, hash, size, created, removed
+		FROM content_intervals
+		WHERE
+			created < $2 AND
+			(removed IS NULL OR removed > $1)
+		ORDER BY username, created
+	`, from.UTC(), to.UTC())
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var interval ContentInterval
+		err := rows.Scan(&interval.User, &interval.Hash, &interval.Size, &interval.Created, &interval.Removed)
+		if err != nil {
+			return Error.Wrap(err)
+		}
+
+		err = fn(interval)
+		if err != nil {
+			return err
+		}
+	}
+
+	return Error.Wrap(rows.Err())
+}
+
+// ListContentIntervals returns all intervals of user and hash, ordered by
+// created time.
+func (db *DB) ListContentIntervals(ctx context.Context, user, hash string) (result []ContentInterval, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, hash, size, created, removed
+		FROM content_intervals
+		WHERE
+			username = $1 AND
+			hash = $2
+		ORDER BY created
+	`, user, hash)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var interval ContentInterval
+		err := rows.Scan(&interval.User, &interval.Hash, &interval.Size, &interval.Created, &interval.Removed)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, interval)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
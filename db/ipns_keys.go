@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"storj.io/private/dbutil/pgutil"
+)
+
+// IPNSKey represents an IPNS key of a user on the IPFS node.
+type IPNSKey struct {
+	// User is the user who owns the key.
+	User string
+
+	// Name is the name of the key as seen by the user.
+	Name string
+
+	// NodeName is the name of the key in the keystore of the IPFS node.
+	NodeName string
+
+	// ID is the IPNS name of the key.
+	ID string
+
+	// Created is when the key was generated.
+	Created time.Time
+}
+
+// AddIPNSKey stores the mapping of an IPNS key to its user.
+//
+// The key's created time is ignored as it is automatically set by the database.
+func (db *DB) AddIPNSKey(ctx context.Context, key IPNSKey) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO ipns_keys (username, name, node_name, id)
+		VALUES ($1, $2, $3, $4)
+	`, key.User, key.Name, key.NodeName, key.ID)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// GetIPNSKey returns the IPNS key of user with name.
+//
+// It returns an ErrNotFound error if the user has no such key.
+func (db *DB) GetIPNSKey(ctx context.Context, user, name string) (key IPNSKey, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = db.QueryRowContext(ctx, `
+		SELECT username, name, node_name, id, created
+		FROM ipns_keys
+		WHERE
+			username = $1 AND
+			name = $2
+	`, user, name).Scan(&key.User, &key.Name, &key.NodeName, &key.ID, &key.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IPNSKey{}, ErrNotFound.New("IPNS key %q", name)
+		}
+		return IPNSKey{}, Error.Wrap(err)
+	}
+
+	return key, nil
+}
+
+// ListIPNSKeys returns the IPNS keys of user ordered by name.
+func (db *DB) ListIPNSKeys(ctx context.Context, user string) (result []IPNSKey, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, name, node_name, id, created
+		FROM ipns_keys
+		WHERE username = $1
+		ORDER BY name
+	`, user)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key IPNSKey
+		err := rows.Scan(&key.User, &key.Name, &key.NodeName, &key.ID, &key.Created)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, key)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// RemoveIPNSKeys deletes the IPNS keys of user with names.
+func (db *DB) RemoveIPNSKeys(ctx context.Context, user string, names []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM ipns_keys
+		WHERE
+			username = $1 AND
+			name = ANY($2)
+	`, user, pgutil.TextArray(names))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SortOrder is the order of listed content by created time, hash and user.
+type SortOrder int
+
+const (
+	// Ascending lists the oldest content first.
+	Ascending SortOrder = iota
+	// Descending lists the newest content first.
+	Descending
+)
+
+// ContentStatus selects the content to list by whether it was removed.
+type ContentStatus int
+
+const (
+	// AnyStatus lists both active and removed content.
+	AnyStatus ContentStatus = iota
+	// ActiveStatus lists only active (not removed) content.
+	ActiveStatus
+	// RemovedStatus lists only removed content.
+	RemovedStatus
+)
+
+// MaxListLimit is the maximum number of content records returned in a page.
+const MaxListLimit = 10000
+
+// ListCursor is the position in a content listing after which the next page
+// starts. Content is listed in the order of created time, hash and user,
+// which is unique for all content.
+type ListCursor struct {
+	Created time.Time
+	Hash    string
+	User    string
+}
+
+// String encodes the cursor in an opaque form that can be passed to clients.
+func (cursor ListCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.Created.UTC().Format(time.RFC3339Nano) + " " + cursor.Hash + " " + cursor.User))
+}
+
+// ParseListCursor decodes a cursor encoded with ListCursor.String.
+func ParseListCursor(s string) (cursor ListCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, Error.New("invalid cursor: %v", err)
+	}
+
+	// The user is last as it is the only part that can contain spaces.
+	created, rest, ok := strings.Cut(string(b), " ")
+	if !ok {
+		return cursor, Error.New("invalid cursor")
+	}
+	hash, user, ok := strings.Cut(rest, " ")
+	if !ok || hash == "" || user == "" {
+		return cursor, Error.New("invalid cursor")
+	}
+
+	cursor.Created, err = time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return cursor, Error.New("invalid cursor: %v", err)
+	}
+	cursor.Hash = hash
+	cursor.User = user
+
+	return cursor, nil
+}
+
+// ListOptions are the filters, order and page of a content listing.
+type ListOptions struct {
+	// User limits the listing to the content of a user. All users if empty.
+	User string
+
+	// Status selects the content by whether it was removed.
+	Status ContentStatus
+
+	// NamePrefix limits the listing to content with a name starting with it.
+	NamePrefix string
+
+	// MinSize and MaxSize limit the listing to content with a size within
+	// the range, inclusive. Unlimited if nil.
+	MinSize *int64
+	MaxSize *int64
+
+	// CreatedAfter and CreatedBefore limit the listing to content created
+	// within the [after, before) window. Unlimited if nil.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// RemovedAfter and RemovedBefore limit the listing to content removed
+	// within the [after, before) window. Unlimited if nil.
+	RemovedAfter  *time.Time
+	RemovedBefore *time.Time
+
+	// Order is the order of the listing by created time, hash and user.
+	Order SortOrder
+
+	// Cursor is where the page starts, exclusive. From the start if nil.
+	Cursor *ListCursor
+
+	// Limit is the maximum number of content in the page, up to MaxListLimit.
+	Limit int
+}
+
+// ContentPage is a page of a content listing.
+type ContentPage struct {
+	// Content is the content in the page.
+	Content []Content
+
+	// Next is the cursor of the next page. Nil if this is the last page.
+	Next *ListCursor
+}
+
+// ListContent returns a page of the content records that match opts.
+//
+// The pages use keyset pagination over the created time, hash and user, so
+// listing a page doesn't scan the content of the previous pages.
+func (db *DB) ListContent(ctx context.Context, opts ListOptions) (page ContentPage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if opts.Limit <= 0 || opts.Limit > MaxListLimit {
+		return page, Error.New("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.User != "" {
+		conditions = append(conditions, "username = "+arg(opts.User))
+	}
+	switch opts.Status {
+	case ActiveStatus:
+		conditions = append(conditions, "removed IS NULL")
+	case RemovedStatus:
+		conditions = append(conditions, "removed IS NOT NULL")
+	}
+	if opts.NamePrefix != "" {
+		conditions = append(conditions, "name LIKE "+arg(likePrefix(opts.NamePrefix)))
+	}
+	if opts.MinSize != nil {
+		conditions = append(conditions, "size >= "+arg(*opts.MinSize))
+	}
+	if opts.MaxSize != nil {
+		conditions = append(conditions, "size <= "+arg(*opts.MaxSize))
+	}
+	if opts.CreatedAfter != nil {
+		conditions = append(conditions, "created >= "+arg(opts.CreatedAfter.UTC()))
+	}
+	if opts.CreatedBefore != nil {
+		conditions = append(conditions, "created < "+arg(opts.CreatedBefore.UTC()))
+	}
+	if opts.RemovedAfter != nil {
+		conditions = append(conditions, "removed >= "+arg(opts.RemovedAfter.UTC()))
+	}
+	if opts.RemovedBefore != nil {
+		conditions = append(conditions, "removed < "+arg(opts.RemovedBefore.UTC()))
+	}
+
+	direction, comparison := "ASC", ">"
+	if opts.Order == Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if opts.Cursor != nil {
+		conditions = append(conditions, fmt.Sprintf("(created, hash, username) %s (%s, %s, %s)",
+			comparison, arg(opts.Cursor.Created.UTC()), arg(opts.Cursor.Hash), arg(opts.Cursor.User)))
+	}
+
+	query := `
+		SELECT username, created, removed, hash, name, size
+		FROM content
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	// Read one more record to know if there is a next page.
+	query += fmt.Sprintf("ORDER BY created %[1]s, hash %[1]s, username %[1]s\nLIMIT %[2]d", direction, opts.Limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content Content
+		err := rows.Scan(&content.User, &content.Created, &content.Removed, &content.Hash, &content.Name, &content.Size)
+		if err != nil {
+			return page, Error.Wrap(err)
+		}
+		page.Content = append(page.Content, content)
+	}
+	if err := rows.Err(); err != nil {
+		return page, Error.Wrap(err)
+	}
+
+	if len(page.Content) > opts.Limit {
+		page.Content = page.Content[:opts.Limit]
+		last := page.Content[len(page.Content)-1]
+		page.Next = &ListCursor{Created: last.Created, Hash: last.Hash, User: last.User}
+	}
+
+	return page, nil
+}
+
+// likePrefix returns a LIKE pattern that matches the strings starting with
+// prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"storj.io/private/dbutil/txutil"
+	"storj.io/private/tagsql"
+)
+
+// OrganizationOwnerPrefix is the prefix of the owner of the content of an
+// organization. It cannot be part of a basic auth user name, so the owner
+// does not clash with users.
+const OrganizationOwnerPrefix = "org:"
+
+// OrganizationOwner returns the owner of the content of org. The owner is
+// used in place of a user name with the content methods, like Add,
+// ListActiveContentByUser and RemoveContentByHashForUser.
+func OrganizationOwner(org string) string {
+	return OrganizationOwnerPrefix + org
+}
+
+// IsOrganizationOwner returns whether owner is the owner of the content of
+// an organization.
+func IsOrganizationOwner(owner string) bool {
+	return strings.HasPrefix(owner, OrganizationOwnerPrefix)
+}
+
+// Organization represents an organization in the database.
+type Organization struct {
+	// Name is the name of the organization.
+	Name string
+
+	// Created is when the organization was created.
+	Created time.Time
+}
+
+// Membership represents a member of an organization in the database.
+type Membership struct {
+	// Organization is the name of the organization.
+	Organization string
+
+	// User is the member.
+	User string
+
+	// Created is when the user became a member.
+	Created time.Time
+}
+
+// OrganizationRemoval is the summary of the removal of an organization.
+type OrganizationRemoval struct {
+	// Organization is the removed organization.
+	Organization string
+
+	// Members are the users who were members of the organization.
+	Members []string
+
+	// Removed is the number of active content records of the organization
+	// that were removed.
+	Removed int
+
+	// Exclusive is the content that no other user has active. It is queued
+	// for unpinning from the IPFS node.
+	Exclusive []Content
+
+	// BytesFreed is the total size of the exclusive content.
+	BytesFreed int64
+}
+
+// AddOrganization creates an organization. It does nothing if the
+// organization already exists.
+func (db *DB) AddOrganization(ctx context.Context, org string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO organizations (name)
+		VALUES ($1)
+		ON CONFLICT (name) DO NOTHING
+	`, org)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// RemoveOrganization removes org and its memberships, removes all active
+// content of the organization and queues its exclusive content for
+// unpinning, in a single transaction. Otherwise, no member could reach the
+// content anymore. The removed content is kept in the database for the
+// billing history, and the remove events are recorded with source.
+//
+// If dryRun is true, nothing is changed, but the returned summary is the same.
+//
+// It returns an ErrNotFound error if the organization doesn't exist.
+func (db *DB) RemoveOrganization(ctx context.Context, org string, source EventSource, dryRun bool) (removal OrganizationRemoval, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		removal = OrganizationRemoval{Organization: org}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM organizations WHERE name = $1)
+		`, org).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound.New("organization %q", org)
+		}
+
+		removal.Members, err = queryStrings(ctx, tx, `
+			SELECT username FROM memberships WHERE organization = $1 ORDER BY username
+		`, org)
+		if err != nil {
+			return err
+		}
+
+		removal.Removed, removal.Exclusive, err = removeAllContent(ctx, tx, OrganizationOwner(org), source, dryRun)
+		if err != nil {
+			return err
+		}
+		for _, content := range removal.Exclusive {
+			removal.BytesFreed += content.Size
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM memberships
+			WHERE organization = $1
+		`, org)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM organizations
+			WHERE name = $1
+		`, org)
+		return err
+	})
+	if err != nil {
+		if ErrNotFound.Has(err) {
+			return OrganizationRemoval{}, err
+		}
+		return OrganizationRemoval{}, Error.Wrap(err)
+	}
+
+	return removal, nil
+}
+
+// ListOrganizations returns all organizations ordered by name.
+func (db *DB) ListOrganizations(ctx context.Context) (result []Organization, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, created
+		FROM organizations
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var org Organization
+		err := rows.Scan(&org.Name, &org.Created)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, org)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// AddMember makes user a member of org. It does nothing if the user is
+// already a member.
+//
+// It returns an ErrNotFound error if the organization doesn't exist.
+func (db *DB) AddMember(ctx context.Context, org, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO memberships (organization, username)
+		SELECT name, $2
+		FROM organizations
+		WHERE name = $1
+		ON CONFLICT (organization, username) DO NOTHING
+	`, org, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		// Either the organization doesn't exist or the user is already a
+		// member.
+		var exists bool
+		err = db.QueryRowContext(ctx, `
+			SELECT true
+			FROM organizations
+			WHERE name = $1
+		`, org).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound.New("organization %q", org)
+		}
+		if err != nil {
+			return Error.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// IsMember returns whether user is a member of org.
+func (db *DB) IsMember(ctx context.Context, org, user string) (member bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = db.QueryRowContext(ctx, `
+		SELECT true
+		FROM memberships
+		WHERE
+			organization = $1 AND
+			username = $2
+	`, org, user).Scan(&member)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, Error.Wrap(err)
+	}
+
+	return member, nil
+}
+
+// RemoveMember removes user from the members of org. The content the user
+// added for the organization stays with the organization.
+//
+// It returns an ErrNotFound error if the user is not a member.
+func (db *DB) RemoveMember(ctx context.Context, org, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM memberships
+		WHERE
+			organization = $1 AND
+			username = $2
+	`, org, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("member %q of organization %q", user, org)
+	}
+
+	return nil
+}
+
+// ListMembers returns the members of org ordered by user.
+func (db *DB) ListMembers(ctx context.Context, org string) (result []Membership, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT organization, username, created
+		FROM memberships
+		WHERE organization = $1
+		ORDER BY username
+	`, org)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return scanMemberships(rows)
+}
+
+// ListMemberships returns the memberships of user ordered by organization.
+func (db *DB) ListMemberships(ctx context.Context, user string) (result []Membership, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT organization, username, created
+		FROM memberships
+		WHERE username = $1
+		ORDER BY organization
+	`, user)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return scanMemberships(rows)
+}
+
+func scanMemberships(rows tagsql.Rows) (result []Membership, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var membership Membership
+		err := rows.Scan(&membership.Organization, &membership.User, &membership.Created)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, membership)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
@@ -0,0 +1,420 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"storj.io/private/dbutil/pgutil"
+	"storj.io/private/dbutil/txutil"
+	"storj.io/private/tagsql"
+)
+
+// Pin request statuses as defined by the IPFS Pinning Service API.
+const (
+	PinStatusQueued  = "queued"
+	PinStatusPinning = "pinning"
+	PinStatusPinned  = "pinned"
+	PinStatusFailed  = "failed"
+)
+
+// Name match strategies as defined by the IPFS Pinning Service API.
+const (
+	MatchExact    = "exact"
+	MatchIExact   = "iexact"
+	MatchPartial  = "partial"
+	MatchIPartial = "ipartial"
+)
+
+// PinRequest represents a pin request record in the database.
+type PinRequest struct {
+	// ID is the request ID.
+	ID string
+
+	// User is the user who made the request.
+	User string
+
+	// CID is the CID to be pinned.
+	CID string
+
+	// Name is the optional name of the pinned content.
+	Name string
+
+	// Status is the status of the request.
+	Status string
+
+	// Meta is the optional metadata of the request.
+	Meta map[string]string
+
+	// Replaces is the ID of the pin request of the user that this request
+	// replaces once its content is pinned. Empty if none.
+	Replaces string
+
+	// Created is when the request was made.
+	Created time.Time
+}
+
+// PinRequestFilter filters the pin requests returned by ListPinRequests.
+// Zero values do not filter.
+type PinRequestFilter struct {
+	// CIDs matches the requests for any of the CIDs.
+	CIDs []string
+
+	// Name matches the requests by name using the Match strategy.
+	Name string
+
+	// Match is the strategy to match Name. Defaults to MatchExact.
+	Match string
+
+	// Statuses matches the requests with any of the statuses.
+	Statuses []string
+
+	// Before matches the requests created before this time.
+	Before *time.Time
+
+	// After matches the requests created after this time.
+	After *time.Time
+
+	// Meta matches the requests with metadata containing all these entries.
+	Meta map[string]string
+
+	// Limit is the maximum number of returned requests.
+	Limit int
+}
+
+// AddPinRequest stores a new pin request.
+//
+// The request's created time is ignored as it is automatically set by the database.
+func (db *DB) AddPinRequest(ctx context.Context, request PinRequest) (created time.Time, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	meta, err := marshalMeta(request.Meta)
+	if err != nil {
+		return time.Time{}, Error.Wrap(err)
+	}
+
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO pin_requests (id, username, cid, name, status, meta, replaces)
+		VALUES ($1, $2, $3, $4, $5, $6::JSONB, $7)
+		RETURNING created
+	`, request.ID, request.User, request.CID, request.Name, request.Status, meta, request.Replaces).Scan(&created)
+	if err != nil {
+		return time.Time{}, Error.Wrap(err)
+	}
+
+	return created, nil
+}
+
+// GetPinRequest returns the pin request with id made by user.
+//
+// It returns an ErrNotFound error if there is no such request.
+func (db *DB) GetPinRequest(ctx context.Context, user, id string) (request PinRequest, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	row := db.QueryRowContext(ctx, `
+		SELECT id, username, cid, name, status, meta::TEXT, replaces, created
+		FROM pin_requests
+		WHERE
+			username = $1 AND
+			id = $2
+	`, user, id)
+
+	request, err = scanPinRequest(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PinRequest{}, ErrNotFound.New("pin request %q", id)
+		}
+		return PinRequest{}, Error.Wrap(err)
+	}
+
+	return request, nil
+}
+
+// UpdatePinRequestStatus sets the status of the pin request with id.
+//
+// It returns an ErrNotFound error if there is no such request, e.g. because
+// it was deleted while its content was being pinned.
+func (db *DB) UpdatePinRequestStatus(ctx context.Context, id, status string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE pin_requests
+		SET status = $2
+		WHERE id = $1
+	`, id, status)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("pin request %q", id)
+	}
+
+	return nil
+}
+
+// ClaimQueuedPinRequests sets up to limit queued pin requests to pinning and
+// returns them, oldest first. Requests that were claimed more than stale ago
+// and are still pinning are claimed again, as their pinning was interrupted.
+func (db *DB) ClaimQueuedPinRequests(ctx context.Context, limit int, stale time.Duration) (result []PinRequest, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		UPDATE pin_requests
+		SET
+			status = $1,
+			claimed = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM pin_requests
+			WHERE
+				status = $2 OR
+				(status = $1 AND (claimed IS NULL OR claimed < NOW() - $3::FLOAT8 * INTERVAL '1 second'))
+			ORDER BY created
+			LIMIT $4
+		)
+		RETURNING id, username, cid, name, status, meta::TEXT, replaces, created
+	`, PinStatusPinning, PinStatusQueued, stale.Seconds(), limit)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		request, err := scanPinRequest(rows)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, request)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result, nil
+}
+
+// RemovePinRequestContent removes the active content of user that matches
+// hash if it is mapped only by pin requests, see Content.PinRequestsOnly,
+// and the user has no pin requests for hash left. Content that the user also
+// added in other ways stays mapped. The pin requests are counted in the same
+// transaction as the content is removed.
+//
+// The remove event is recorded with source, and the hash is queued for
+// unpinning like by RemoveContentByHashForUser if no user has it active
+// anymore.
+func (db *DB) RemovePinRequestContent(ctx context.Context, user, hash string, source EventSource) (removed bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) error {
+		var pinRequestsOnly bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT pin_requests_only
+			FROM content
+			WHERE
+				username = $1 AND
+				hash = $2 AND
+				removed IS NULL
+			FOR UPDATE
+		`, user, hash).Scan(&pinRequestsOnly)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !pinRequestsOnly) {
+			removed = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var count int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM pin_requests
+			WHERE
+				username = $1 AND
+				cid = $2
+		`, user, hash).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			removed = false
+			return nil
+		}
+
+		affected, err := removeContentForUser(ctx, tx, user, []string{hash}, source)
+		removed = affected > 0
+		return err
+	})
+	if err != nil {
+		return false, Error.Wrap(err)
+	}
+
+	return removed, nil
+}
+
+// DeletePinRequest deletes the pin request with id made by user.
+//
+// It returns an ErrNotFound error if there is no such request.
+func (db *DB) DeletePinRequest(ctx context.Context, user, id string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM pin_requests
+		WHERE
+			username = $1 AND
+			id = $2
+	`, user, id)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("pin request %q", id)
+	}
+
+	return nil
+}
+
+// ListPinRequests returns the pin requests of user that match filter,
+// ordered from the newest to the oldest. It also returns the total count of
+// matching requests, which may be more than the number of returned requests.
+func (db *DB) ListPinRequests(ctx context.Context, user string, filter PinRequestFilter) (result []PinRequest, count int, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	conditions := []string{"username = $1"}
+	args := []interface{}{user}
+	addCondition := func(format string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if len(filter.CIDs) > 0 {
+		addCondition("cid = ANY($%d)", pgutil.TextArray(filter.CIDs))
+	}
+
+	if filter.Name != "" {
+		switch filter.Match {
+		case MatchExact, "":
+			addCondition("name = $%d", filter.Name)
+		case MatchIExact:
+			addCondition("LOWER(name) = LOWER($%d)", filter.Name)
+		case MatchPartial:
+			addCondition("name LIKE $%d", "%"+escapeLike(filter.Name)+"%")
+		case MatchIPartial:
+			addCondition("name ILIKE $%d", "%"+escapeLike(filter.Name)+"%")
+		default:
+			return nil, 0, Error.New("invalid match strategy: %q", filter.Match)
+		}
+	}
+
+	if len(filter.Statuses) > 0 {
+		addCondition("status = ANY($%d)", pgutil.TextArray(filter.Statuses))
+	}
+
+	if filter.Before != nil {
+		addCondition("created < $%d", *filter.Before)
+	}
+
+	if filter.After != nil {
+		addCondition("created > $%d", *filter.After)
+	}
+
+	if len(filter.Meta) > 0 {
+		meta, err := marshalMeta(filter.Meta)
+		if err != nil {
+			return nil, 0, Error.Wrap(err)
+		}
+		addCondition("meta @> $%d::JSONB", meta)
+	}
+
+	query := `
+		SELECT id, username, cid, name, status, meta::TEXT, replaces, created, COUNT(*) OVER ()
+		FROM pin_requests
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY created DESC, id`
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request PinRequest
+		var meta string
+		err := rows.Scan(&request.ID, &request.User, &request.CID, &request.Name, &request.Status, &meta, &request.Replaces, &request.Created, &count)
+		if err != nil {
+			return nil, 0, Error.Wrap(err)
+		}
+
+		request.Meta, err = unmarshalMeta(meta)
+		if err != nil {
+			return nil, 0, Error.Wrap(err)
+		}
+
+		result = append(result, request)
+	}
+
+	return result, count, Error.Wrap(rows.Err())
+}
+
+func scanPinRequest(row interface{ Scan(...interface{}) error }) (request PinRequest, err error) {
+	var meta string
+	err = row.Scan(&request.ID, &request.User, &request.CID, &request.Name, &request.Status, &meta, &request.Replaces, &request.Created)
+	if err != nil {
+		return PinRequest{}, err
+	}
+
+	request.Meta, err = unmarshalMeta(meta)
+	if err != nil {
+		return PinRequest{}, err
+	}
+
+	return request, nil
+}
+
+func marshalMeta(meta map[string]string) (string, error) {
+	if meta == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(meta)
+	return string(data), err
+}
+
+func unmarshalMeta(data string) (meta map[string]string, err error) {
+	err = json.Unmarshal([]byte(data), &meta)
+	if len(meta) == 0 {
+		meta = nil
+	}
+	return meta, err
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"storj.io/private/dbutil/pgutil"
+	"storj.io/private/dbutil/txutil"
+	"storj.io/private/tagsql"
+)
+
+// Quota represents the limits of a user in the database.
+type Quota struct {
+	// User is the user the limits apply to.
+	User string
+
+	// MaxBytes is the maximum total size in bytes of the user's active
+	// content. Nil if unlimited.
+	MaxBytes *int64
+
+	// MaxPins is the maximum number of the user's active content records.
+	// Nil if unlimited.
+	MaxPins *int64
+
+	// Updated is when the quota was last changed.
+	Updated time.Time
+}
+
+// SetQuota creates or replaces the quota of a user.
+//
+// The quota's updated time is ignored as it is automatically set by the database.
+func (db *DB) SetQuota(ctx context.Context, quota Quota) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO quotas (username, max_bytes, max_pins)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (username)
+		DO UPDATE SET
+			max_bytes = excluded.max_bytes,
+			max_pins = excluded.max_pins,
+			updated = NOW()
+	`, quota.User, quota.MaxBytes, quota.MaxPins)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// GetQuota returns the quota of user.
+//
+// It returns an ErrNotFound error if the user has no quota.
+func (db *DB) GetQuota(ctx context.Context, user string) (quota Quota, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	quota.User = user
+	err = db.QueryRowContext(ctx, `
+		SELECT max_bytes, max_pins, updated
+		FROM quotas
+		WHERE username = $1
+	`, user).Scan(&quota.MaxBytes, &quota.MaxPins, &quota.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Quota{}, ErrNotFound.New("quota for user %q", user)
+		}
+		return Quota{}, Error.Wrap(err)
+	}
+
+	return quota, nil
+}
+
+// RemoveQuota deletes the quota of user, which makes the user unlimited.
+//
+// It returns an ErrNotFound error if the user has no quota.
+func (db *DB) RemoveQuota(ctx context.Context, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM quotas
+		WHERE username = $1
+	`, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("quota for user %q", user)
+	}
+
+	return nil
+}
+
+// ListQuotas returns all quota records ordered by user.
+func (db *DB) ListQuotas(ctx context.Context) (result []Quota, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, max_bytes, max_pins, updated
+		FROM quotas
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quota Quota
+		err := rows.Scan(&quota.User, &quota.MaxBytes, &quota.MaxPins, &quota.Updated)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, quota)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// AddWithinQuota adds the contents of a single user to the database like Add,
+// with the events of source, if check accepts them.
+//
+// Check is called with the current usage of the user and the usage that the
+// contents add to it, i.e. of the contents that the user doesn't have active
+// yet. If check returns an error, no content is added and the error is
+// returned as is. A nil check accepts any contents.
+//
+// The check and the adds run in one serializable transaction, so concurrent
+// calls for the same user cannot exceed the quota together. The transaction
+// is retried on serialization failures, so check may be called more than once.
+func (db *DB) AddWithinQuota(ctx context.Context, contents []Content, source EventSource, check func(usage, added Usage) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if len(contents) == 0 {
+		return nil
+	}
+	user := contents[0].User
+
+	var checkErr error
+	err = txutil.WithTx(ctx, db.DB, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		checkErr = nil
+
+		if check != nil {
+			usage, added, err := addedUsage(ctx, tx, user, contents)
+			if err != nil {
+				return err
+			}
+
+			checkErr = check(usage, added)
+			if checkErr != nil {
+				return checkErr
+			}
+		}
+
+		for _, content := range contents {
+			_, err = addContent(ctx, tx, content, source)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if checkErr != nil {
+		return checkErr
+	}
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// addedUsage returns the current usage of user in tx and the usage that
+// contents would add to it.
+func addedUsage(ctx context.Context, tx tagsql.Tx, user string, contents []Content) (usage, added Usage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	usage.User = user
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(size), 0), COUNT(*)
+		FROM content
+		WHERE
+			username = $1 AND
+			removed IS NULL
+	`, user).Scan(&usage.Bytes, &usage.Pins)
+	if err != nil {
+		return Usage{}, Usage{}, err
+	}
+
+	hashes := make([]string, 0, len(contents))
+	for _, content := range contents {
+		hashes = append(hashes, content.Hash)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT hash
+		FROM content
+		WHERE
+			username = $1 AND
+			hash = ANY($2) AND
+			removed IS NULL
+	`, user, pgutil.TextArray(hashes))
+	if err != nil {
+		return Usage{}, Usage{}, err
+	}
+	defer rows.Close()
+
+	active := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return Usage{}, Usage{}, err
+		}
+		active[hash] = true
+	}
+	if err = rows.Err(); err != nil {
+		return Usage{}, Usage{}, err
+	}
+
+	added.User = user
+	for _, content := range contents {
+		if active[content.Hash] {
+			continue
+		}
+		// Count contents with the same hash only once.
+		active[content.Hash] = true
+		added.Bytes += content.Size
+		added.Pins++
+	}
+
+	return usage, added, nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"storj.io/private/dbutil/pgutil"
+	"storj.io/private/dbutil/txutil"
+	"storj.io/private/tagsql"
+)
+
+// QueuedUnpin represents a hash queued for unpinning from the IPFS node.
+type QueuedUnpin struct {
+	// Hash is the IPFS hash to unpin.
+	Hash string
+
+	// Created is when the hash was queued.
+	Created time.Time
+
+	// Attempts is the number of failed unpin attempts.
+	Attempts int
+
+	// NextAttempt is when the unpin should be attempted next.
+	NextAttempt time.Time
+
+	// LastError is the error of the last failed attempt.
+	LastError string
+}
+
+// queueUnpins queues hashes for unpinning from the IPFS node if no user has
+// them active anymore. Hashes already in the queue are left as they are.
+func queueUnpins(ctx context.Context, tx tagsql.Tx, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO unpin_queue (hash)
+		SELECT h
+		FROM unnest($1::TEXT[]) AS h
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM content
+			WHERE
+				hash = h AND
+				removed IS NULL
+		)
+		ON CONFLICT (hash) DO NOTHING
+	`, pgutil.TextArray(hashes))
+	return err
+}
+
+// dequeueUnpin removes hash from the unpin queue in tx, as a user has it
+// active again. It waits for UnpinQueued to finish with the hash, if running.
+func dequeueUnpin(ctx context.Context, tx tagsql.Tx, hash string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM unpin_queue
+		WHERE hash = $1
+	`, hash)
+	return err
+}
+
+// UnpinQueued calls unpin with the queued hashes that no user has active
+// and removes them from the queue if unpin succeeds. Hashes that a user has
+// active again are removed from the queue without unpinning, and hashes that
+// are not queued anymore are ignored. It returns the hashes passed to unpin.
+//
+// The queue rows of hashes are locked while unpin runs. As adding content
+// removes its hash from the queue in the same transaction, no user can add
+// the hashes between the check and the unpin.
+//
+// If unpin returns an error, the queue is left unchanged and the error is
+// returned as is.
+func (db *DB) UnpinQueued(ctx context.Context, hashes []string, unpin func(ctx context.Context, hashes []string) error) (unpinned []string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var unpinErr error
+	err = txutil.WithTx(ctx, db.DB, nil, func(ctx context.Context, tx tagsql.Tx) (err error) {
+		unpinned, unpinErr = nil, nil
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT
+				hash,
+				EXISTS (
+					SELECT 1
+					FROM content
+					WHERE
+						content.hash = unpin_queue.hash AND
+						removed IS NULL
+				)
+			FROM unpin_queue
+			WHERE hash = ANY($1)
+			ORDER BY hash
+			FOR UPDATE
+		`, pgutil.TextArray(hashes))
+		if err != nil {
+			return err
+		}
+
+		var queued []string
+		for rows.Next() {
+			var hash string
+			var active bool
+			err = rows.Scan(&hash, &active)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			queued = append(queued, hash)
+			if !active {
+				unpinned = append(unpinned, hash)
+			}
+		}
+		err = rows.Err()
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(unpinned) > 0 {
+			unpinErr = unpin(ctx, unpinned)
+			if unpinErr != nil {
+				return unpinErr
+			}
+		}
+
+		if len(queued) == 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM unpin_queue
+			WHERE hash = ANY($1)
+		`, pgutil.TextArray(queued))
+		return err
+	})
+	if unpinErr != nil {
+		return nil, unpinErr
+	}
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return unpinned, nil
+}
+
+// ListDueUnpins returns up to limit queued unpins whose next attempt is due,
+// the most overdue first.
+func (db *DB) ListDueUnpins(ctx context.Context, limit int) (result []QueuedUnpin, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT hash, created, attempts, next_attempt, last_error
+		FROM unpin_queue
+		WHERE next_attempt <= NOW()
+		ORDER BY next_attempt
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var unpin QueuedUnpin
+		err := rows.Scan(&unpin.Hash, &unpin.Created, &unpin.Attempts, &unpin.NextAttempt, &unpin.LastError)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, unpin)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
+
+// RetryQueuedUnpin records a failed unpin attempt of hash with its error and
+// postpones the next attempt by delay.
+func (db *DB) RetryQueuedUnpin(ctx context.Context, hash string, delay time.Duration, lastError string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE unpin_queue
+		SET
+			attempts = attempts + 1,
+			next_attempt = NOW() + $2::FLOAT8 * INTERVAL '1 second',
+			last_error = $3
+		WHERE hash = $1
+	`, hash, delay.Seconds(), lastError)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// UnpinQueueStats returns the number of queued unpins and the age of the
+// oldest one. The age is zero if the queue is empty.
+func (db *DB) UnpinQueueStats(ctx context.Context) (depth int64, oldest time.Duration, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var seconds float64
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created)), 0)::FLOAT8
+		FROM unpin_queue
+	`).Scan(&depth, &seconds)
+	if err != nil {
+		return 0, 0, Error.Wrap(err)
+	}
+
+	return depth, time.Duration(seconds * float64(time.Second)), nil
+}
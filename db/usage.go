@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"storj.io/private/tagsql"
+)
+
+// Usage represents the content of a user at a point in time.
+type Usage struct {
+	// User is the user the content belongs to.
+	User string
+
+	// Organization is the organization the content belongs to, if User is
+	// the owner of its content, see OrganizationOwner. Empty for users.
+	Organization string
+
+	// Bytes is the total size in bytes of the active content.
+	Bytes int64
+
+	// Pins is the number of active content records.
+	Pins int64
+
+	// Removed is the number of removed content records.
+	Removed int64
+}
+
+// GetUsage returns the current usage of user.
+func (db *DB) GetUsage(ctx context.Context, user string) (usage Usage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return db.getUsage(ctx, user, nil)
+}
+
+// GetUsageAsOf returns the usage of user as of the given time.
+//
+// The usage is calculated from the intervals of the content, so content that
+// was removed and added again counts as active only within its intervals.
+func (db *DB) GetUsageAsOf(ctx context.Context, user string, asOf time.Time) (usage Usage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return db.getUsage(ctx, user, &asOf)
+}
+
+func (db *DB) getUsage(ctx context.Context, user string, asOf *time.Time) (usage Usage, err error) {
+	usages, err := db.listUsage(ctx, &user, asOf)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if len(usages) == 0 {
+		return Usage{User: user}, nil
+	}
+
+	return usages[0], nil
+}
+
+// ListUsage returns the usage of all users as of the given time, ordered by
+// user. If asOf is nil, the current usage is returned.
+func (db *DB) ListUsage(ctx context.Context, asOf *time.Time) (result []Usage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return db.listUsage(ctx, nil, asOf)
+}
+
+// listUsage returns the usage of user, or all users if nil, as of the given
+// time, or now if nil.
+func (db *DB) listUsage(ctx context.Context, user *string, asOf *time.Time) (result []Usage, err error) {
+	var rows tagsql.Rows
+	if asOf == nil {
+		rows, err = db.QueryContext(ctx, `
+			SELECT
+				username,
+				COALESCE(SUM(size) FILTER (WHERE removed IS NULL), 0),
+				COUNT(*) FILTER (WHERE removed IS NULL),
+				COUNT(*) FILTER (WHERE removed IS NOT NULL)
+			FROM content
+			WHERE $1::TEXT IS NULL OR username = $1::TEXT
+			GROUP BY username
+			ORDER BY username
+		`, user)
+	} else {
+		// The timestamps in the database are in UTC without time zone.
+		rows, err = db.QueryContext(ctx, `
+			SELECT
+				username,
+				COALESCE(SUM(size) FILTER (WHERE active), 0),
+				COUNT(*) FILTER (WHERE active),
+				COUNT(*) FILTER (WHERE NOT active)
+			FROM (
+				SELECT
+					username,
+					MAX(size) FILTER (WHERE removed IS NULL OR removed > $1) AS size,
+					bool_or(removed IS NULL OR removed > $1) AS active
+				FROM content_intervals
+				WHERE
+					created <= $1 AND
+					($2::TEXT IS NULL OR username = $2::TEXT)
+				GROUP BY username, hash
+			) AS hashes
+			GROUP BY username
+			ORDER BY username
+		`, asOf.UTC(), user)
+	}
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var usage Usage
+		err := rows.Scan(&usage.User, &usage.Bytes, &usage.Pins, &usage.Removed)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		if IsOrganizationOwner(usage.User) {
+			usage.Organization = strings.TrimPrefix(usage.User, OrganizationOwnerPrefix)
+		}
+		result = append(result, usage)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// User represents a user record in the database.
+type User struct {
+	// Name is the user name.
+	Name string
+
+	// Created is when the user was created.
+	Created time.Time
+}
+
+// SetUserPassword creates the user if it does not exist and sets its password hash.
+func (db *DB) SetUserPassword(ctx context.Context, user, passwordHash string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (username, password_hash)
+		VALUES ($1, $2)
+		ON CONFLICT (username)
+		DO UPDATE SET password_hash = excluded.password_hash
+	`, user, passwordHash)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// GetUserPasswordHash returns the password hash of user.
+//
+// It returns an ErrNotFound error if the user does not exist.
+func (db *DB) GetUserPasswordHash(ctx context.Context, user string) (passwordHash string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = db.QueryRowContext(ctx, `
+		SELECT password_hash
+		FROM users
+		WHERE username = $1
+	`, user).Scan(&passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound.New("user %q", user)
+		}
+		return "", Error.Wrap(err)
+	}
+
+	return passwordHash, nil
+}
+
+// RemoveUser deletes user from the users table.
+//
+// The content records of the user are not affected. It returns an
+// ErrNotFound error if the user does not exist.
+func (db *DB) RemoveUser(ctx context.Context, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM users
+		WHERE username = $1
+	`, user)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNotFound.New("user %q", user)
+	}
+
+	return nil
+}
+
+// ListUsers returns all user records ordered by name.
+func (db *DB) ListUsers(ctx context.Context) (result []User, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT username, created
+		FROM users
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Name, &user.Created)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		result = append(result, user)
+	}
+
+	return result, Error.Wrap(rows.Err())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	deleteUserCmd = &cobra.Command{
+		Use:   "delete-user <user>",
+		Short: "Remove all content of a user, unpin the content no other user has, and delete the user's credentials",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdDeleteUser,
+	}
+
+	deleteUserConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		DryRun      bool   `help:"only report what would be deleted" default:"false"`
+		Output      string `help:"output format: table, csv or json" default:"table"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(deleteUserCmd)
+	process.Bind(deleteUserCmd, &deleteUserConfig)
+}
+
+func cmdDeleteUser(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	switch deleteUserConfig.Output {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unsupported output format: %q", deleteUserConfig.Output)
+	}
+
+	proxyDB, err := openDB(ctx, deleteUserConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = proxyDB.Close() }()
+
+	deletion, err := proxyDB.DeleteUser(ctx, args[0], db.EventSource{}, deleteUserConfig.DryRun)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+
+	return printUserDeletion(deletion, deleteUserConfig.DryRun, deleteUserConfig.Output)
+}
+
+// printUserDeletion prints the exclusive content of the deleted user,
+// which is unpinned from the IPFS node, and the summary of the deletion.
+func printUserDeletion(deletion db.UserDeletion, dryRun bool, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if deletion.Exclusive == nil {
+			deletion.Exclusive = []db.Content{}
+		}
+		if deletion.Organizations == nil {
+			deletion.Organizations = []string{}
+		}
+		return enc.Encode(struct {
+			db.UserDeletion
+			DryRun bool
+		}{deletion, dryRun})
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"hash", "name", "size"})
+		for _, content := range deletion.Exclusive {
+			_ = w.Write([]string{
+				content.Hash,
+				content.Name,
+				strconv.FormatInt(content.Size, 10),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "HASH\tNAME\tSIZE")
+		for _, content := range deletion.Exclusive {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", content.Hash, content.Name, content.Size)
+		}
+		err := w.Flush()
+		if err != nil {
+			return err
+		}
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("\n%s %d pins of %s, unpinning %d not pinned by other users and freeing %d bytes.\n",
+			verb, deletion.Removed, deletion.User, len(deletion.Exclusive), deletion.BytesFreed)
+		fmt.Printf("%s %d IPNS keys and the MFS root from the IPFS node, memberships of %d organizations, admin role: %t.\n",
+			verb, deletion.Keys, len(deletion.Organizations), deletion.Admin)
+		fmt.Printf("%s %d pin requests, quota: %t.\n", verb, deletion.PinRequests, deletion.Quota)
+		return nil
+	}
+}
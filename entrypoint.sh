@@ -12,4 +12,32 @@ if [ ! -z $PROXY_DEBUG_ADDR ] ; then
   debug_addr_flag="--debug.addr $PROXY_DEBUG_ADDR"
 fi
 
-exec ./ipfs-user-mapping-proxy run --address :${PROXY_PORT} --target $PROXY_TARGET --database-url $PROXY_DATABASE_URL $log_file_flag --log.level $PROXY_LOG_LEVEL $debug_addr_flag
+if [ ! -z $PROXY_DRAIN_TIMEOUT ] ; then
+  drain_timeout_flag="--drain-timeout $PROXY_DRAIN_TIMEOUT"
+fi
+
+if [ ! -z $PROXY_READ_POLICY ] ; then
+  read_policy_flag="--read-policy $PROXY_READ_POLICY"
+fi
+
+if [ ! -z $PROXY_PASSTHROUGH ] ; then
+  passthrough_flag="--passthrough $PROXY_PASSTHROUGH"
+fi
+
+if [ ! -z $PROXY_ADMINS ] ; then
+  admins_flag="--admins $PROXY_ADMINS"
+fi
+
+if [ ! -z $PROXY_TRUSTED_HEADER ] ; then
+  trusted_header_flag="--auth.trusted-header $PROXY_TRUSTED_HEADER --auth.trusted-proxies $PROXY_TRUSTED_PROXIES"
+fi
+
+if [ ! -z $PROXY_TLS_CERT ] ; then
+  tls_flag="--tls.cert-file $PROXY_TLS_CERT --tls.key-file $PROXY_TLS_KEY"
+fi
+
+if [ ! -z $PROXY_TLS_CLIENT_CA ] ; then
+  tls_client_ca_flag="--tls.client-ca $PROXY_TLS_CLIENT_CA"
+fi
+
+exec ./ipfs-user-mapping-proxy run --address :${PROXY_PORT} --target $PROXY_TARGET --database-url $PROXY_DATABASE_URL $log_file_flag --log.level $PROXY_LOG_LEVEL $debug_addr_flag $drain_timeout_flag $read_policy_flag $passthrough_flag $admins_flag $trusted_header_flag $tls_flag $tls_client_ca_flag
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	eventsCmd = &cobra.Command{
+		Use:   "events",
+		Short: "Show the timeline of content events for a user or a hash",
+		Args:  cobra.NoArgs,
+		RunE:  cmdEvents,
+	}
+
+	eventsConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		User        string `help:"user to show the events of" default:""`
+		Hash        string `help:"hash to show the events of" default:""`
+		Output      string `help:"output format: table, csv or json" default:"table"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	process.Bind(eventsCmd, &eventsConfig)
+}
+
+func cmdEvents(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if (eventsConfig.User == "") == (eventsConfig.Hash == "") {
+		return errors.New("exactly one of --user or --hash must be provided")
+	}
+
+	switch eventsConfig.Output {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unsupported output format: %q", eventsConfig.Output)
+	}
+
+	db, err := openDB(ctx, eventsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if eventsConfig.User != "" {
+		events, err := db.ListContentEventsByUser(ctx, eventsConfig.User)
+		if err != nil {
+			return fmt.Errorf("failed to list events: %v", err)
+		}
+		return printEvents(events, eventsConfig.Output)
+	}
+
+	events, err := db.ListContentEventsByHash(ctx, eventsConfig.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to list events: %v", err)
+	}
+	return printEvents(events, eventsConfig.Output)
+}
+
+func printEvents(events []db.ContentEvent, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if events == nil {
+			events = []db.ContentEvent{}
+		}
+		return enc.Encode(events)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"time", "user", "hash", "type", "size", "name", "request_id", "actor"})
+		for _, event := range events {
+			_ = w.Write([]string{
+				event.Created.Format(time.RFC3339),
+				event.User,
+				event.Hash,
+				event.Type,
+				strconv.FormatInt(event.Size, 10),
+				event.Name,
+				event.RequestID,
+				event.Actor,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tUSER\tHASH\tTYPE\tSIZE\tNAME\tREQUEST ID\tACTOR")
+		for _, event := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				event.Created.Format(time.RFC3339), event.User, event.Hash, event.Type, event.Size, event.Name, event.RequestID, event.Actor)
+		}
+		return w.Flush()
+	}
+}
@@ -9,7 +9,9 @@ require (
 	github.com/stretchr/testify v1.8.1
 	github.com/zeebo/errs v1.3.0
 	go.uber.org/zap v1.14.1
+	golang.org/x/crypto v0.9.0
 	golang.org/x/text v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 	storj.io/common v0.0.0-20230602145716-d6ea82d58b3d
 	storj.io/private v0.0.0-20230614131149-2ffd1635adea
 )
@@ -52,13 +54,11 @@ require (
 	github.com/zeebo/structs v1.0.3-0.20230601144555-f2db46069602 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
 	go.uber.org/multierr v1.5.0 // indirect
-	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	gopkg.in/ini.v1 v1.51.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	storj.io/drpc v0.0.33 // indirect
 	storj.io/monkit-jaeger v0.0.0-20220915074555-d100d7589f41 // indirect
 )
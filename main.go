@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/url"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
+	"storj.io/ipfs-user-mapping-proxy/auth"
 	"storj.io/ipfs-user-mapping-proxy/db"
 	"storj.io/ipfs-user-mapping-proxy/proxy"
 	"storj.io/private/process"
@@ -26,9 +30,40 @@ var (
 	}
 
 	config struct {
-		Address     string `help:"address to listen for incoming requests"`
-		Target      string `help:"target url of the IPFS HTTP API to redirect the incoming requests"`
-		DatabaseURL string `help:"database url to store user to content mappings"`
+		Address      string        `help:"address to listen for incoming requests"`
+		Target       string        `help:"target url of the IPFS HTTP API to redirect the incoming requests"`
+		DatabaseURL  string        `help:"database url to store user to content mappings"`
+		DrainTimeout time.Duration `help:"time to wait for in-flight requests to finish on shutdown" default:"30s"`
+		ReadPolicy   string        `help:"who can read content with cat, get, ls and dag/export: public or owned" default:"owned"`
+		Passthrough  string        `help:"path to a YAML or JSON policy file for the API endpoints that are not intercepted, reloaded on SIGHUP" default:""`
+		Admins       []string      `help:"users with the admin role, in addition to the admins in the database" default:""`
+		TLS          struct {
+			CertFile          string        `help:"path to the PEM certificate file to serve HTTPS, reloaded when it changes" default:""`
+			KeyFile           string        `help:"path to the PEM key file of the certificate" default:""`
+			ClientCA          string        `help:"path to a PEM file with the CAs of the client certificates that authenticate users" default:""`
+			RequireClientCert bool          `help:"reject clients without a valid client certificate" default:"false"`
+			ClientURIPrefix   string        `help:"take the user from the SAN URI of the client certificate with this prefix instead of its common name" default:""`
+			ReloadInterval    time.Duration `help:"interval to check the TLS files for changes" default:"10s"`
+		}
+		Auth struct {
+			Htpasswd       string   `help:"path to an htpasswd file with bcrypt or argon2 hashes to verify basic auth passwords against" default:""`
+			Database       bool     `help:"verify basic auth passwords against the users table in the database" default:"false"`
+			TrustedHeader  string   `help:"header with the user name authenticated by a trusted proxy, like X-Authenticated-User" default:""`
+			TrustedProxies []string `help:"CIDRs of the trusted proxies that may set the trusted header" default:""`
+			JWT            struct {
+				JWKS struct {
+					File string `help:"path to a JWKS file with the RS256, ES256 or EdDSA public keys to verify JWT bearer tokens" default:""`
+					URL  string `help:"URL of a JWKS with the RS256, ES256 or EdDSA public keys to verify JWT bearer tokens" default:""`
+				}
+				HMACSecret      string        `help:"secret to verify HS256, HS384 or HS512 JWT bearer tokens" default:""`
+				Audience        string        `help:"audience that JWTs must be issued for" default:""`
+				UserClaim       string        `help:"JWT claim with the user name" default:"sub"`
+				ScopeClaim      string        `help:"JWT claim with the scopes of the token, unrestricted if missing" default:"scope"`
+				QuotaBytesClaim string        `help:"JWT claim with the storage quota of the user in bytes, overriding the quota in the database" default:"quota_bytes"`
+				QuotaPinsClaim  string        `help:"JWT claim with the pin quota of the user, overriding the quota in the database" default:"quota_pins"`
+				Leeway          time.Duration `help:"allowed clock skew when validating the exp and nbf claims of JWTs" default:"1m"`
+			}
+		}
 	}
 )
 
@@ -42,7 +77,8 @@ func main() {
 }
 
 func cmdRun(cmd *cobra.Command, args []string) error {
-	ctx := cmd.Context()
+	ctx, cancel := process.Ctx(cmd)
+	defer cancel()
 
 	logger, _, err := process.NewLogger(rootCmd.Use)
 	if err != nil {
@@ -70,10 +106,96 @@ func cmdRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to migrate database schema: %v", err)
 	}
 
-	err = proxy.New(logger, db, config.Address, target).Run(ctx)
+	var verifiers auth.Verifiers
+	if config.Auth.Htpasswd != "" {
+		htpasswd, err := auth.LoadHtpasswd(config.Auth.Htpasswd)
+		if err != nil {
+			logger.Fatal("Failed to load htpasswd file", zap.Error(err))
+			return fmt.Errorf("failed to load htpasswd file: %v", err)
+		}
+		verifiers = append(verifiers, htpasswd)
+	}
+	if config.Auth.Database {
+		verifiers = append(verifiers, auth.NewDBVerifier(db))
+	}
+
+	var jwtVerifier *auth.JWTVerifier
+	if config.Auth.JWT.JWKS.File != "" || config.Auth.JWT.JWKS.URL != "" || config.Auth.JWT.HMACSecret != "" {
+		jwtVerifier, err = auth.NewJWTVerifier(ctx, auth.JWTConfig{
+			JWKSFile:        config.Auth.JWT.JWKS.File,
+			JWKSURL:         config.Auth.JWT.JWKS.URL,
+			HMACSecret:      config.Auth.JWT.HMACSecret,
+			Audience:        config.Auth.JWT.Audience,
+			UserClaim:       config.Auth.JWT.UserClaim,
+			ScopeClaim:      config.Auth.JWT.ScopeClaim,
+			QuotaBytesClaim: config.Auth.JWT.QuotaBytesClaim,
+			QuotaPinsClaim:  config.Auth.JWT.QuotaPinsClaim,
+			Leeway:          config.Auth.JWT.Leeway,
+		})
+		if err != nil {
+			logger.Fatal("Failed to load JWT keys", zap.Error(err))
+			return fmt.Errorf("failed to load JWT keys: %v", err)
+		}
+	}
+
+	trustedProxies, err := proxy.ParseTrustedProxies(config.Auth.TrustedProxies)
+	if err != nil {
+		logger.Fatal("Failed to parse trusted proxies", zap.Error(err))
+		return fmt.Errorf("failed to parse trusted proxies: %v", err)
+	}
+
+	readPolicy, err := proxy.ParseReadPolicy(config.ReadPolicy)
+	if err != nil {
+		logger.Fatal("Failed to parse read policy", zap.Error(err))
+		return fmt.Errorf("failed to parse read policy: %v", err)
+	}
+
+	p := proxy.New(logger, db, config.Address, target).
+		WithDrainTimeout(config.DrainTimeout).
+		WithReadPolicy(readPolicy).
+		WithPassthroughPolicy(config.Passthrough).
+		WithAdmins(config.Admins)
+	if len(verifiers) > 0 {
+		p = p.WithVerifier(verifiers)
+	}
+	if jwtVerifier != nil {
+		p = p.WithJWTVerifier(jwtVerifier)
+	}
+	if config.Auth.TrustedHeader != "" {
+		p = p.WithTrustedHeader(config.Auth.TrustedHeader, trustedProxies)
+	}
+	if config.TLS.CertFile != "" || config.TLS.KeyFile != "" {
+		p = p.WithTLS(proxy.TLSConfig{
+			CertFile:          config.TLS.CertFile,
+			KeyFile:           config.TLS.KeyFile,
+			ClientCAFile:      config.TLS.ClientCA,
+			RequireClientCert: config.TLS.RequireClientCert,
+			ClientURIPrefix:   config.TLS.ClientURIPrefix,
+			ReloadInterval:    config.TLS.ReloadInterval,
+		})
+	}
+
+	err = p.Run(ctx)
 	if err != nil {
 		logger.Error("Error running proxy", zap.Error(err))
 	}
 
 	return err
 }
+
+// openDB opens the database at databaseURL and migrates it to the latest version.
+func openDB(ctx context.Context, databaseURL string) (*db.DB, error) {
+	db, err := db.Open(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	db = db.WithLog(zap.NewNop())
+
+	err = db.MigrateToLatest(ctx)
+	if err != nil {
+		return nil, errs.Combine(fmt.Errorf("failed to migrate database schema: %v", err), db.Close())
+	}
+
+	return db, nil
+}
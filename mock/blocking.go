@@ -0,0 +1,28 @@
+package mock
+
+import "net/http"
+
+// BlockingHandler is an HTTP handler that blocks every request until
+// Release is closed and then passes it to Handler. Started receives a value
+// when a request is blocked.
+type BlockingHandler struct {
+	Handler ResettableHandler
+	Started chan struct{}
+	Release chan struct{}
+}
+
+func (h *BlockingHandler) Reset() {
+	h.Handler.Reset()
+	h.Started = make(chan struct{}, 1)
+	h.Release = make(chan struct{})
+}
+
+func (h *BlockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case h.Started <- struct{}{}:
+	default:
+	}
+
+	<-h.Release
+	h.Handler.ServeHTTP(w, r)
+}
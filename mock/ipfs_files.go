@@ -0,0 +1,51 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// IPFSFilesHandler is an HTTP handler that mocks the /api/v0/files/* enpoints
+// of an IPFS Node. It records the calls and echoes the paths back: ls lists
+// one entry, stat fails with the path in the error and read returns the path
+// as the file content.
+type IPFSFilesHandler struct {
+	Calls []string
+}
+
+func (h *IPFSFilesHandler) Reset() {
+	h.Calls = nil
+}
+
+func (h *IPFSFilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	command := path.Base(r.URL.Path)
+	args := r.URL.Query()["arg"]
+	h.Calls = append(h.Calls, strings.TrimSpace(command+" "+strings.Join(args, " ")))
+
+	switch command {
+	case "ls":
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"Entries": []map[string]interface{}{{"Name": "a.txt", "Type": 0, "Size": 0, "Hash": ""}},
+		})
+		if err != nil {
+			panic(err)
+		}
+	case "stat":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"Message": fmt.Sprintf("%s: file does not exist", strings.Join(args, ", ")),
+			"Code":    0,
+			"Type":    "error",
+		})
+		if err != nil {
+			panic(err)
+		}
+	case "read":
+		_, _ = w.Write([]byte(strings.Join(args, " ")))
+	}
+}
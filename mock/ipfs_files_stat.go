@@ -0,0 +1,39 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+)
+
+// IPFSFilesStatHandler is an HTTP handler that mocks the /api/v0/files/stat
+// enpoint of an IPFS Node. It reports CumulativeSize for every path.
+type IPFSFilesStatHandler struct {
+	CumulativeSize int64
+}
+
+func (h *IPFSFilesStatHandler) Reset() {}
+
+func (h *IPFSFilesStatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	arg := r.URL.Query().Get("arg")
+	if arg == "" {
+		http.Error(w, `argument "path" is required`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(struct {
+		Hash           string
+		CumulativeSize int64
+		Type           string
+	}{
+		Hash:           path.Base(arg),
+		CumulativeSize: h.CumulativeSize,
+		Type:           "file",
+	})
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,75 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+// IPFSKeysHandler is an HTTP handler that mocks the /api/v0/key/gen,
+// /api/v0/key/rm and /api/v0/name/publish enpoints of an IPFS Node. It keeps
+// the generated keys in memory and records the published names.
+type IPFSKeysHandler struct {
+	Keys      map[string]string
+	Published map[string]string
+}
+
+func (h *IPFSKeysHandler) Reset() {
+	h.Keys = make(map[string]string)
+	h.Published = make(map[string]string)
+}
+
+func (h *IPFSKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var response interface{}
+	switch r.URL.Path {
+	case proxy.KeyGenEndpoint:
+		name := query.Get("arg")
+		if _, ok := h.Keys[name]; ok {
+			http.Error(w, "key with name '"+name+"' already exists", http.StatusInternalServerError)
+			return
+		}
+		h.Keys[name] = "k51-" + name
+		response = proxy.KeyResponseMessage{Name: name, ID: h.Keys[name]}
+	case proxy.KeyRmEndpoint:
+		var removed proxy.KeyListResponseMessage
+		for _, name := range query["arg"] {
+			id, ok := h.Keys[name]
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				err := json.NewEncoder(w).Encode(proxy.IPFSErrorMessage{
+					Message: "no key named " + name + " was found",
+					Code:    0,
+					Type:    "error",
+				})
+				if err != nil {
+					panic(err)
+				}
+				return
+			}
+			delete(h.Keys, name)
+			removed.Keys = append(removed.Keys, proxy.KeyResponseMessage{Name: name, ID: id})
+		}
+		response = removed
+	case proxy.NamePublishEndpoint:
+		id, ok := h.Keys[query.Get("key")]
+		if !ok {
+			http.Error(w, "no key by the given name was found", http.StatusInternalServerError)
+			return
+		}
+		h.Published[id] = query.Get("arg")
+		response = struct{ Name, Value string }{Name: id, Value: query.Get("arg")}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,46 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// IPFSPinAddHandler is an HTTP handler that mocks the /api/v0/pin/add enpoint of an IPFS Node.
+// With the progress argument, it streams a progress message before the pins.
+type IPFSPinAddHandler struct {
+	Invoked bool
+	Pinned  []string
+}
+
+func (h *IPFSPinAddHandler) Reset() {
+	h.Invoked = false
+	h.Pinned = nil
+}
+
+func (h *IPFSPinAddHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Invoked = true
+
+	toPin := r.URL.Query()["arg"]
+	if len(toPin) == 0 {
+		http.Error(w, `argument "ipfs-path" is required`, http.StatusBadRequest)
+		return
+	}
+
+	h.Pinned = append(h.Pinned, toPin...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	jw := json.NewEncoder(w)
+
+	if r.URL.Query().Get("progress") == "true" {
+		err := jw.Encode(struct{ Progress int }{Progress: 1})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err := jw.Encode(struct{ Pins []string }{Pins: toPin})
+	if err != nil {
+		panic(err)
+	}
+}
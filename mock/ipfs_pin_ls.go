@@ -0,0 +1,44 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+// IPFSPinLsHandler is an HTTP handler that mocks the /api/v0/pin/ls enpoint
+// of an IPFS Node. It streams Pins as recursive pins.
+type IPFSPinLsHandler struct {
+	Pins []string
+
+	// OnList, if not nil, is called before the pins are streamed.
+	OnList func()
+}
+
+func (h *IPFSPinLsHandler) Reset() {}
+
+func (h *IPFSPinLsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("stream") != "true" {
+		http.Error(w, "only stream mode is supported by the mock", http.StatusBadRequest)
+		return
+	}
+
+	if h.OnList != nil {
+		h.OnList()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	jw := json.NewEncoder(w)
+
+	for _, pin := range h.Pins {
+		err := jw.Encode(proxy.PinLsStreamMessage{
+			Cid:  pin,
+			Type: "recursive",
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
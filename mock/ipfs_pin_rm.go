@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"sort"
+	"sync"
 
 	"storj.io/ipfs-user-mapping-proxy/proxy"
 )
@@ -12,6 +13,12 @@ import (
 type IPFSPinRmHandler struct {
 	Invoked bool
 	Removed []string
+
+	// NotPinned are the hashes that the mock responds to with the error of
+	// the IPFS node for unpinning content that is not pinned.
+	NotPinned []string
+
+	mu sync.Mutex
 }
 
 func (h *IPFSPinRmHandler) Reset() {
@@ -20,6 +27,9 @@ func (h *IPFSPinRmHandler) Reset() {
 }
 
 func (h *IPFSPinRmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.Invoked = true
 
 	var toRemove []string
@@ -38,6 +48,24 @@ func (h *IPFSPinRmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, hash := range toRemove {
+		for _, notPinned := range h.NotPinned {
+			if hash == notPinned {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				err := json.NewEncoder(w).Encode(proxy.IPFSErrorMessage{
+					Message: "not pinned or pinned indirectly",
+					Code:    0,
+					Type:    "error",
+				})
+				if err != nil {
+					panic(err)
+				}
+				return
+			}
+		}
+	}
+
 	sort.Strings(toRemove)
 	h.Removed = append(h.Removed, toRemove...)
 
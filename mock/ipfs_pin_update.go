@@ -0,0 +1,47 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+// IPFSPinUpdateHandler is an HTTP handler that mocks the /api/v0/pin/update enpoint of an IPFS Node.
+type IPFSPinUpdateHandler struct {
+	Invoked bool
+	Updated [][2]string
+	// Unpinned are the old pins that were unpinned by the updates.
+	Unpinned []string
+}
+
+func (h *IPFSPinUpdateHandler) Reset() {
+	h.Invoked = false
+	h.Updated = nil
+	h.Unpinned = nil
+}
+
+func (h *IPFSPinUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Invoked = true
+
+	args := r.URL.Query()["arg"]
+	if len(args) != 2 {
+		http.Error(w, `arguments "from-path" and "to-path" are required`, http.StatusBadRequest)
+		return
+	}
+
+	h.Updated = append(h.Updated, [2]string{args[0], args[1]})
+	if r.URL.Query().Get("unpin") != "false" {
+		h.Unpinned = append(h.Unpinned, args[0])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(proxy.PinUpdateResponseMessage{
+		Pins: args,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
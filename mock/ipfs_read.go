@@ -0,0 +1,29 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// IPFSReadHandler is an HTTP handler that mocks the read endpoints of an IPFS
+// Node like /api/v0/cat. It records the requested paths and echoes them back
+// as the response body.
+type IPFSReadHandler struct {
+	Paths []string
+}
+
+func (h *IPFSReadHandler) Reset() {
+	h.Paths = nil
+}
+
+func (h *IPFSReadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()["arg"]
+	h.Paths = append(h.Paths, args...)
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := fmt.Fprint(w, strings.Join(args, "\n"))
+	if err != nil {
+		panic(err)
+	}
+}
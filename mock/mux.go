@@ -0,0 +1,24 @@
+package mock
+
+import "net/http"
+
+// ServeMux is an HTTP handler that dispatches requests to the handler
+// registered for the exact request path. It resets all registered handlers
+// on Reset.
+type ServeMux map[string]ResettableHandler
+
+func (m ServeMux) Reset() {
+	for _, handler := range m {
+		handler.Reset()
+	}
+}
+
+func (m ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := m[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
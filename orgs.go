@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	orgsCmd = &cobra.Command{
+		Use:   "orgs",
+		Short: "Manage the organizations and their members in the database",
+	}
+
+	orgsCreateCmd = &cobra.Command{
+		Use:   "create <org>",
+		Short: "Create an organization",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdOrgsCreate,
+	}
+
+	orgsRmCmd = &cobra.Command{
+		Use:   "rm <org>",
+		Short: "Remove an organization, its members and its content, and unpin the content no user has",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdOrgsRm,
+	}
+
+	orgsLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List organizations",
+		Args:  cobra.NoArgs,
+		RunE:  cmdOrgsLs,
+	}
+
+	orgsAddMemberCmd = &cobra.Command{
+		Use:   "add-member <org> <user>",
+		Short: "Make a user a member of an organization",
+		Args:  cobra.ExactArgs(2),
+		RunE:  cmdOrgsAddMember,
+	}
+
+	orgsRmMemberCmd = &cobra.Command{
+		Use:   "rm-member <org> <user>",
+		Short: "Remove a user from the members of an organization",
+		Args:  cobra.ExactArgs(2),
+		RunE:  cmdOrgsRmMember,
+	}
+
+	orgsMembersCmd = &cobra.Command{
+		Use:   "members <org>",
+		Short: "List the members of an organization",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdOrgsMembers,
+	}
+
+	orgsConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+	}
+
+	orgsRmConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		DryRun      bool   `help:"only report what would be removed" default:"false"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(orgsCmd)
+	for _, cmd := range []*cobra.Command{orgsCreateCmd, orgsLsCmd, orgsAddMemberCmd, orgsRmMemberCmd, orgsMembersCmd} {
+		orgsCmd.AddCommand(cmd)
+		process.Bind(cmd, &orgsConfig)
+	}
+	orgsCmd.AddCommand(orgsRmCmd)
+	process.Bind(orgsRmCmd, &orgsRmConfig)
+}
+
+func cmdOrgsCreate(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, orgsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.AddOrganization(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %v", err)
+	}
+
+	return nil
+}
+
+func cmdOrgsRm(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	proxyDB, err := openDB(ctx, orgsRmConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = proxyDB.Close() }()
+
+	removal, err := proxyDB.RemoveOrganization(ctx, args[0], db.EventSource{}, orgsRmConfig.DryRun)
+	if err != nil {
+		return fmt.Errorf("failed to remove organization: %v", err)
+	}
+
+	verb := "Removed"
+	if orgsRmConfig.DryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s organization %s with %d members and %d pins, unpinning %d not pinned by other users and freeing %d bytes.\n",
+		verb, removal.Organization, len(removal.Members), removal.Removed, len(removal.Exclusive), removal.BytesFreed)
+
+	return nil
+}
+
+func cmdOrgsLs(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, orgsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	orgs, err := db.ListOrganizations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list organizations: %v", err)
+	}
+
+	for _, org := range orgs {
+		fmt.Printf("%s\t%s\n", org.Name, org.Created.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func cmdOrgsAddMember(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, orgsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.AddMember(ctx, args[0], args[1])
+	if err != nil {
+		return fmt.Errorf("failed to add member: %v", err)
+	}
+
+	return nil
+}
+
+func cmdOrgsRmMember(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, orgsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.RemoveMember(ctx, args[0], args[1])
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %v", err)
+	}
+
+	return nil
+}
+
+func cmdOrgsMembers(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, orgsConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	members, err := db.ListMembers(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to list members: %v", err)
+	}
+
+	for _, member := range members {
+		fmt.Printf("%s\t%s\n", member.User, member.Created.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -5,10 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/spacemonkeygo/monkit/v3"
 	"go.uber.org/zap"
@@ -35,12 +33,8 @@ func (p *Proxy) HandleAdd(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) handleAdd(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		mon.Counter("add_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
-		p.log.Error("No basic auth in request")
-		err = errors.New("no basic auth")
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	ctx, user, err := p.authenticate(ctx, w, r, "add")
+	if err != nil {
 		return err
 	}
 
@@ -61,7 +55,13 @@ func (p *Proxy) handleAdd(ctx context.Context, w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	wrapper := NewResponseWriterWrapper(w)
+	err = p.precheckQuota(ctx, w, r, user, "add")
+	if err != nil {
+		return err
+	}
+
+	var messages addResponseMessages
+	wrapper := NewResponseWriterWrapper(w, messages.collect)
 	p.proxy.ServeHTTP(wrapper, r)
 
 	code := wrapper.StatusCode
@@ -78,65 +78,56 @@ func (p *Proxy) handleAdd(ctx context.Context, w http.ResponseWriter, r *http.Re
 		return fmt.Errorf("Proxy error (code %d)", code)
 	}
 
-	decoder := json.NewDecoder(strings.NewReader(string(wrapper.Body)))
-	var messages []AddResponseMessage
-	for {
-		var msg AddResponseMessage
-		err := decoder.Decode(&msg)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			mon.Counter("add_handler_error_unmarshal_response").Inc(1)
-			p.log.Error("JSON response unmarshal error",
-				zap.String("User", user),
-				zap.ByteString("Body", wrapper.Body),
-				zap.Error(err))
-			return err
-		}
-		messages = append(messages, msg)
+	err = wrapper.Finish()
+	if err != nil {
+		mon.Counter("add_handler_error_unmarshal_response").Inc(1)
+		p.log.Error("JSON response unmarshal error",
+			zap.String("User", user),
+			zap.Error(err))
+		return err
 	}
 
-	if len(messages) == 0 {
+	if messages.count == 0 {
 		mon.Counter("add_handler_error_no_response_message").Inc(1)
 		p.log.Error("No response message",
-			zap.String("User", user),
-			zap.ByteString("Body", wrapper.Body),
-			zap.Error(err))
+			zap.String("User", user))
 		return errors.New("no response message")
 	}
 
-	name := messages[len(messages)-1].Name
+	last := messages.last[1]
+	name := last.Name
 	if WrapWithDirectory(r) {
-		if len(messages) == 1 {
+		if messages.count == 1 {
 			mon.Counter("add_handler_error_only_one_response_message_wrap_with_directory").Inc(1)
 			p.log.Error("Only one response message for wrap-with-directory",
 				zap.String("User", user),
-				zap.ByteString("Body", wrapper.Body),
-				zap.Error(err))
+				zap.String("Hash", last.Hash))
 			return errors.New("only one response message for wrap-with-directory")
 		}
-		name = messages[len(messages)-2].Name + " (wrapped)"
+		name = messages.last[0].Name + " (wrapped)"
 	}
 
-	hash := messages[len(messages)-1].Hash
+	hash := last.Hash
 
-	size, err := strconv.ParseInt(messages[len(messages)-1].Size, 10, 64)
+	size, err := strconv.ParseInt(last.Size, 10, 64)
 	if err != nil {
 		mon.Counter("add_handler_error_parse_size").Inc(1)
 		p.log.Error("Size parse error",
 			zap.String("User", user),
-			zap.String("Size", messages[len(messages)-1].Size), zap.Error(err))
+			zap.String("Size", last.Size), zap.Error(err))
 		return err
 	}
 
-	err = p.db.Add(ctx, db.Content{
+	err = p.addWithinQuota(ctx, wrapper, "add", []db.Content{{
 		User: user,
 		Hash: hash,
 		Name: name,
 		Size: size,
-	})
+	}}, eventSource(ctx))
 	if err != nil {
+		if isQuotaExceeded(err) {
+			return err
+		}
 		mon.Counter("add_handler_error_db_add").Inc(1)
 		p.log.Error("Error adding content to database",
 			zap.String("User", user),
@@ -150,6 +141,27 @@ func (p *Proxy) handleAdd(ctx context.Context, w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+// addResponseMessages keeps the last two messages of an add response, which
+// is all that is needed to find the root of the upload.
+type addResponseMessages struct {
+	// last holds the last two messages. The most recent one is last[1].
+	last  [2]AddResponseMessage
+	count int
+}
+
+func (messages *addResponseMessages) collect(data []byte) error {
+	var msg AddResponseMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return err
+	}
+
+	messages.last[0], messages.last[1] = messages.last[1], msg
+	messages.count++
+
+	return nil
+}
+
 func WrapWithDirectory(r *http.Request) bool {
 	if !r.URL.Query().Has("wrap-with-directory") {
 		return false
@@ -305,7 +305,7 @@ func TestPinRmHandle_Repin(t *testing.T) {
 		assert.WithinDuration(t, time.Now(), createdTime, 1*time.Minute)
 
 		// Mark the content as removed in the database
-		err = db.RemoveContentByHashForUser(ctx, contents[0].User, []string{contents[0].Hash})
+		err = removeContent(ctx, db, contents[0].User, contents[0].Hash)
 		require.NoError(t, err)
 
 		// Confirm the content is marked as removed.
@@ -462,6 +462,12 @@ func sortByCreated(contents []db.Content) {
 }
 
 func runTest(t *testing.T, mockHandler mock.ResettableHandler, f func(*testing.T, *testcontext.Context, *httptest.Server, *db.DB)) {
+	runTestWithConfig(t, mockHandler, nil, f)
+}
+
+// runTestWithConfig is like runTest, but calls configure to set up the proxy
+// before it starts serving requests.
+func runTestWithConfig(t *testing.T, mockHandler mock.ResettableHandler, configure func(*proxy.Proxy, *db.DB), f func(*testing.T, *testcontext.Context, *httptest.Server, *db.DB)) {
 	for _, impl := range []dbutil.Implementation{dbutil.Postgres, dbutil.Cockroach} {
 		impl := impl
 		name := cases.Title(language.English).String(impl.String())
@@ -492,7 +498,10 @@ func runTest(t *testing.T, mockHandler mock.ResettableHandler, f func(*testing.T
 			require.NoError(t, err)
 
 			proxy := proxy.New(log, db, "", ipfsServerURL)
-			tsProxy := httptest.NewServer(proxy.ServeMux())
+			if configure != nil {
+				configure(proxy, db)
+			}
+			tsProxy := httptest.NewServer(proxy.Handler())
 
 			f(t, ctx, tsProxy, db)
 		})
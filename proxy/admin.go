@@ -0,0 +1,553 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// DefaultAdminListLimit is the default number of content records listed by
+// GET /admin/users/{user}/content.
+const DefaultAdminListLimit = 1000
+
+// AdminUserMessage is the JSON object of a user returned to admin requests.
+type AdminUserMessage struct {
+	User string `json:"User"`
+	// Organization is the organization of the content if User is the owner
+	// of the content of an organization, see db.OrganizationOwner.
+	Organization string `json:"Organization,omitempty"`
+	Admin        bool   `json:"Admin"`
+	Bytes        int64  `json:"Bytes"`
+	Pins         int64  `json:"Pins"`
+	Removed      int64  `json:"Removed"`
+}
+
+// AdminContentMessage is the JSON object of a content record returned to
+// admin requests.
+type AdminContentMessage struct {
+	User    string     `json:"User"`
+	Hash    string     `json:"Hash"`
+	Name    string     `json:"Name"`
+	Size    int64      `json:"Size"`
+	Created time.Time  `json:"Created"`
+	Removed *time.Time `json:"Removed,omitempty"`
+}
+
+// AdminContentListMessage is the JSON object returned to
+// GET /admin/users/{user}/content requests.
+type AdminContentListMessage struct {
+	Content []AdminContentMessage `json:"Content"`
+	Next    string                `json:"Next,omitempty"`
+}
+
+// AdminRemoveContentMessage is the JSON object returned to
+// DELETE /admin/users/{user}/content requests.
+type AdminRemoveContentMessage struct {
+	User string `json:"User"`
+	// Removed is the number of content records removed for the user.
+	Removed int `json:"Removed"`
+	// Unpinned is the number of removed hashes that no other user has, which
+	// are unpinned from the IPFS node right away, or by the unpin queue if
+	// that fails.
+	Unpinned int `json:"Unpinned"`
+}
+
+// AdminDeleteUserMessage is the JSON object returned to
+// DELETE /admin/users/{user} requests.
+type AdminDeleteUserMessage struct {
+	User   string `json:"User"`
+	DryRun bool   `json:"DryRun"`
+	// Removed is the number of content records removed for the user.
+	Removed int `json:"Removed"`
+	// Unpinned are the removed hashes that no other user has, which are
+	// unpinned from the IPFS node.
+	Unpinned []string `json:"Unpinned"`
+	// BytesFreed is the total size of the unpinned hashes.
+	BytesFreed int64 `json:"BytesFreed"`
+	// Keys is the number of IPNS keys removed for the user, which are
+	// removed from the IPFS node with the MFS root of the user.
+	Keys int `json:"Keys"`
+	// Organizations are the organizations the user was removed from.
+	Organizations []string `json:"Organizations"`
+	// Admin is whether the admin role of the user was removed.
+	Admin bool `json:"Admin"`
+	// PinRequests is the number of pin requests removed for the user.
+	PinRequests int `json:"PinRequests"`
+	// Quota is whether the quota of the user was removed.
+	Quota bool `json:"Quota"`
+}
+
+// AdminEventMessage is the JSON object of a content event returned to admin
+// requests.
+type AdminEventMessage struct {
+	User      string    `json:"User"`
+	Type      string    `json:"Type"`
+	Size      int64     `json:"Size"`
+	Name      string    `json:"Name"`
+	Created   time.Time `json:"Created"`
+	RequestID string    `json:"RequestID,omitempty"`
+	Actor     string    `json:"Actor,omitempty"`
+}
+
+// AdminHashMessage is the JSON object returned to GET /admin/content/{hash}
+// requests.
+type AdminHashMessage struct {
+	Hash   string                `json:"Hash"`
+	Owners []AdminContentMessage `json:"Owners"`
+	Events []AdminEventMessage   `json:"Events"`
+}
+
+// WithAdmins gives the admin role to users, in addition to the admins in the
+// database.
+func (p *Proxy) WithAdmins(users []string) *Proxy {
+	p.admins = make(map[string]bool, len(users))
+	for _, user := range users {
+		p.admins[user] = true
+	}
+	return p
+}
+
+// isAdmin returns whether user has the admin role, either from the
+// configuration or from the database.
+func (p *Proxy) isAdmin(ctx context.Context, user string) (admin bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if p.admins[user] {
+		return true, nil
+	}
+
+	return p.db.IsAdmin(ctx, user)
+}
+
+// authenticateAdmin returns the authenticated user of the request if the user
+// has the admin role. The admin role is refused to basic auth credentials
+// that are not checked by a verifier.
+//
+// Otherwise, it writes an error response to w and counts the response code
+// under the response codes series of handler.
+func (p *Proxy) authenticateAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string) (user string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	user, _, err = p.authenticateUser(ctx, w, r, handler)
+	if err != nil {
+		return "", err
+	}
+
+	if !p.verifiedCredentials(ctx, r) {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusForbidden))).Inc(1)
+		p.log.Error("Admin endpoint called with unverified credentials",
+			zap.String("User", user),
+			zap.String("Endpoint", r.URL.Path))
+		err = errors.New("admin role requires verified credentials")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", err
+	}
+
+	admin, err := p.isAdmin(ctx, user)
+	if err != nil {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusInternalServerError))).Inc(1)
+		p.log.Error("Error checking admin role", zap.String("User", user), zap.Error(err))
+		http.Error(w, "error checking admin role", http.StatusInternalServerError)
+		return "", err
+	}
+
+	if !admin {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusForbidden))).Inc(1)
+		p.log.Error("Non-admin user calling admin endpoint",
+			zap.String("User", user),
+			zap.String("Endpoint", r.URL.Path))
+		err = errors.New("admin role required")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", err
+	}
+
+	return user, nil
+}
+
+// HandleAdminUsers is an HTTP handler that serves the GET /admin/users
+// endpoint.
+//
+// It responds with all users that have content or a password in the
+// database, with their usage and whether they are admins.
+func (p *Proxy) HandleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleAdminUsers(r.Context(), w, r)
+}
+
+// HandleAdminUser is an HTTP handler that serves the /admin/users/{user} and
+// /admin/users/{user}/content endpoints.
+//
+// DELETE /admin/users/{user} deletes the user with all its content, see
+// db.DeleteUser. With the dry-run argument, it only reports what would be
+// deleted.
+//
+// GET /admin/users/{user}/content lists the content of the user, active and
+// removed. The optional status argument (active, removed or any), and the
+// limit and cursor arguments page through the list.
+//
+// DELETE /admin/users/{user}/content removes all active content of the user.
+// The hashes that no other user has are queued for unpinning from the IPFS
+// node.
+func (p *Proxy) HandleAdminUser(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleAdminUser(r.Context(), w, r)
+}
+
+// HandleAdminContent is an HTTP handler that serves the
+// GET /admin/content/{hash} endpoint.
+//
+// It responds with all users that have or had the hash, and the history of
+// its content events.
+func (p *Proxy) HandleAdminContent(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleAdminContent(r.Context(), w, r)
+}
+
+func (p *Proxy) handleAdminUsers(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = p.authenticateAdmin(ctx, w, r, "admin_users")
+	if err != nil {
+		return err
+	}
+
+	if r.Method != http.MethodGet {
+		return writeAdminError(w, "admin_users", http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+
+	usages, err := p.db.ListUsage(ctx, nil)
+	if err != nil {
+		mon.Counter("admin_users_handler_error_db_list_usage").Inc(1)
+		return writeAdminError(w, "admin_users", http.StatusInternalServerError, err)
+	}
+
+	users, err := p.db.ListUsers(ctx)
+	if err != nil {
+		mon.Counter("admin_users_handler_error_db_list_users").Inc(1)
+		return writeAdminError(w, "admin_users", http.StatusInternalServerError, err)
+	}
+
+	admins, err := p.db.ListAdmins(ctx)
+	if err != nil {
+		mon.Counter("admin_users_handler_error_db_list_admins").Inc(1)
+		return writeAdminError(w, "admin_users", http.StatusInternalServerError, err)
+	}
+
+	messages := make(map[string]*AdminUserMessage)
+	message := func(user string) *AdminUserMessage {
+		if messages[user] == nil {
+			messages[user] = &AdminUserMessage{User: user, Admin: p.admins[user]}
+		}
+		return messages[user]
+	}
+
+	for _, usage := range usages {
+		msg := message(usage.User)
+		msg.Organization = usage.Organization
+		msg.Bytes = usage.Bytes
+		msg.Pins = usage.Pins
+		msg.Removed = usage.Removed
+	}
+	for _, user := range users {
+		message(user.Name)
+	}
+	for _, admin := range admins {
+		message(admin.Name).Admin = true
+	}
+
+	result := make([]AdminUserMessage, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, *msg)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].User < result[j].User })
+
+	return writeAdminResponse(w, "admin_users", result)
+}
+
+func (p *Proxy) handleAdminUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	admin, err := p.authenticateAdmin(ctx, w, r, "admin_user")
+	if err != nil {
+		return err
+	}
+
+	// The admin is the actor of the content events of the removals.
+	ctx = withActor(ctx, admin)
+
+	user, resource, ok := adminUserPath(r.URL.Path)
+	if !ok {
+		return writeAdminError(w, "admin_user", http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+	}
+
+	switch {
+	case resource == "" && r.Method == http.MethodDelete:
+		return p.adminDeleteUser(ctx, w, r, admin, user)
+	case resource == "content" && r.Method == http.MethodGet:
+		return p.adminListContent(ctx, w, r, user)
+	case resource == "content" && r.Method == http.MethodDelete:
+		return p.adminRemoveContent(ctx, w, admin, user)
+	default:
+		return writeAdminError(w, "admin_user", http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// adminUserPath returns the user and the resource of an /admin/users/{user}
+// or /admin/users/{user}/content path. The resource is empty for the former.
+func adminUserPath(path string) (user, resource string, ok bool) {
+	user, resource, _ = strings.Cut(strings.TrimPrefix(path, AdminUsersEndpoint+"/"), "/")
+	if user == "" || (resource != "" && resource != "content") {
+		return "", "", false
+	}
+	return user, resource, true
+}
+
+// adminListContent lists a page of the content of user.
+func (p *Proxy) adminListContent(ctx context.Context, w http.ResponseWriter, r *http.Request, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	opts := db.ListOptions{
+		User:  user,
+		Limit: DefaultAdminListLimit,
+	}
+
+	for param, values := range r.URL.Query() {
+		value := values[len(values)-1]
+		switch param {
+		case "status":
+			switch value {
+			case "any":
+				opts.Status = db.AnyStatus
+			case "active":
+				opts.Status = db.ActiveStatus
+			case "removed":
+				opts.Status = db.RemovedStatus
+			default:
+				err = fmt.Errorf("invalid status %q, must be one of {active, removed, any}", value)
+			}
+		case "limit":
+			opts.Limit, err = strconv.Atoi(value)
+			if err != nil || opts.Limit < 1 || opts.Limit > db.MaxListLimit {
+				err = fmt.Errorf("invalid limit %q, must be between 1 and %d", value, db.MaxListLimit)
+			}
+		case "cursor":
+			var cursor db.ListCursor
+			cursor, err = db.ParseListCursor(value)
+			if err != nil {
+				err = fmt.Errorf("invalid cursor %q", value)
+			}
+			opts.Cursor = &cursor
+		default:
+			err = fmt.Errorf("unsupported argument: %s", param)
+		}
+		if err != nil {
+			mon.Counter("admin_user_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			return writeAdminError(w, "admin_user", http.StatusBadRequest, err)
+		}
+	}
+
+	page, err := p.db.ListContent(ctx, opts)
+	if err != nil {
+		mon.Counter("admin_user_handler_error_db_list_content").Inc(1)
+		return writeAdminError(w, "admin_user", http.StatusInternalServerError, err)
+	}
+
+	result := AdminContentListMessage{
+		Content: make([]AdminContentMessage, 0, len(page.Content)),
+	}
+	for _, content := range page.Content {
+		result.Content = append(result.Content, adminContentMessage(content))
+	}
+	if page.Next != nil {
+		result.Next = page.Next.String()
+		w.Header().Set(NextCursorHeader, result.Next)
+	}
+
+	return writeAdminResponse(w, "admin_user", result)
+}
+
+// adminRemoveContent removes all active content of user. The hashes that no
+// other user has are unpinned, or left in the unpin queue if unpinning fails.
+func (p *Proxy) adminRemoveContent(ctx context.Context, w http.ResponseWriter, admin, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	removed, exclusive, err := p.db.RemoveAllContentByUser(ctx, user, eventSource(ctx))
+	if err != nil {
+		mon.Counter("admin_user_handler_error_db_remove").Inc(1)
+		return writeAdminError(w, "admin_user", http.StatusInternalServerError, err)
+	}
+
+	if len(exclusive) > 0 {
+		hashes := make([]string, 0, len(exclusive))
+		for _, content := range exclusive {
+			hashes = append(hashes, content.Hash)
+		}
+
+		err = p.unpinQueued(ctx, hashes)
+		if err != nil {
+			// Log the error but don't return error to the client.
+			// The unpin queue will retry it.
+			mon.Counter("admin_user_handler_error_backend_unpin").Inc(1)
+			p.log.Error("Error unpinning removed user content",
+				zap.String("Admin", admin),
+				zap.String("User", user),
+				zap.Error(err))
+		}
+	}
+
+	p.log.Info("Admin removed user content",
+		zap.String("Admin", admin),
+		zap.String("User", user),
+		zap.Int("Removed", removed),
+		zap.Int("Unpinned", len(exclusive)))
+
+	return writeAdminResponse(w, "admin_user", AdminRemoveContentMessage{
+		User:     user,
+		Removed:  removed,
+		Unpinned: len(exclusive),
+	})
+}
+
+// adminDeleteUser deletes user with all its content. With the dry-run
+// argument, it only reports what would be deleted.
+func (p *Proxy) adminDeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request, admin, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var dryRun bool
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "dry-run":
+			dryRun, err = strconv.ParseBool(values[len(values)-1])
+			if err != nil {
+				err = fmt.Errorf("invalid value %q for dry-run", values[len(values)-1])
+			}
+		default:
+			err = fmt.Errorf("unsupported argument: %s", param)
+		}
+		if err != nil {
+			mon.Counter("admin_user_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			return writeAdminError(w, "admin_user", http.StatusBadRequest, err)
+		}
+	}
+
+	deletion, err := p.db.DeleteUser(ctx, user, eventSource(ctx), dryRun)
+	if err != nil {
+		mon.Counter("admin_user_handler_error_db_delete_user").Inc(1)
+		return writeAdminError(w, "admin_user", http.StatusInternalServerError, err)
+	}
+
+	if !dryRun {
+		p.log.Info("Admin deleted user",
+			zap.String("Admin", admin),
+			zap.String("User", user),
+			zap.Int("Removed", deletion.Removed),
+			zap.Int("Unpinned", len(deletion.Exclusive)),
+			zap.Int64("Bytes Freed", deletion.BytesFreed))
+	}
+
+	result := AdminDeleteUserMessage{
+		User:          user,
+		DryRun:        dryRun,
+		Removed:       deletion.Removed,
+		Unpinned:      make([]string, 0, len(deletion.Exclusive)),
+		BytesFreed:    deletion.BytesFreed,
+		Keys:          deletion.Keys,
+		Organizations: make([]string, 0, len(deletion.Organizations)),
+		Admin:         deletion.Admin,
+		PinRequests:   deletion.PinRequests,
+		Quota:         deletion.Quota,
+	}
+	result.Organizations = append(result.Organizations, deletion.Organizations...)
+	for _, content := range deletion.Exclusive {
+		result.Unpinned = append(result.Unpinned, content.Hash)
+	}
+
+	return writeAdminResponse(w, "admin_user", result)
+}
+
+func (p *Proxy) handleAdminContent(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = p.authenticateAdmin(ctx, w, r, "admin_content")
+	if err != nil {
+		return err
+	}
+
+	if r.Method != http.MethodGet {
+		return writeAdminError(w, "admin_content", http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, AdminContentEndpoint+"/")
+	if hash == "" || strings.Contains(hash, "/") {
+		return writeAdminError(w, "admin_content", http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+	}
+
+	contents, err := p.db.ListContentByHash(ctx, hash)
+	if err != nil {
+		mon.Counter("admin_content_handler_error_db_list_content").Inc(1)
+		return writeAdminError(w, "admin_content", http.StatusInternalServerError, err)
+	}
+
+	events, err := p.db.ListContentEventsByHash(ctx, hash)
+	if err != nil {
+		mon.Counter("admin_content_handler_error_db_list_events").Inc(1)
+		return writeAdminError(w, "admin_content", http.StatusInternalServerError, err)
+	}
+
+	if len(contents) == 0 && len(events) == 0 {
+		return writeAdminError(w, "admin_content", http.StatusNotFound, fmt.Errorf("content %s not found", hash))
+	}
+
+	result := AdminHashMessage{
+		Hash:   hash,
+		Owners: make([]AdminContentMessage, 0, len(contents)),
+		Events: make([]AdminEventMessage, 0, len(events)),
+	}
+	for _, content := range contents {
+		result.Owners = append(result.Owners, adminContentMessage(content))
+	}
+	for _, event := range events {
+		result.Events = append(result.Events, AdminEventMessage{
+			User:      event.User,
+			Type:      event.Type,
+			Size:      event.Size,
+			Name:      event.Name,
+			Created:   event.Created,
+			RequestID: event.RequestID,
+			Actor:     event.Actor,
+		})
+	}
+
+	return writeAdminResponse(w, "admin_content", result)
+}
+
+func adminContentMessage(content db.Content) AdminContentMessage {
+	return AdminContentMessage{
+		User:    content.User,
+		Hash:    content.Hash,
+		Name:    content.Name,
+		Size:    content.Size,
+		Created: content.Created,
+		Removed: content.Removed,
+	}
+}
+
+func writeAdminResponse(w http.ResponseWriter, handler string, v interface{}) error {
+	mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, handler string, code int, err error) error {
+	mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+	http.Error(w, err.Error(), code)
+	return err
+}
@@ -0,0 +1,395 @@
+package proxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestAdmin_Auth(t *testing.T) {
+	configure := func(p *proxy.Proxy, db *proxydb.DB) {
+		withPasswordVerifier(p, db)
+		p.WithAdmins([]string{"root"})
+	}
+
+	runTestWithConfig(t, nil, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		require.NoError(t, db.AddAdmin(ctx, "dbadmin"))
+
+		for _, tc := range []struct {
+			user string
+			code int
+		}{
+			{user: "", code: http.StatusUnauthorized},
+			{user: "john", code: http.StatusForbidden},
+			{user: "root", code: http.StatusOK},
+			{user: "dbadmin", code: http.StatusOK},
+		} {
+			resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint, tc.user)
+			assert.Equal(t, tc.code, resp.StatusCode, tc.user)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		require.NoError(t, db.RemoveAdmin(ctx, "dbadmin"))
+
+		resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint, "dbadmin")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestAdmin_UnverifiedBasicAuth(t *testing.T) {
+	configure := func(p *proxy.Proxy, _ *proxydb.DB) { p.WithAdmins([]string{"root"}) }
+
+	runTestWithConfig(t, nil, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		// Any password is accepted without a verifier.
+		resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint, "root")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// API keys are verified.
+		key := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "admin", User: "root", Scopes: []string{"admin"}})
+		req, err := http.NewRequest(http.MethodGet, server.URL+proxy.AdminUsersEndpoint, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestAdmin_Users(t *testing.T) {
+	runTestWithConfig(t, nil, withPasswordVerifier, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: proxydb.OrganizationOwner("acme"), Hash: "pin-hash-3", Name: "third.jpg", Size: 4096},
+		)
+		require.NoError(t, err)
+		require.NoError(t, db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-1"}, proxydb.EventSource{}))
+		require.NoError(t, db.AddAdmin(ctx, "admin"))
+
+		resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint, "admin")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var users []proxy.AdminUserMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, []proxy.AdminUserMessage{
+			{User: "admin", Admin: true},
+			{User: "john", Bytes: 2048, Pins: 1, Removed: 1},
+			{User: "org:acme", Organization: "acme", Bytes: 4096, Pins: 1},
+			{User: "shawn", Bytes: 2048, Pins: 1},
+		}, users)
+	})
+}
+
+func TestAdmin_UserContent(t *testing.T) {
+	ipfsHandler := new(mock.IPFSPinRmHandler)
+	runTestWithConfig(t, ipfsHandler, withPasswordVerifier, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: "john", Hash: "pin-hash-3", Name: "third.jpg", Size: 4096},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+		)
+		require.NoError(t, err)
+		require.NoError(t, db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-3"}, proxydb.EventSource{}))
+		require.NoError(t, db.AddAdmin(ctx, "admin"))
+
+		listContent := func(query string) proxy.AdminContentListMessage {
+			resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint+"/john/content"+query, "admin")
+			require.Equal(t, http.StatusOK, resp.StatusCode, query)
+
+			var list proxy.AdminContentListMessage
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+			require.NoError(t, resp.Body.Close())
+			return list
+		}
+
+		hashes := func(list proxy.AdminContentListMessage) (result []string) {
+			for _, content := range list.Content {
+				result = append(result, content.Hash)
+			}
+			return result
+		}
+
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2", "pin-hash-3"}, hashes(listContent("")))
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2"}, hashes(listContent("?status=active")))
+		assert.ElementsMatch(t, []string{"pin-hash-3"}, hashes(listContent("?status=removed")))
+
+		first := listContent("?limit=2")
+		require.Len(t, first.Content, 2)
+		require.NotEmpty(t, first.Next)
+		second := listContent("?limit=2&cursor=" + first.Next)
+		assert.Len(t, second.Content, 1)
+		assert.Empty(t, second.Next)
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2", "pin-hash-3"}, append(hashes(first), hashes(second)...))
+
+		for _, query := range []string{"?status=pinned", "?limit=0", "?cursor=invalid", "?type=all"} {
+			resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint+"/john/content"+query, "admin")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		for _, path := range []string{"/john/keys", "/john/content/pin-hash-1"} {
+			resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminUsersEndpoint+path, "admin")
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		resp := adminRequest(t, http.MethodDelete, server.URL+proxy.AdminUsersEndpoint+"/john/content", "admin")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var removed proxy.AdminRemoveContentMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&removed))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.AdminRemoveContentMessage{User: "john", Removed: 2, Unpinned: 1}, removed)
+
+		active, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, active)
+
+		active, err = db.ListActiveContentByUser(ctx, "shawn")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, active)
+
+		// The exclusive hash is unpinned right away. The hash removed before
+		// is still left to the unpin queue.
+		assert.Equal(t, []string{"pin-hash-1"}, ipfsHandler.Removed)
+
+		queued, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+		assert.Equal(t, "pin-hash-3", queued[0].Hash)
+	})
+}
+
+func TestAdmin_Content(t *testing.T) {
+	runTestWithConfig(t, nil, withPasswordVerifier, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "copy.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+		require.NoError(t, db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-1"}, proxydb.EventSource{RequestID: "request-1", Actor: "jane"}))
+		require.NoError(t, db.AddAdmin(ctx, "admin"))
+
+		resp := adminRequest(t, http.MethodGet, server.URL+proxy.AdminContentEndpoint+"/pin-hash-1", "admin")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var content proxy.AdminHashMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&content))
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, "pin-hash-1", content.Hash)
+		require.Len(t, content.Owners, 2)
+		assert.Equal(t, "john", content.Owners[0].User)
+		assert.NotNil(t, content.Owners[0].Removed)
+		assert.Equal(t, "shawn", content.Owners[1].User)
+		assert.Equal(t, "copy.jpg", content.Owners[1].Name)
+		assert.Nil(t, content.Owners[1].Removed)
+
+		var events []string
+		for _, event := range content.Events {
+			events = append(events, event.User+" "+event.Type)
+		}
+		assert.Equal(t, []string{"john add", "shawn add", "john remove"}, events)
+		assert.Equal(t, "request-1", content.Events[2].RequestID)
+		assert.Equal(t, "jane", content.Events[2].Actor)
+
+		resp = adminRequest(t, http.MethodGet, server.URL+proxy.AdminContentEndpoint+"/unknown-hash", "admin")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = adminRequest(t, http.MethodDelete, server.URL+proxy.AdminContentEndpoint+"/pin-hash-1", "admin")
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestAdmin_DeleteUser(t *testing.T) {
+	files := new(mock.IPFSFilesHandler)
+	keys := new(mock.IPFSKeysHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.FilesEndpoint + "rm": files,
+		proxy.KeyRmEndpoint:        keys,
+	}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, db *proxydb.DB) {
+		withPasswordVerifier(configured, db)
+		p = configured
+	}
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: "john", Hash: "pin-hash-3", Name: "third.jpg", Size: 4096},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+		)
+		require.NoError(t, err)
+		require.NoError(t, db.SetUserPassword(ctx, "john", "hash"))
+		require.NoError(t, db.AddAdmin(ctx, "admin"))
+		require.NoError(t, db.AddAdmin(ctx, "john"))
+		require.NoError(t, db.AddOrganization(ctx, "acme"))
+		require.NoError(t, db.AddMember(ctx, "acme", "john"))
+		require.NoError(t, db.AddIPNSKey(ctx, proxydb.IPNSKey{User: "john", Name: "blog", NodeName: nodeKeyName("john", "blog"), ID: keyID("john", "blog")}))
+		keys.Keys[nodeKeyName("john", "blog")] = keyID("john", "blog")
+		// The key of the site is already gone from the IPFS node.
+		require.NoError(t, db.AddIPNSKey(ctx, proxydb.IPNSKey{User: "john", Name: "site", NodeName: nodeKeyName("john", "site"), ID: keyID("john", "site")}))
+		maxPins := int64(10)
+		require.NoError(t, db.SetQuota(ctx, proxydb.Quota{User: "john", MaxPins: &maxPins}))
+		_, err = db.AddPinRequest(ctx, proxydb.PinRequest{ID: "request-1", User: "john", CID: "pin-hash-4", Status: proxydb.PinStatusQueued})
+		require.NoError(t, err)
+
+		exclusive, err := db.ListExclusiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		require.Len(t, exclusive, 2)
+		assert.Equal(t, "pin-hash-1", exclusive[0].Hash)
+		assert.Equal(t, "pin-hash-3", exclusive[1].Hash)
+
+		deleteUser := func(query string) proxy.AdminDeleteUserMessage {
+			resp := adminRequest(t, http.MethodDelete, server.URL+proxy.AdminUsersEndpoint+"/john"+query, "admin")
+			require.Equal(t, http.StatusOK, resp.StatusCode, query)
+
+			var deletion proxy.AdminDeleteUserMessage
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&deletion))
+			require.NoError(t, resp.Body.Close())
+			return deletion
+		}
+
+		expected := proxy.AdminDeleteUserMessage{
+			User:          "john",
+			DryRun:        true,
+			Removed:       3,
+			Unpinned:      []string{"pin-hash-1", "pin-hash-3"},
+			BytesFreed:    5120,
+			Keys:          2,
+			Organizations: []string{"acme"},
+			Admin:         true,
+			PinRequests:   1,
+			Quota:         true,
+		}
+
+		// The dry run doesn't change anything.
+		assert.Equal(t, expected, deleteUser("?dry-run=true"))
+
+		active, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Len(t, active, 3)
+
+		queued, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, queued)
+
+		cleanups, err := db.ListDueCleanups(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, cleanups)
+
+		expected.DryRun = false
+		assert.Equal(t, expected, deleteUser(""))
+
+		active, err = db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, active)
+
+		active, err = db.ListActiveContentByUser(ctx, "shawn")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, active)
+
+		queued, err = db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		var queuedHashes []string
+		for _, unpin := range queued {
+			queuedHashes = append(queuedHashes, unpin.Hash)
+		}
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-3"}, queuedHashes)
+
+		_, err = db.GetUserPasswordHash(ctx, "john")
+		assert.True(t, proxydb.ErrNotFound.Has(err))
+
+		ipnsKeys, err := db.ListIPNSKeys(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, ipnsKeys)
+
+		admin, err := db.IsAdmin(ctx, "john")
+		require.NoError(t, err)
+		assert.False(t, admin)
+
+		member, err := db.IsMember(ctx, "acme", "john")
+		require.NoError(t, err)
+		assert.False(t, member)
+
+		// The pin worker finds no pin requests of the deleted user.
+		_, err = db.GetPinRequest(ctx, "john", "request-1")
+		assert.True(t, proxydb.ErrNotFound.Has(err))
+
+		_, err = db.GetQuota(ctx, "john")
+		assert.True(t, proxydb.ErrNotFound.Has(err))
+
+		// The MFS root and the keys are removed from the IPFS node by the
+		// cleanup queue. The missing key is considered removed.
+		cleanups, err = db.ListDueCleanups(ctx, 10)
+		require.NoError(t, err)
+		assert.Len(t, cleanups, 3)
+
+		require.NoError(t, p.ProcessCleanupQueue(ctx))
+		assert.Equal(t, []string{"rm /users/john"}, files.Calls)
+		assert.Empty(t, keys.Keys)
+
+		cleanups, err = db.ListDueCleanups(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, cleanups)
+
+		// Deleting again finds nothing to delete.
+		assert.Equal(t, proxy.AdminDeleteUserMessage{User: "john", Unpinned: []string{}, Organizations: []string{}}, deleteUser(""))
+
+		resp := adminRequest(t, http.MethodDelete, server.URL+proxy.AdminUsersEndpoint+"/john?dry-run=maybe", "admin")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func adminRequest(t *testing.T, method, url, user string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if user != "" {
+		req.SetBasicAuth(user, "somepassword")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+// passwordVerifier accepts the password of adminRequest for all users.
+type passwordVerifier struct{}
+
+func (passwordVerifier) Verify(ctx context.Context, user, password string) error {
+	if password != "somepassword" {
+		return auth.ErrInvalidCredentials
+	}
+	return nil
+}
+
+// withPasswordVerifier verifies the basic auth of adminRequest, which the
+// admin role requires.
+func withPasswordVerifier(p *proxy.Proxy, _ *proxydb.DB) {
+	p.WithVerifier(passwordVerifier{})
+}
@@ -0,0 +1,113 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestAPIKeys_Scopes(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		readKey := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "read", User: "john", Scopes: []string{"pin:read"}})
+		rmKey := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "rm", User: "john", Scopes: []string{"pin:read", "pin:rm"}})
+
+		for _, tc := range []struct {
+			endpoint string
+			key      string
+			code     int
+		}{
+			{endpoint: proxy.UsageEndpoint, key: readKey, code: http.StatusOK},
+			{endpoint: proxy.PinLsEndpoint, key: readKey, code: http.StatusOK},
+			{endpoint: proxy.PinRmEndpoint + "?arg=pin-hash-1", key: readKey, code: http.StatusForbidden},
+			{endpoint: proxy.KeyListEndpoint, key: rmKey, code: http.StatusForbidden},
+			{endpoint: proxy.AdminUsersEndpoint, key: rmKey, code: http.StatusForbidden},
+			{endpoint: proxy.UsageEndpoint, key: "unknown-key", code: http.StatusUnauthorized},
+		} {
+			resp := apiKeyRequest(t, server.URL+tc.endpoint, tc.key)
+			assert.Equal(t, tc.code, resp.StatusCode, tc.endpoint)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		// The key authenticates as its user.
+		resp := apiKeyRequest(t, server.URL+proxy.UsageEndpoint, readKey)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage proxy.UsageResponseMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john", Bytes: 1024, Pins: 1}, usage)
+
+		keys, err := db.ListAPIKeys(ctx, "john")
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		for _, key := range keys {
+			assert.NotNil(t, key.LastUsed, key.ID)
+		}
+
+		// The last used time is not updated again within a minute.
+		resp = apiKeyRequest(t, server.URL+proxy.UsageEndpoint, readKey)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		again, err := db.ListAPIKeys(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, keys, again)
+	})
+}
+
+func TestAPIKeys_ExpiredAndRevoked(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		expired := time.Now().Add(-time.Minute)
+		expiredKey := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "expired", User: "john", Scopes: []string{"pin:read"}, Expires: &expired})
+
+		expires := time.Now().Add(time.Hour)
+		key := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "valid", User: "john", Scopes: []string{"pin:read"}, Expires: &expires})
+
+		resp := apiKeyRequest(t, server.URL+proxy.UsageEndpoint, expiredKey)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = apiKeyRequest(t, server.URL+proxy.UsageEndpoint, key)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		require.NoError(t, db.RevokeAPIKey(ctx, "valid"))
+		assert.True(t, proxydb.ErrNotFound.Has(db.RevokeAPIKey(ctx, "valid")))
+
+		resp = apiKeyRequest(t, server.URL+proxy.UsageEndpoint, key)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func addAPIKey(ctx *testcontext.Context, t *testing.T, db *proxydb.DB, key proxydb.APIKey) string {
+	token, hash, err := auth.NewToken()
+	require.NoError(t, err)
+	require.NoError(t, db.AddAPIKey(ctx, key, hash))
+	return token
+}
+
+func apiKeyRequest(t *testing.T, url, key string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// handlerScopes are the scopes an API key or a JWT with a scope claim needs
+// to call the handlers. They cannot call handlers that are not listed.
+var handlerScopes = map[string][]auth.Scope{
+	"add":           {auth.ScopeAdd},
+	"dag_import":    {auth.ScopeAdd},
+	"pin_add":       {auth.ScopeAdd},
+	"pin_update":    {auth.ScopeAdd, auth.ScopePinRm},
+	"pin_ls":        {auth.ScopePinRead},
+	"usage":         {auth.ScopePinRead},
+	"cat":           {auth.ScopePinRead},
+	"get":           {auth.ScopePinRead},
+	"ls":            {auth.ScopePinRead},
+	"dag_export":    {auth.ScopePinRead},
+	"pin_rm":        {auth.ScopePinRm},
+	"files":         {auth.ScopeFiles},
+	"key_gen":       {auth.ScopeKeys},
+	"key_list":      {auth.ScopeKeys},
+	"key_rm":        {auth.ScopeKeys},
+	"name_publish":  {auth.ScopeKeys},
+	"pins":          {auth.ScopePins},
+	"admin_users":   {auth.ScopeAdmin},
+	"admin_user":    {auth.ScopeAdmin},
+	"admin_content": {auth.ScopeAdmin},
+	"passthrough":   {auth.ScopeAdmin},
+}
+
+// authenticate returns the owner of the content of the request and a copy of
+// ctx with the authenticated user as the actor of the content events, see
+// eventSource.
+//
+// The owner is the authenticated user, see authenticateUser, unless the
+// request acts on behalf of an organization with the X-Organization header
+// or the organization scope of its credentials. Then, the owner is the
+// organization, see db.OrganizationOwner, and the user must be a member.
+//
+// If the request is not authenticated, it writes an error response to w and
+// counts the response code under the response codes series of handler.
+func (p *Proxy) authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string) (_ context.Context, owner string, err error) {
+	user, scopes, err := p.authenticateUser(ctx, w, r, handler)
+	if err != nil {
+		return ctx, "", err
+	}
+
+	owner, err = p.resolveOwner(ctx, w, r, user, scopes, handler)
+	if err != nil {
+		return ctx, "", err
+	}
+
+	return withActor(ctx, user), owner, nil
+}
+
+// actorKey is the context key of the user who makes the changes of a request.
+type actorKey struct{}
+
+// withActor returns a copy of ctx with user as the actor of the content
+// events, see eventSource.
+func withActor(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, actorKey{}, user)
+}
+
+// eventSource returns the source of the content events of the request of
+// ctx: its request ID and the user who makes the changes.
+func eventSource(ctx context.Context) db.EventSource {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return db.EventSource{
+		RequestID: requestID(ctx),
+		Actor:     actor,
+	}
+}
+
+// authenticateUser returns the authenticated user of the request and the
+// scopes of its credentials. Nil scopes are not restricted.
+//
+// Requests with an identity resolved by withIdentity, like a JWT, are
+// authenticated with that identity. Other requests with a bearer token are
+// authenticated with the API key of the token. Both must have the scopes of
+// handler, if restricted. Other requests are authenticated with basic auth.
+//
+// If the request is not authenticated, it writes an error response to w and
+// counts the response code under the response codes series of handler.
+func (p *Proxy) authenticateUser(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string) (user string, scopes []string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if id, ok := identityFrom(ctx); ok {
+		return p.authenticateIdentity(ctx, w, id, handler)
+	}
+
+	if token, ok := auth.BearerToken(r); ok {
+		return p.authenticateAPIKey(ctx, w, token, handler)
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
+		p.log.Error("No basic auth in request")
+		err = errors.New("no basic auth")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", nil, err
+	}
+
+	if p.verifier == nil {
+		return user, nil, nil
+	}
+
+	err = p.verifier.Verify(ctx, user, password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
+			p.log.Error("Invalid credentials", zap.String("User", user))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return "", nil, err
+		}
+
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusInternalServerError))).Inc(1)
+		p.log.Error("Error verifying credentials", zap.String("User", user), zap.Error(err))
+		http.Error(w, "error verifying credentials", http.StatusInternalServerError)
+		return "", nil, err
+	}
+
+	return user, nil, nil
+}
+
+// verifiedCredentials returns whether the credentials of the request were
+// verified: an identity resolved by withIdentity, an API key, or basic auth
+// checked by the verifier. Without a verifier, basic auth accepts any
+// password, so it must not be trusted for privileged roles.
+func (p *Proxy) verifiedCredentials(ctx context.Context, r *http.Request) bool {
+	if _, ok := identityFrom(ctx); ok {
+		return true
+	}
+	if _, ok := auth.BearerToken(r); ok {
+		return true
+	}
+	return p.verifier != nil
+}
+
+// authenticateAPIKey returns the user and scopes of the API key token if the
+// key has the scopes of handler.
+func (p *Proxy) authenticateAPIKey(ctx context.Context, w http.ResponseWriter, token, handler string) (user string, scopes []string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	key, err := p.db.UseAPIKey(ctx, auth.HashToken(token))
+	if err != nil {
+		if db.ErrNotFound.Has(err) {
+			mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
+			p.log.Error("Invalid or expired API key")
+			err = errors.New("invalid or expired API key")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return "", nil, err
+		}
+
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusInternalServerError))).Inc(1)
+		p.log.Error("Error verifying API key", zap.Error(err))
+		http.Error(w, "error verifying API key", http.StatusInternalServerError)
+		return "", nil, err
+	}
+
+	err = p.checkScopes(w, "API key", key.Scopes, handler, zap.String("User", key.User), zap.String("Key", key.ID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key.User, key.Scopes, nil
+}
+
+// authenticateIdentity returns the user and scopes of id if its credentials
+// were accepted and it has the scopes of handler.
+func (p *Proxy) authenticateIdentity(ctx context.Context, w http.ResponseWriter, id identity, handler string) (user string, scopes []string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if id.err != nil {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
+		p.log.Error("Invalid "+id.source, zap.Error(id.err))
+		http.Error(w, id.err.Error(), http.StatusUnauthorized)
+		return "", nil, id.err
+	}
+
+	if id.scopes != nil {
+		err = p.checkScopes(w, id.source, id.scopes, handler, zap.String("User", id.user))
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return id.user, id.scopes, nil
+}
+
+// checkScopes returns an error if granted does not include the scopes of
+// handler and writes an error response to w.
+//
+// The credentials are described by source and fields in the error and log.
+func (p *Proxy) checkScopes(w http.ResponseWriter, source string, granted []string, handler string, fields ...zap.Field) error {
+	scopes, ok := handlerScopes[handler]
+	if ok && auth.HasScopes(granted, scopes...) {
+		return nil
+	}
+
+	mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusForbidden))).Inc(1)
+	p.log.Error(source+" without required scopes", append(fields, zap.String("Handler", handler))...)
+	err := fmt.Errorf("%s does not have the required scopes: %v", source, scopes)
+	http.Error(w, err.Error(), http.StatusForbidden)
+	return err
+}
@@ -0,0 +1,92 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestAuth_DBVerifier(t *testing.T) {
+	runTestWithConfig(t, new(mock.IPFSAddHandler), withDBVerifier, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("somepassword"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		err = db.SetUserPassword(ctx, "john", string(hash))
+		require.NoError(t, err)
+
+		// Upload a file with the correct password.
+		err = addFile(server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		require.NoError(t, err)
+
+		// Upload a file with a wrong password.
+		req, err := addRequest(server.URL+proxy.AddEndpoint, "john", 1024, "second.jpg")
+		require.NoError(t, err)
+		req.SetBasicAuth("john", "wrongpassword")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// Upload a file with an unknown user.
+		err = addFile(server.URL+proxy.AddEndpoint, "shawn", 1024, "third.jpg")
+		require.Error(t, err)
+
+		// Check that only the first file is in the DB.
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, contents, 1)
+		assert.Equal(t, "john", contents[0].User)
+		assert.Equal(t, "first.jpg", contents[0].Name)
+	})
+}
+
+func TestAuth_WrongPassword(t *testing.T) {
+	runTestWithConfig(t, new(mock.IPFSPinRmHandler), withDBVerifier, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("somepassword"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		err = db.SetUserPassword(ctx, "john", string(hash))
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		for _, endpoint := range []string{
+			proxy.AddEndpoint,
+			proxy.DAGImportEndpoint,
+			proxy.PinLsEndpoint,
+			proxy.PinRmEndpoint + "?arg=pin-hash-1",
+		} {
+			req, err := http.NewRequest(http.MethodPost, server.URL+endpoint, nil)
+			require.NoError(t, err)
+			req.SetBasicAuth("john", "wrongpassword")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, endpoint)
+		}
+
+		// Check that the DB record was not marked as removed.
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, contents, 1)
+		assert.Nil(t, contents[0].Removed)
+	})
+}
+
+func withDBVerifier(p *proxy.Proxy, db *proxydb.DB) {
+	p.WithVerifier(auth.NewDBVerifier(db))
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+const (
+	backendFilesStatEndpoint = "/api/v0/files/stat"
+	backendDAGStatEndpoint   = "/api/v0/dag/stat"
+)
+
+// BackendError is the error class for errors returned by the IPFS node.
+var BackendError = errs.Class("backend")
+
+// backendErrorMessage is the JSON object returned by the IPFS node on errors.
+type backendErrorMessage struct {
+	Message string
+	Code    int
+	Type    string
+}
+
+// backendResponseErr is the error of a non-OK response of the IPFS node with
+// an IPFS error message.
+type backendResponseErr struct {
+	status string
+	msg    backendErrorMessage
+}
+
+func (err *backendResponseErr) Error() string {
+	return err.status + ": " + err.msg.Message
+}
+
+// backendRequest sends a POST request with args to endpoint of the IPFS node.
+//
+// The caller must close the response body.
+func (p *Proxy) backendRequest(ctx context.Context, endpoint string, args url.Values) (*http.Response, error) {
+	u := *p.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + endpoint
+	u.RawQuery = args.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, BackendError.Wrap(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, BackendError.Wrap(err)
+	}
+
+	return resp, nil
+}
+
+// backendCall sends a POST request with args to endpoint of the IPFS node and
+// decodes the JSON response into result. Non-OK responses are returned as
+// BackendError errors.
+func (p *Proxy) backendCall(ctx context.Context, endpoint string, args url.Values, result interface{}) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	resp, err := p.backendRequest(ctx, endpoint, args)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return backendResponseError(resp)
+	}
+
+	if result == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return BackendError.Wrap(err)
+	}
+
+	return BackendError.Wrap(json.NewDecoder(resp.Body).Decode(result))
+}
+
+// backendResponseError returns a BackendError with the error message of a
+// non-OK response.
+func backendResponseError(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return BackendError.New("%s", resp.Status)
+	}
+
+	var msg backendErrorMessage
+	if json.Unmarshal(body, &msg) == nil && msg.Message != "" {
+		return BackendError.Wrap(&backendResponseErr{status: resp.Status, msg: msg})
+	}
+
+	return BackendError.New("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// pin recursively pins cid on the IPFS node.
+func (p *Proxy) pin(ctx context.Context, cid string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return p.backendCall(ctx, PinAddEndpoint, url.Values{"arg": {cid}}, nil)
+}
+
+// unpin removes the pins of hashes from the IPFS node.
+func (p *Proxy) unpin(ctx context.Context, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return p.backendCall(ctx, PinRmEndpoint, url.Values{"arg": hashes}, nil)
+}
+
+// cumulativeSize returns the total size of the DAG with root cid.
+//
+// It asks files/stat first, which works for UnixFS content, and falls back
+// to dag/stat for any other kind of DAG.
+func (p *Proxy) cumulativeSize(ctx context.Context, cid string) (size int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var filesStat struct {
+		CumulativeSize int64
+	}
+	filesErr := p.backendCall(ctx, backendFilesStatEndpoint, url.Values{"arg": {"/ipfs/" + cid}}, &filesStat)
+	if filesErr == nil {
+		return filesStat.CumulativeSize, nil
+	}
+
+	// Older nodes return Size, newer nodes return TotalSize.
+	var dagStat struct {
+		Size      int64
+		TotalSize int64
+	}
+	dagErr := p.backendCall(ctx, backendDAGStatEndpoint, url.Values{"arg": {cid}, "progress": {"false"}}, &dagStat)
+	if dagErr != nil {
+		return 0, errs.Combine(filesErr, dagErr)
+	}
+
+	if dagStat.TotalSize > 0 {
+		return dagStat.TotalSize, nil
+	}
+	return dagStat.Size, nil
+}
+
+// mfsSize returns the cumulative size of the MFS path on the IPFS node.
+func (p *Proxy) mfsSize(ctx context.Context, path string) (size int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var filesStat struct {
+		CumulativeSize int64
+	}
+	err = p.backendCall(ctx, backendFilesStatEndpoint, url.Values{"arg": {path}}, &filesStat)
+	if err != nil {
+		return 0, err
+	}
+
+	return filesStat.CumulativeSize, nil
+}
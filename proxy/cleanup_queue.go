@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// cleanupBatchSize is the maximum number of queued cleanups processed at
+// once.
+const cleanupBatchSize = 100
+
+// errUnknownCleanupKind is the error of a queued cleanup that this version of
+// the proxy cannot process. Retrying it cannot succeed.
+var errUnknownCleanupKind = errs.Class("unknown cleanup kind")
+
+// ProcessCleanupQueue removes the due MFS roots and keys of deleted users in
+// the cleanup queue from the IPFS node. State that does not exist anymore is
+// considered removed. Failed cleanups are retried with exponential backoff.
+func (p *Proxy) ProcessCleanupQueue(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	cleanups, err := p.db.ListDueCleanups(ctx, cleanupBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, cleanup := range cleanups {
+		err = p.cleanup(ctx, cleanup)
+		if errUnknownCleanupKind.Has(err) {
+			mon.Counter("cleanup_queue_error_unknown_kind").Inc(1)
+			p.log.Error("Dropping queued cleanup of unknown kind",
+				zap.String("Kind", string(cleanup.Kind)),
+				zap.String("Name", cleanup.Name),
+				zap.Error(err))
+
+			err = p.db.DeleteQueuedCleanup(ctx, cleanup.Kind, cleanup.Name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) || !BackendError.Has(err) {
+				return err
+			}
+
+			mon.Counter("cleanup_queue_error_backend").Inc(1)
+			p.log.Error("Error cleaning up state of deleted user",
+				zap.String("Kind", string(cleanup.Kind)),
+				zap.String("Name", cleanup.Name),
+				zap.Int("Attempts", cleanup.Attempts+1),
+				zap.Error(err))
+
+			err = p.db.RetryQueuedCleanup(ctx, cleanup.Kind, cleanup.Name, unpinRetryDelay(cleanup.Attempts), err.Error())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = p.db.DeleteQueuedCleanup(ctx, cleanup.Kind, cleanup.Name)
+		if err != nil {
+			return err
+		}
+		mon.Counter("cleanup_queue_removed").Inc(1)
+	}
+
+	return nil
+}
+
+// cleanup removes the state of a queued cleanup from the IPFS node.
+func (p *Proxy) cleanup(ctx context.Context, cleanup db.QueuedCleanup) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	switch cleanup.Kind {
+	case db.CleanupMFSRoot:
+		root, err := mfsRoot(cleanup.Name)
+		if err != nil {
+			// The user never had an MFS root.
+			return nil
+		}
+
+		p.mfsRoots.Delete(root)
+		err = p.backendCall(ctx, FilesEndpoint+"rm", url.Values{
+			"arg":       {root},
+			"recursive": {"true"},
+			"force":     {"true"},
+		}, nil)
+		if err != nil && !isFileNotExist(err) {
+			return err
+		}
+		return nil
+	case db.CleanupKey:
+		err = p.backendCall(ctx, KeyRmEndpoint, url.Values{"arg": {cleanup.Name}}, nil)
+		if err != nil && !isNoKey(err) {
+			return err
+		}
+		return nil
+	default:
+		return errUnknownCleanupKind.New("%q", cleanup.Kind)
+	}
+}
+
+// isFileNotExist returns whether err is the error of the IPFS node for
+// removing an MFS path that does not exist.
+func isFileNotExist(err error) bool {
+	var respErr *backendResponseErr
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.msg.Code == ipfsErrNormal &&
+		respErr.msg.Type == "error" &&
+		strings.HasSuffix(respErr.msg.Message, ipfsErrFileNotExistMessage)
+}
+
+// isNoKey returns whether err is the error of the IPFS node for removing a
+// key that does not exist.
+func isNoKey(err error) bool {
+	var respErr *backendResponseErr
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.msg.Code == ipfsErrNormal &&
+		respErr.msg.Type == "error" &&
+		strings.HasPrefix(respErr.msg.Message, ipfsErrNoKeyMessage)
+}
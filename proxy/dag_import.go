@@ -5,12 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
 	"storj.io/ipfs-user-mapping-proxy/db"
@@ -46,12 +45,8 @@ func (p *Proxy) HandleDAGImport(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) handleDAGImport(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		mon.Counter("dag_import_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
-		p.log.Error("No basic auth in request")
-		err = errors.New("no basic auth")
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	ctx, user, err := p.authenticate(ctx, w, r, "dag_import")
+	if err != nil {
 		return err
 	}
 
@@ -79,6 +74,11 @@ func (p *Proxy) handleDAGImport(ctx context.Context, w http.ResponseWriter, r *h
 		}
 	}
 
+	err = p.precheckQuota(ctx, w, r, user, "dag_import")
+	if err != nil {
+		return err
+	}
+
 	// Ensure that the stats param is set in the request
 	if !Stats(r) {
 		values := r.URL.Query()
@@ -86,7 +86,8 @@ func (p *Proxy) handleDAGImport(ctx context.Context, w http.ResponseWriter, r *h
 		r.URL.RawQuery = values.Encode()
 	}
 
-	wrapper := NewResponseWriterWrapper(w)
+	var messages dagImportResponseMessages
+	wrapper := NewResponseWriterWrapper(w, messages.collect)
 	p.proxy.ServeHTTP(wrapper, r)
 
 	code := wrapper.StatusCode
@@ -103,77 +104,102 @@ func (p *Proxy) handleDAGImport(ctx context.Context, w http.ResponseWriter, r *h
 		return fmt.Errorf("Proxy error (code %d)", code)
 	}
 
-	decoder := json.NewDecoder(strings.NewReader(string(wrapper.Body)))
-
-	var cids []string
-	for {
-		var msg DAGImportResponseMessage
-		err := decoder.Decode(&msg)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+	err = wrapper.Finish()
+	if err != nil {
+		switch {
+		case errPinError.Has(err):
+			mon.Counter("dag_import_handler_pin_error_msg").Inc(1)
+		case errNoRootCID.Has(err):
+			mon.Counter("dag_import_handler_no_root_cid").Inc(1)
+		default:
 			mon.Counter("dag_import_handler_error_unmarshal_response").Inc(1)
 			p.log.Error("JSON response unmarshal error",
 				zap.String("User", user),
-				zap.ByteString("Body", wrapper.Body),
 				zap.Error(err))
 			return err
 		}
+		p.log.Error("DAG Import error",
+			zap.String("User", user),
+			zap.Strings("Roots", messages.cids),
+			zap.Error(err))
+		return err
+	}
 
-		if msg.Root != nil {
-			if msg.Root.PinErrorMsg != "" {
-				mon.Counter("dag_import_handler_pin_error_msg").Inc(1)
-				err = errors.New(msg.Root.PinErrorMsg)
-				p.log.Error("DAG Import error",
-					zap.String("User", user),
-					zap.ByteString("Body", wrapper.Body),
-					zap.Error(err))
-				return err
-			}
+	if messages.stats == nil {
+		return nil
+	}
 
-			cid, found := msg.Root.Cid["/"]
-			if !found {
-				mon.Counter("dag_import_handler_no_root_cid").Inc(1)
-				err = errors.New("no root CID in response")
-				p.log.Error("DAG Import error",
-					zap.String("User", user),
-					zap.ByteString("Body", wrapper.Body),
-					zap.Error(err))
-				return err
-			}
+	// It is not ideal to set the total bytes count to each of the
+	// imported CARs (in the case of multiple CARs in a single request),
+	// but we have no better way to keep track of the uploaded size.
+	size := messages.stats.BlockBytesCount
+
+	contents := make([]db.Content, 0, len(messages.cids))
+	for _, cid := range messages.cids {
+		contents = append(contents, db.Content{
+			User: user,
+			Hash: cid,
+			Name: cid + " (dag import)",
+			Size: size,
+		})
+	}
 
-			cids = append(cids, cid)
+	err = p.addWithinQuota(ctx, wrapper, "dag_import", contents, eventSource(ctx))
+	if err != nil {
+		if isQuotaExceeded(err) {
+			return err
 		}
+		mon.Counter("dag_import_handler_error_db_add").Inc(1)
+		p.log.Error("Error adding content to database",
+			zap.String("User", user),
+			zap.Strings("Hashes", messages.cids),
+			zap.Int64("Size", size),
+			zap.Error(err))
+		return err
+	}
 
-		if msg.Stats != nil {
-			// It is not ideal to set the total bytes count to each of the
-			// imported CARs (in the case of multiple CARs in a single request),
-			// but we have no better way to keep track of the uploaded size.
-			size := msg.Stats.BlockBytesCount
-
-			for _, cid := range cids {
-				hash := cid
-				name := cid + " (dag import)"
-				err = p.db.Add(ctx, db.Content{
-					User: user,
-					Hash: hash,
-					Name: name,
-					Size: size,
-				})
-				if err != nil {
-					mon.Counter("dag_import_handler_error_db_add").Inc(1)
-					p.log.Error("Error adding content to database",
-						zap.String("User", user),
-						zap.String("Hash", hash),
-						zap.String("Name", name),
-						zap.Int64("Size", size),
-						zap.Error(err))
-					return err
-				}
-			}
-			return nil
+	return nil
+}
+
+var (
+	errPinError  = errs.Class("DAG import pin error")
+	errNoRootCID = errs.Class("no root CID in response")
+)
+
+// dagImportResponseMessages collects the root CIDs and the stats of a DAG
+// import response.
+type dagImportResponseMessages struct {
+	cids  []string
+	stats *CarImportStats
+}
+
+func (messages *dagImportResponseMessages) collect(data []byte) error {
+	var msg DAGImportResponseMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return err
+	}
+
+	if messages.stats != nil {
+		// The stats message completes the response.
+		return nil
+	}
+
+	if msg.Root != nil {
+		if msg.Root.PinErrorMsg != "" {
+			return errPinError.New("%s", msg.Root.PinErrorMsg)
+		}
+
+		cid, found := msg.Root.Cid["/"]
+		if !found {
+			return errNoRootCID.New("")
 		}
+
+		messages.cids = append(messages.cids, cid)
+	}
+
+	if msg.Stats != nil {
+		messages.stats = msg.Stats
 	}
 
 	return nil
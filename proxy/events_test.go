@@ -0,0 +1,71 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestContentEvents(t *testing.T) {
+	runTest(t, new(mock.IPFSPinRmHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		content := proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024}
+
+		err := prefillDB(ctx, db, content, proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "other.jpg", Size: 1024})
+		require.NoError(t, err)
+
+		// Remove the pin with a request ID.
+		req, err := pinRmRequest(server.URL+proxy.PinRmEndpoint, "john", "pin-hash-1")
+		require.NoError(t, err)
+		req.Header.Set(proxy.RequestIDHeader, "remove-request")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "remove-request", resp.Header.Get(proxy.RequestIDHeader))
+
+		// Add the content again, and once more while it is active.
+		err = db.Add(ctx, content, proxydb.EventSource{RequestID: "readd-request"})
+		require.NoError(t, err)
+		err = prefillDB(ctx, db, content)
+		require.NoError(t, err)
+
+		events, err := db.ListContentEventsByUser(ctx, "john")
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, proxydb.ContentEventAdd, events[0].Type)
+		assert.Equal(t, proxydb.ContentEventRemove, events[1].Type)
+		assert.Equal(t, "remove-request", events[1].RequestID)
+		assert.Equal(t, proxydb.ContentEventReAdd, events[2].Type)
+		assert.Equal(t, "readd-request", events[2].RequestID)
+		for _, event := range events {
+			assert.Equal(t, "john", event.User)
+			assert.Equal(t, "pin-hash-1", event.Hash)
+			assert.Equal(t, "first.jpg", event.Name)
+			assert.EqualValues(t, 1024, event.Size)
+		}
+
+		events, err = db.ListContentEventsByHash(ctx, "pin-hash-1")
+		require.NoError(t, err)
+		require.Len(t, events, 4)
+	})
+}
+
+func TestRequestID_Generated(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint, "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.NotEmpty(t, resp.Header.Get(proxy.RequestIDHeader))
+	})
+}
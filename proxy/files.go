@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+)
+
+// MFSUsersRoot is the MFS directory of the IPFS node under which each user
+// has a private MFS root.
+const MFSUsersRoot = "/users"
+
+// filesCommands are the MFS commands that are proxied for users.
+var filesCommands = map[string]bool{
+	"mkdir": true,
+	"write": true,
+	"cp":    true,
+	"ls":    true,
+	"stat":  true,
+	"rm":    true,
+	"mv":    true,
+	"read":  true,
+	"flush": true,
+}
+
+// HandleFiles is an HTTP handler that intercepts
+// the /api/v0/files/* requests to the IPFS node.
+//
+// It rewrites the MFS paths of the request into the authenticated user's MFS
+// root and strips the root back out of the response, so each user sees a
+// private MFS.
+func (p *Proxy) HandleFiles(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleFiles(r.Context(), w, r)
+}
+
+func (p *Proxy) handleFiles(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "files")
+	if err != nil {
+		return err
+	}
+
+	command := strings.TrimPrefix(r.URL.Path, FilesEndpoint)
+	if !filesCommands[command] {
+		mon.Counter("files_handler_unsupported_command", monkit.NewSeriesTag("command", command)).Inc(1)
+		err = fmt.Errorf("unsupported files command: %s", command)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return err
+	}
+
+	root, err := mfsRoot(user)
+	if err != nil {
+		mon.Counter("files_handler_invalid_user").Inc(1)
+		p.log.Error("Invalid user for MFS", zap.String("User", user))
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return err
+	}
+
+	query := r.URL.Query()
+	args := query["arg"]
+	if len(args) == 0 && (command == "ls" || command == "flush") {
+		args = []string{"/"}
+	}
+
+	var cids []string
+	rewritten := make([]string, len(args))
+	for i, arg := range args {
+		// Content can be copied into MFS or stat-ed from the user's pins.
+		if strings.HasPrefix(arg, "/ipfs/") && ((command == "cp" && i == 0) || command == "stat") {
+			cid, err := ipfsPathRoot(arg)
+			if err != nil {
+				mon.Counter("files_handler_invalid_path").Inc(1)
+				writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+				return err
+			}
+			cids = append(cids, cid)
+			rewritten[i] = arg
+			continue
+		}
+
+		rewritten[i], err = mfsPath(root, arg)
+		if err != nil {
+			mon.Counter("files_handler_invalid_path").Inc(1)
+			writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+			return err
+		}
+	}
+
+	if len(cids) > 0 {
+		err = p.checkOwned(ctx, user, cids)
+		if err != nil {
+			if errNotOwned.Has(err) {
+				mon.Counter("files_handler_content_not_owned").Inc(1)
+				writeIPFSError(w, http.StatusForbidden, ipfsErrClient, err.Error())
+				return err
+			}
+			mon.Counter("files_handler_error_db_list_content").Inc(1)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	err = p.ensureMFSRoot(ctx, root)
+	if err != nil {
+		mon.Counter("files_handler_error_mkdir_root").Inc(1)
+		p.log.Error("Error creating MFS root",
+			zap.String("User", user),
+			zap.Error(err))
+		http.Error(w, "error creating MFS root", http.StatusBadGateway)
+		return err
+	}
+
+	if command == "write" || command == "cp" {
+		err = p.precheckFilesQuota(ctx, w, r, user, root, command, rewritten)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(rewritten) > 0 {
+		query["arg"] = rewritten
+	}
+	r.URL.RawQuery = query.Encode()
+
+	// The content of files is passed through unchanged.
+	stripper := newPrefixStripper(w, root, command == "read")
+	p.proxy.ServeHTTP(stripper, r)
+	err = stripper.Finish()
+
+	code := stripper.StatusCode
+	mon.Counter("files_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Proxy error (code %d)", code)
+	}
+
+	return nil
+}
+
+// precheckFilesQuota checks the byte quota of user before a write or cp
+// command adds content to the MFS root of the user. The size of the MFS root
+// counts against the quota in addition to the user's pinned content, with
+// the request's Content-Length as the size of a write, and the size of the
+// source as the size of a cp. Writes of unknown length are rejected for users
+// with a limited quota.
+//
+// If the quota would be exceeded, it writes an error response to w.
+func (p *Proxy) precheckFilesQuota(ctx context.Context, w http.ResponseWriter, r *http.Request, user, root, command string, args []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	quota, limited, err := p.lookupQuota(ctx, user)
+	if err != nil {
+		p.writeQuotaError(w, user, "files", 0, err)
+		return err
+	}
+	if !limited {
+		return nil
+	}
+
+	var size int64
+	switch command {
+	case "write":
+		if r.ContentLength < 0 {
+			mon.Counter("files_handler_unknown_length").Inc(1)
+			err = errors.New("the content length of writes is required with a quota")
+			http.Error(w, err.Error(), http.StatusLengthRequired)
+			return err
+		}
+		size = r.ContentLength
+	case "cp":
+		if len(args) > 0 {
+			if strings.HasPrefix(args[0], "/ipfs/") {
+				var cid string
+				cid, err = ipfsPathRoot(args[0])
+				if err == nil {
+					size, err = p.cumulativeSize(ctx, cid)
+				}
+			} else {
+				size, err = p.mfsSize(ctx, args[0])
+			}
+		}
+	}
+
+	var rootSize int64
+	if err == nil {
+		rootSize, err = p.mfsSize(ctx, root)
+	}
+	if err != nil {
+		mon.Counter("files_handler_error_backend_stat").Inc(1)
+		p.log.Error("Error getting size of MFS content",
+			zap.String("User", user),
+			zap.Error(err))
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	usage, err := p.db.GetUsage(ctx, user)
+	if err == nil {
+		err = exceedsQuota(quota, usage, rootSize+size, 0)
+	}
+	if err != nil {
+		p.writeQuotaError(w, user, "files", size, err)
+		return err
+	}
+
+	return nil
+}
+
+// mfsRoot returns the MFS root of user.
+func mfsRoot(user string) (string, error) {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, `/\`) {
+		return "", fmt.Errorf("user name %q cannot be used as MFS root", user)
+	}
+	return path.Join(MFSUsersRoot, user), nil
+}
+
+// mfsPath rewrites the MFS path arg of a user into the user's MFS root.
+func mfsPath(root, arg string) (string, error) {
+	if !strings.HasPrefix(arg, "/") {
+		return "", errors.New("paths must start with a leading slash")
+	}
+
+	for _, segment := range strings.Split(arg, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path %q must not contain '..'", arg)
+		}
+	}
+
+	return path.Join(root, arg), nil
+}
+
+// ipfsPathRoot returns the root CID of an /ipfs/ path.
+func ipfsPathRoot(arg string) (string, error) {
+	rest := strings.TrimPrefix(arg, "/ipfs/")
+	for _, segment := range strings.Split(rest, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path %q must not contain '..'", arg)
+		}
+	}
+
+	cid := strings.SplitN(rest, "/", 2)[0]
+	if cid == "" {
+		return "", fmt.Errorf("invalid path %q", arg)
+	}
+
+	return cid, nil
+}
+
+// ensureMFSRoot creates the MFS root directory on the IPFS node if it was not
+// created yet by this proxy.
+func (p *Proxy) ensureMFSRoot(ctx context.Context, root string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if _, ok := p.mfsRoots.Load(root); ok {
+		return nil
+	}
+
+	err = p.backendCall(ctx, FilesEndpoint+"mkdir", url.Values{
+		"arg":     {root},
+		"parents": {"true"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	p.mfsRoots.Store(root, struct{}{})
+	return nil
+}
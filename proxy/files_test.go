@@ -0,0 +1,156 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestFilesHandler_RewritePaths(t *testing.T) {
+	files := new(mock.IPFSFilesHandler)
+	ipfsHandler := mock.ServeMux{}
+	for _, command := range []string{"mkdir", "ls", "stat", "read", "mv", "cp"} {
+		ipfsHandler[proxy.FilesEndpoint+command] = files
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		resp := filesRequest(t, server.URL, "john", "ls", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Entries":[{"Name":"a.txt","Type":0,"Size":0,"Hash":""}]}`, readBody(t, resp))
+
+		resp = filesRequest(t, server.URL, "john", "mv", "?arg=/docs/a.txt&arg=/docs/./b.txt")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// Check that the prefix is stripped from the response.
+		resp = filesRequest(t, server.URL, "john", "stat", "?arg=/docs/a.txt")
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.JSONEq(t, `{"Message":"/docs/a.txt: file does not exist","Code":0,"Type":"error"}`, readBody(t, resp))
+
+		// Check that the file content is not changed.
+		resp = filesRequest(t, server.URL, "john", "read", "?arg=/docs/a.txt")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/users/john/docs/a.txt", readBody(t, resp))
+
+		assert.Equal(t, []string{
+			"mkdir /users/john",
+			"ls /users/john",
+			"mv /users/john/docs/a.txt /users/john/docs/b.txt",
+			"stat /users/john/docs/a.txt",
+			"read /users/john/docs/a.txt",
+		}, files.Calls)
+	})
+}
+
+func TestFilesHandler_Rejected(t *testing.T) {
+	files := new(mock.IPFSFilesHandler)
+	ipfsHandler := mock.ServeMux{}
+	for _, command := range []string{"mkdir", "cp", "chcid"} {
+		ipfsHandler[proxy.FilesEndpoint+command] = files
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			command string
+			query   string
+			code    int
+		}{
+			{"chcid", "?arg=/", http.StatusNotFound},
+			{"mkdir", "?arg=docs", http.StatusBadRequest},
+			{"mkdir", "?arg=/docs/../../shawn", http.StatusBadRequest},
+			{"cp", "?arg=/ipfs/pin-hash-1/../pin-hash-2&arg=/b", http.StatusBadRequest},
+			{"cp", "?arg=/ipfs/pin-hash-2&arg=/b", http.StatusForbidden},
+		} {
+			resp := filesRequest(t, server.URL, "john", tc.command, tc.query)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tc.code, resp.StatusCode, tc.command+tc.query)
+		}
+		assert.Empty(t, files.Calls)
+
+		// Copy owned content into MFS.
+		resp := filesRequest(t, server.URL, "john", "cp", "?arg=/ipfs/pin-hash-1/dir&arg=/b")
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"mkdir /users/john", "cp /ipfs/pin-hash-1/dir /users/john/b"}, files.Calls)
+	})
+}
+
+func TestFilesHandler_Quota(t *testing.T) {
+	files := new(mock.IPFSFilesHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 1024},
+	}
+	for _, command := range []string{"mkdir", "write", "cp"} {
+		ipfsHandler[proxy.FilesEndpoint+command] = files
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(3000)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db, proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024})
+		require.NoError(t, err)
+
+		// The MFS root and the pinned content take 2048 bytes.
+		resp := filesWriteRequest(t, server.URL, "john", "/a.txt", strings.NewReader(strings.Repeat("a", 100)))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = filesWriteRequest(t, server.URL, "john", "/b.txt", strings.NewReader(strings.Repeat("b", 1000)))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		// Writes of unknown length are rejected.
+		resp = filesWriteRequest(t, server.URL, "john", "/c.txt", io.MultiReader(strings.NewReader("c")))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusLengthRequired, resp.StatusCode)
+
+		// The copied content is 1024 bytes.
+		resp = filesRequest(t, server.URL, "john", "cp", "?arg=/ipfs/pin-hash-1&arg=/d")
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		assert.Equal(t, []string{"mkdir /users/john", "write /users/john/a.txt"}, files.Calls)
+
+		// Users without a quota are not limited.
+		resp = filesWriteRequest(t, server.URL, "shawn", "/c.txt", io.MultiReader(strings.NewReader("c")))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func filesWriteRequest(t *testing.T, serverURL, user, path string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, serverURL+proxy.FilesEndpoint+"write?arg="+path+"&create=true", body)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func filesRequest(t *testing.T, serverURL, user, command, query string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, serverURL+proxy.FilesEndpoint+command+query, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
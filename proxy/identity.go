@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// identity is the identity of a request resolved by withIdentity from
+// credentials that are not checked per handler, like JWTs, client
+// certificates or the trusted header.
+type identity struct {
+	// user is the authenticated user.
+	user string
+	// scopes restrict the handlers the identity can call. Nil scopes are
+	// not restricted.
+	scopes []string
+	// source describes the credentials in logs and errors.
+	source string
+	// err is set if the request has credentials that were rejected.
+	err error
+}
+
+// identityKey is the context key of the identity of a request.
+type identityKey struct{}
+
+// identityFrom returns the identity resolved for the request of ctx.
+func identityFrom(ctx context.Context) (id identity, ok bool) {
+	id, ok = ctx.Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// WithJWTVerifier sets the verifier for JWT bearer tokens. If not set, all
+// bearer tokens are checked as API keys.
+func (p *Proxy) WithJWTVerifier(verifier *auth.JWTVerifier) *Proxy {
+	p.jwt = verifier
+	return p
+}
+
+// ParseTrustedProxies parses the CIDRs of the trusted proxies. Single IP
+// addresses are accepted as well.
+func ParseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// WithTrustedHeader sets the header with the user name of requests that
+// were authenticated by a proxy in front of this one, like
+// X-Authenticated-User. The header is accepted only from the trusted
+// proxies and is stripped from all other requests.
+func (p *Proxy) WithTrustedHeader(header string, proxies []*net.IPNet) *Proxy {
+	p.trustedHeader = http.CanonicalHeaderKey(header)
+	p.trustedProxies = proxies
+	return p
+}
+
+// withIdentity resolves the identity of the requests handled by next and
+// stores it in the request context, where authenticate picks it up.
+//
+// The user in the trusted header is taken as is if the request comes from
+// a trusted proxy. Otherwise, the user of a verified client certificate is
+// taken. Otherwise, bearer tokens that look like JWTs are verified with the
+// JWT verifier, if set. The quota claims of the token become the quota hint
+// of the request.
+func (p *Proxy) withIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := p.trustedHeaderUser(r); user != "" {
+			ctx := context.WithValue(r.Context(), identityKey{}, identity{user: user, source: "trusted header"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if user := p.clientCertUser(r); user != "" {
+			ctx := context.WithValue(r.Context(), identityKey{}, identity{user: user, source: "client certificate"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if p.jwt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := auth.BearerToken(r)
+		if !ok || !auth.IsJWT(token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		claims, err := p.jwt.Verify(ctx, token)
+		if err != nil {
+			ctx = context.WithValue(ctx, identityKey{}, identity{source: "JWT", err: err})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		ctx = context.WithValue(ctx, identityKey{}, identity{
+			user:   claims.User,
+			scopes: claims.Scopes,
+			source: "JWT",
+		})
+		if claims.MaxBytes != nil || claims.MaxPins != nil {
+			ctx = withQuotaHint(ctx, db.Quota{
+				User:     claims.User,
+				MaxBytes: claims.MaxBytes,
+				MaxPins:  claims.MaxPins,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// trustedHeaderUser returns the user in the trusted header of r if r comes
+// from a trusted proxy. If not, the header is stripped from r, so it is not
+// forwarded to the IPFS node either.
+func (p *Proxy) trustedHeaderUser(r *http.Request) string {
+	if p.trustedHeader == "" {
+		return ""
+	}
+
+	if !p.isTrustedProxy(r.RemoteAddr) {
+		if _, ok := r.Header[p.trustedHeader]; ok {
+			mon.Counter("trusted_header_stripped").Inc(1)
+			p.log.Warn("Stripped trusted header from untrusted client",
+				zap.String("Header", p.trustedHeader),
+				zap.String("RemoteAddr", r.RemoteAddr))
+			r.Header.Del(p.trustedHeader)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(r.Header.Get(p.trustedHeader))
+}
+
+// isTrustedProxy returns whether remoteAddr is in one of the trusted proxy
+// networks.
+func (p *Proxy) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package proxy_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/billing"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+)
+
+func TestContentIntervals_ReAdd(t *testing.T) {
+	runTest(t, new(mock.IPFSPinRmHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		content := proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024}
+
+		// Add, remove and add the same content again.
+		err := prefillDB(ctx, db, content)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		err = prefillDB(ctx, db, content)
+		require.NoError(t, err)
+
+		// Adding active content again doesn't start a new interval.
+		err = prefillDB(ctx, db, content)
+		require.NoError(t, err)
+
+		intervals, err := db.ListContentIntervals(ctx, "john", "pin-hash-1")
+		require.NoError(t, err)
+		require.Len(t, intervals, 2)
+		require.NotNil(t, intervals[0].Removed)
+		assert.False(t, intervals[1].Created.Before(*intervals[0].Removed))
+		assert.Nil(t, intervals[1].Removed)
+
+		// Check that the ledger counts both intervals, but not the gap.
+		from := intervals[0].Created.Add(-time.Hour)
+		to := time.Now().Add(time.Hour)
+
+		entries, err := billing.Ledger(ctx, db, from, to)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "john", entries[0].User)
+		assert.Equal(t, 2, entries[0].Intervals)
+
+		expected := billing.ByteHours(intervals[0], from, to) + billing.ByteHours(intervals[1], from, to)
+		assert.InDelta(t, expected, entries[0].ByteHours, 1e-6)
+
+		// Check that the usage in the gap doesn't count the content.
+		usage, err := db.GetUsageAsOf(ctx, "john", *intervals[0].Removed)
+		require.NoError(t, err)
+		assert.Equal(t, proxydb.Usage{User: "john", Removed: 1}, usage)
+
+		usage, err = db.GetUsageAsOf(ctx, "john", intervals[1].Created)
+		require.NoError(t, err)
+		assert.Equal(t, proxydb.Usage{User: "john", Bytes: 1024, Pins: 1}, usage)
+	})
+}
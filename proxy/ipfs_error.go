@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes of the IPFS HTTP API.
+const (
+	ipfsErrNormal = 0
+	ipfsErrClient = 1
+)
+
+// Error messages of the IPFS node for state that does not exist.
+const (
+	// ipfsErrNotPinnedMessage is the error message for unpinning content
+	// that is not pinned.
+	ipfsErrNotPinnedMessage = "not pinned or pinned indirectly"
+	// ipfsErrFileNotExistMessage is the end of the error message for
+	// removing an MFS path that does not exist.
+	ipfsErrFileNotExistMessage = "file does not exist"
+	// ipfsErrNoKeyMessage is the start of the error message for removing a
+	// key that does not exist.
+	ipfsErrNoKeyMessage = "no key named"
+)
+
+// IPFSErrorMessage is the JSON object returned by the IPFS HTTP API on errors.
+type IPFSErrorMessage struct {
+	Message string `json:"Message"`
+	Code    int    `json:"Code"`
+	Type    string `json:"Type"`
+}
+
+// writeIPFSError writes msg in the same error format as the IPFS HTTP API,
+// so IPFS clients can show it to the user.
+func writeIPFSError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(IPFSErrorMessage{
+		Message: msg,
+		Code:    code,
+		Type:    "error",
+	})
+}
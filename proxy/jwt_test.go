@@ -0,0 +1,152 @@
+package proxy_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+const testJWTSecret = "jwt-secret"
+
+func TestJWT_Authenticate(t *testing.T) {
+	runTestWithConfig(t, nil, configureJWT(t), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		exp := time.Now().Add(time.Hour).Unix()
+		readOnly := signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "aud": "ipfs", "scope": "pin:read"})
+		unrestricted := signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "aud": "ipfs"})
+
+		for _, tc := range []struct {
+			name     string
+			endpoint string
+			token    string
+			code     int
+		}{
+			{name: "in scope", endpoint: proxy.UsageEndpoint, token: readOnly, code: http.StatusOK},
+			{name: "out of scope", endpoint: proxy.PinRmEndpoint + "?arg=pin-hash-1", token: readOnly, code: http.StatusForbidden},
+			{name: "unrestricted", endpoint: proxy.PinLsEndpoint, token: unrestricted, code: http.StatusOK},
+			{
+				name:     "expired",
+				endpoint: proxy.UsageEndpoint,
+				token:    signTestJWT(t, map[string]interface{}{"sub": "john", "exp": time.Now().Add(-time.Hour).Unix(), "aud": "ipfs"}),
+				code:     http.StatusUnauthorized,
+			},
+			{
+				name:     "not yet valid",
+				endpoint: proxy.UsageEndpoint,
+				token:    signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "nbf": exp, "aud": "ipfs"}),
+				code:     http.StatusUnauthorized,
+			},
+			{
+				name:     "wrong audience",
+				endpoint: proxy.UsageEndpoint,
+				token:    signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "aud": "web"}),
+				code:     http.StatusUnauthorized,
+			},
+			{name: "bad signature", endpoint: proxy.UsageEndpoint, token: unrestricted + "x", code: http.StatusUnauthorized},
+		} {
+			resp := apiKeyRequest(t, server.URL+tc.endpoint, tc.token)
+			assert.Equal(t, tc.code, resp.StatusCode, tc.name)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		// The token authenticates as the user of its claim.
+		resp := apiKeyRequest(t, server.URL+proxy.UsageEndpoint, readOnly)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage proxy.UsageResponseMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john", Bytes: 1024, Pins: 1}, usage)
+
+		// API keys still work next to JWTs.
+		key := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "read", User: "john", Scopes: []string{"pin:read"}})
+		resp = apiKeyRequest(t, server.URL+proxy.UsageEndpoint, key)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestJWT_QuotaHint(t *testing.T) {
+	runTestWithConfig(t, new(mock.IPFSAddHandler), configureJWT(t), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		// The quota of the token overrides the quota in the database.
+		maxBytes := int64(1 << 20)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		exp := time.Now().Add(time.Hour).Unix()
+		limited := signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "aud": "ipfs", "quota_bytes": 1000})
+
+		req, err := addRequest(server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+limited)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, contents)
+
+		// Without the hint, the quota in the database applies.
+		unlimited := signTestJWT(t, map[string]interface{}{"sub": "john", "exp": exp, "aud": "ipfs"})
+
+		req, err = addRequest(server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+unlimited)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Len(t, hashes, 1)
+	})
+}
+
+func configureJWT(t *testing.T) func(*proxy.Proxy, *proxydb.DB) {
+	verifier, err := auth.NewJWTVerifier(context.Background(), auth.JWTConfig{
+		HMACSecret: testJWTSecret,
+		Audience:   "ipfs",
+	})
+	require.NoError(t, err)
+
+	return func(configured *proxy.Proxy, _ *proxydb.DB) {
+		configured.WithJWTVerifier(verifier)
+	}
+}
+
+func signTestJWT(t *testing.T, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(testJWTSecret))
+	_, _ = mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,416 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// KeyResponseMessage is the JSON object returned for a key to Key requests.
+type KeyResponseMessage struct {
+	Name string `json:"Name"`
+	ID   string `json:"Id"`
+}
+
+// KeyListResponseMessage is the JSON object returned to Key List and Key
+// Remove requests.
+type KeyListResponseMessage struct {
+	Keys []KeyResponseMessage `json:"Keys"`
+}
+
+// keyNamePattern is the pattern of the key names that users can choose.
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// nodeKeyName returns the name of a key of user in the keystore of the IPFS
+// node. Users share the keystore, so the names are namespaced per user with
+// the SHA-256 hash of the user name, which has a fixed length and cannot
+// contain the separator, so that no two users can get the same name.
+func nodeKeyName(user, name string) string {
+	sum := sha256.Sum256([]byte(user))
+	return "k-" + hex.EncodeToString(sum[:]) + "-" + name
+}
+
+// HandleKeyGen is an HTTP handler that intercepts
+// the /api/v0/key/gen requests to the IPFS node.
+//
+// It generates the key under a name namespaced to the authenticated user and
+// maps the key to the user. The mapping is stored in the database.
+func (p *Proxy) HandleKeyGen(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleKeyGen(r.Context(), w, r)
+}
+
+func (p *Proxy) handleKeyGen(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "key_gen")
+	if err != nil {
+		return err
+	}
+
+	backendArgs := url.Values{}
+	var name string
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "arg":
+			name = values[len(values)-1]
+		case "type", "size", "ipns-base":
+			backendArgs[param] = values
+		default:
+			mon.Counter("key_gen_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only arg, type, size and ipns-base arguments are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	if !keyNamePattern.MatchString(name) || name == "self" {
+		mon.Counter("key_gen_handler_invalid_name").Inc(1)
+		err = fmt.Errorf("invalid key name %q: must be 1 to 64 letters, digits, '.', '_' or '-', and not 'self'", name)
+		writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+		return err
+	}
+
+	_, err = p.db.GetIPNSKey(ctx, user, name)
+	switch {
+	case err == nil:
+		mon.Counter("key_gen_handler_key_exists").Inc(1)
+		err = fmt.Errorf("key with name '%s' already exists", name)
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	case !db.ErrNotFound.Has(err):
+		mon.Counter("key_gen_handler_error_db_get_key").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	nodeName := nodeKeyName(user, name)
+	backendArgs.Set("arg", nodeName)
+
+	var generated KeyResponseMessage
+	err = p.backendCall(ctx, KeyGenEndpoint, backendArgs, &generated)
+	if err != nil {
+		mon.Counter("key_gen_handler_error_backend").Inc(1)
+		p.log.Error("Error generating key",
+			zap.String("User", user),
+			zap.String("Name", name),
+			zap.Error(err))
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	err = p.db.AddIPNSKey(ctx, db.IPNSKey{
+		User:     user,
+		Name:     name,
+		NodeName: nodeName,
+		ID:       generated.ID,
+	})
+	if err != nil {
+		mon.Counter("key_gen_handler_error_db_add_key").Inc(1)
+		p.log.Error("Error adding key to database",
+			zap.String("User", user),
+			zap.String("Name", name),
+			zap.String("ID", generated.ID),
+			zap.Error(err))
+
+		// Don't leave a key on the IPFS node that no user can remove.
+		rmErr := p.backendCall(ctx, KeyRmEndpoint, url.Values{"arg": {nodeName}}, nil)
+		if rmErr != nil {
+			mon.Counter("key_gen_handler_error_backend_rm").Inc(1)
+			p.log.Error("Error removing key without owner",
+				zap.String("User", user),
+				zap.String("Name", name),
+				zap.String("NodeName", nodeName),
+				zap.Error(rmErr))
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	mon.Counter("key_gen_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(KeyResponseMessage{Name: name, ID: generated.ID})
+}
+
+// HandleKeyList is an HTTP handler that intercepts
+// the /api/v0/key/list requests to the IPFS node.
+//
+// It lists only the keys of the authenticated user from the database.
+func (p *Proxy) HandleKeyList(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleKeyList(r.Context(), w, r)
+}
+
+func (p *Proxy) handleKeyList(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "key_list")
+	if err != nil {
+		return err
+	}
+
+	for param := range r.URL.Query() {
+		switch param {
+		case "l":
+			// The IDs are always returned in the JSON response.
+			continue
+		default:
+			mon.Counter("key_list_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only l argument is allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	keys, err := p.db.ListIPNSKeys(ctx, user)
+	if err != nil {
+		mon.Counter("key_list_handler_error_db_list_keys").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	mon.Counter("key_list_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+
+	return writeKeyListResponse(w, keys)
+}
+
+// HandleKeyRm is an HTTP handler that intercepts
+// the /api/v0/key/rm requests to the IPFS node.
+//
+// It removes only keys of the authenticated user from the IPFS node and the
+// database.
+func (p *Proxy) HandleKeyRm(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleKeyRm(r.Context(), w, r)
+}
+
+func (p *Proxy) handleKeyRm(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "key_rm")
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "arg":
+			names = append(names, values...)
+		case "l":
+			continue
+		default:
+			mon.Counter("key_rm_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only arg and l arguments are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	if len(names) == 0 {
+		mon.Counter("key_rm_handler_no_args").Inc(1)
+		err = errors.New(`argument "name" is required`)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var keys []db.IPNSKey
+	var nodeNames []string
+	for _, name := range names {
+		key, err := p.db.GetIPNSKey(ctx, user, name)
+		if err != nil {
+			if db.ErrNotFound.Has(err) {
+				mon.Counter("key_rm_handler_key_not_found").Inc(1)
+				err = fmt.Errorf("no key named %s was found", name)
+				writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+				return err
+			}
+			mon.Counter("key_rm_handler_error_db_get_key").Inc(1)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		keys = append(keys, key)
+		nodeNames = append(nodeNames, key.NodeName)
+	}
+
+	err = p.backendCall(ctx, KeyRmEndpoint, url.Values{"arg": nodeNames}, nil)
+	if err != nil {
+		mon.Counter("key_rm_handler_error_backend").Inc(1)
+		p.log.Error("Error removing keys",
+			zap.String("User", user),
+			zap.Strings("Names", names),
+			zap.Error(err))
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	err = p.db.RemoveIPNSKeys(ctx, user, names)
+	if err != nil {
+		mon.Counter("key_rm_handler_error_db_remove_keys").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	mon.Counter("key_rm_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+
+	return writeKeyListResponse(w, keys)
+}
+
+func writeKeyListResponse(w http.ResponseWriter, keys []db.IPNSKey) error {
+	msg := KeyListResponseMessage{Keys: []KeyResponseMessage{}}
+	for _, key := range keys {
+		msg.Keys = append(msg.Keys, KeyResponseMessage{Name: key.Name, ID: key.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(msg)
+}
+
+// HandleNamePublish is an HTTP handler that intercepts
+// the /api/v0/name/publish requests to the IPFS node.
+//
+// It allows publishing only content pinned by the authenticated user with
+// keys of the user.
+func (p *Proxy) HandleNamePublish(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleNamePublish(r.Context(), w, r)
+}
+
+func (p *Proxy) handleNamePublish(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "name_publish")
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	for param := range query {
+		switch param {
+		case "arg", "key", "resolve", "lifetime", "ttl", "allow-offline", "quieter", "ipns-base":
+			continue
+		default:
+			mon.Counter("name_publish_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only arg, key, resolve, lifetime, ttl, allow-offline, quieter and ipns-base arguments are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	arg := query.Get("arg")
+	if !strings.HasPrefix(arg, "/") {
+		arg = "/ipfs/" + arg
+	}
+	if !strings.HasPrefix(arg, "/ipfs/") {
+		mon.Counter("name_publish_handler_invalid_path").Inc(1)
+		err = fmt.Errorf("only /ipfs/ paths can be published: %s", arg)
+		writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+		return err
+	}
+
+	cid, err := ipfsPathRoot(arg)
+	if err != nil {
+		mon.Counter("name_publish_handler_invalid_path").Inc(1)
+		writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+		return err
+	}
+
+	key, err := p.userKey(ctx, user, query.Get("key"))
+	if err != nil {
+		if db.ErrNotFound.Has(err) {
+			mon.Counter("name_publish_handler_key_not_owned").Inc(1)
+			writeIPFSError(w, http.StatusForbidden, ipfsErrClient, err.Error())
+			return err
+		}
+		mon.Counter("name_publish_handler_error_db_get_key").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	err = p.checkOwned(ctx, user, []string{cid})
+	if err != nil {
+		if errNotOwned.Has(err) {
+			mon.Counter("name_publish_handler_content_not_owned").Inc(1)
+			writeIPFSError(w, http.StatusForbidden, ipfsErrClient, err.Error())
+			return err
+		}
+		mon.Counter("name_publish_handler_error_db_list_content").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	query.Set("arg", arg)
+	query.Set("key", key.NodeName)
+	r.URL.RawQuery = query.Encode()
+
+	wrapper := NewResponseWriterWrapper(w, nil)
+	p.proxy.ServeHTTP(wrapper, r)
+
+	code := wrapper.StatusCode
+	mon.Counter("name_publish_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+
+	if code != http.StatusOK {
+		if code > 400 && code != http.StatusBadGateway {
+			// BadGateway is logged by the proxy error handler
+			p.log.Error("Proxy error",
+				zap.String("User", user),
+				zap.Int("Code", code),
+				zap.ByteString("Body", wrapper.Body))
+		}
+		return fmt.Errorf("Proxy error (code %d)", code)
+	}
+
+	return nil
+}
+
+// userKey returns the key of user with the given name or ID.
+//
+// It returns an ErrNotFound error if the user has no such key. The node's
+// self key is never returned.
+func (p *Proxy) userKey(ctx context.Context, user, nameOrID string) (key db.IPNSKey, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if nameOrID == "" || nameOrID == "self" {
+		return db.IPNSKey{}, db.ErrNotFound.New("the self key cannot be used, pass the key argument with a key generated by the user")
+	}
+
+	key, err = p.db.GetIPNSKey(ctx, user, nameOrID)
+	if !db.ErrNotFound.Has(err) {
+		return key, err
+	}
+
+	keys, listErr := p.db.ListIPNSKeys(ctx, user)
+	if listErr != nil {
+		return db.IPNSKey{}, listErr
+	}
+	for _, key := range keys {
+		if key.ID == nameOrID {
+			return key, nil
+		}
+	}
+
+	return db.IPNSKey{}, err
+}
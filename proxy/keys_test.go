@@ -0,0 +1,155 @@
+package proxy_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestKeys_GenListRm(t *testing.T) {
+	keys := new(mock.IPFSKeysHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.KeyGenEndpoint: keys,
+		proxy.KeyRmEndpoint:  keys,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		resp := keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=blog&type=ed25519", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Name":"blog","Id":"`+keyID("john", "blog")+`"}`, readBody(t, resp))
+
+		resp = keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=blog", "shawn")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+		assert.Len(t, keys.Keys, 2)
+
+		// The same user can't generate the same key twice.
+		resp = keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=blog", "john")
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// Invalid names are rejected.
+		for _, name := range []string{"", "self", "a/b"} {
+			resp = keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg="+name, "john")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, name)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		// Each user sees only their own keys.
+		resp = keysRequest(t, server.URL+proxy.KeyListEndpoint+"?l=true", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Keys":[{"Name":"blog","Id":"`+keyID("john", "blog")+`"}]}`, readBody(t, resp))
+
+		// A user can't remove the keys of others.
+		resp = keysRequest(t, server.URL+proxy.KeyRmEndpoint+"?arg=blog", "bob")
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = keysRequest(t, server.URL+proxy.KeyRmEndpoint+"?arg=blog", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Keys":[{"Name":"blog","Id":"`+keyID("john", "blog")+`"}]}`, readBody(t, resp))
+		assert.Equal(t, map[string]string{nodeKeyName("shawn", "blog"): keyID("shawn", "blog")}, keys.Keys)
+
+		resp = keysRequest(t, server.URL+proxy.KeyListEndpoint, "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Keys":[]}`, readBody(t, resp))
+	})
+}
+
+func TestNamePublish(t *testing.T) {
+	keys := new(mock.IPFSKeysHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.KeyGenEndpoint:      keys,
+		proxy.NamePublishEndpoint: keys,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		for _, user := range []string{"john", "shawn"} {
+			resp := keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=blog", user)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		for _, query := range []string{
+			"?arg=pin-hash-1",                               // the self key
+			"?arg=pin-hash-1&key=self",                      // the self key
+			"?arg=pin-hash-1&key=" + keyID("shawn", "blog"), // a key of another user
+			"?arg=pin-hash-2&key=blog",                      // content of another user
+		} {
+			resp := keysRequest(t, server.URL+proxy.NamePublishEndpoint+query, "john")
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, query)
+			require.NoError(t, resp.Body.Close())
+		}
+		assert.Empty(t, keys.Published)
+
+		resp := keysRequest(t, server.URL+proxy.NamePublishEndpoint+"?arg=/ipfs/pin-hash-1/index.html&key=blog", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Name":"`+keyID("john", "blog")+`","Value":"/ipfs/pin-hash-1/index.html"}`, readBody(t, resp))
+
+		// Publish with the key ID.
+		resp = keysRequest(t, server.URL+proxy.NamePublishEndpoint+"?arg=pin-hash-2&key="+keyID("shawn", "blog"), "shawn")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, map[string]string{
+			keyID("john", "blog"):  "/ipfs/pin-hash-1/index.html",
+			keyID("shawn", "blog"): "/ipfs/pin-hash-2",
+		}, keys.Published)
+	})
+}
+
+func TestKeys_NamespacedNames(t *testing.T) {
+	keys := new(mock.IPFSKeysHandler)
+	runTest(t, mock.ServeMux{proxy.KeyGenEndpoint: keys}, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		// The names would be the same if the user was only prefixed.
+		resp := keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=b", "john-a")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = keysRequest(t, server.URL+proxy.KeyGenEndpoint+"?arg=a-b", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, map[string]string{
+			nodeKeyName("john-a", "b"): keyID("john-a", "b"),
+			nodeKeyName("john", "a-b"): keyID("john", "a-b"),
+		}, keys.Keys)
+	})
+}
+
+// nodeKeyName returns the name of a key of user in the keystore of the IPFS
+// node.
+func nodeKeyName(user, name string) string {
+	sum := sha256.Sum256([]byte(user))
+	return "k-" + hex.EncodeToString(sum[:]) + "-" + name
+}
+
+// keyID returns the ID of a key of user generated by mock.IPFSKeysHandler.
+func keyID(user, name string) string {
+	return "k51-" + nodeKeyName(user, name)
+}
+
+func keysRequest(t *testing.T, url, user string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
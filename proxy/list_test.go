@@ -0,0 +1,168 @@
+package proxy_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestListContent(t *testing.T) {
+	runTest(t, new(mock.IPFSPinRmHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "photo-1.jpg", Size: 100},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "photo-2.jpg", Size: 200},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-3", Name: "photo-3.jpg", Size: 300},
+			proxydb.Content{User: "john", Hash: "pin-hash-4", Name: "video_1.mp4", Size: 400},
+			proxydb.Content{User: "john", Hash: "pin-hash-5", Name: "photo-5.jpg", Size: 500},
+		)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-2")
+
+		// Page through all content of john.
+		var hashes []string
+		var cursor *proxydb.ListCursor
+		for {
+			page, err := db.ListContent(ctx, proxydb.ListOptions{User: "john", Cursor: cursor, Limit: 2})
+			require.NoError(t, err)
+			for _, content := range page.Content {
+				hashes = append(hashes, content.Hash)
+			}
+			if page.Next == nil {
+				break
+			}
+			cursor = page.Next
+		}
+		assert.Equal(t, []string{"pin-hash-1", "pin-hash-2", "pin-hash-4", "pin-hash-5"}, hashes)
+
+		list := func(opts proxydb.ListOptions) []string {
+			opts.Limit = proxydb.MaxListLimit
+			page, err := db.ListContent(ctx, opts)
+			require.NoError(t, err)
+			require.Nil(t, page.Next)
+
+			hashes := []string{}
+			for _, content := range page.Content {
+				hashes = append(hashes, content.Hash)
+			}
+			return hashes
+		}
+
+		minSize, maxSize := int64(200), int64(400)
+
+		assert.Equal(t, []string{"pin-hash-5", "pin-hash-4", "pin-hash-3", "pin-hash-2", "pin-hash-1"},
+			list(proxydb.ListOptions{Order: proxydb.Descending}))
+		assert.Equal(t, []string{"pin-hash-1", "pin-hash-4", "pin-hash-5"},
+			list(proxydb.ListOptions{User: "john", Status: proxydb.ActiveStatus}))
+		assert.Equal(t, []string{"pin-hash-2"},
+			list(proxydb.ListOptions{Status: proxydb.RemovedStatus}))
+		assert.Equal(t, []string{"pin-hash-1", "pin-hash-2", "pin-hash-3", "pin-hash-5"},
+			list(proxydb.ListOptions{NamePrefix: "photo-"}))
+		assert.Equal(t, []string{"pin-hash-2", "pin-hash-3", "pin-hash-4"},
+			list(proxydb.ListOptions{MinSize: &minSize, MaxSize: &maxSize}))
+
+		// Check the created and removed windows.
+		page, err := db.ListContent(ctx, proxydb.ListOptions{Limit: proxydb.MaxListLimit})
+		require.NoError(t, err)
+		require.Len(t, page.Content, 5)
+		removed := *page.Content[1].Removed
+		created := page.Content[2].Created
+
+		assert.Equal(t, []string{"pin-hash-3", "pin-hash-4", "pin-hash-5"},
+			list(proxydb.ListOptions{CreatedAfter: &created}))
+		assert.Equal(t, []string{"pin-hash-1", "pin-hash-2"},
+			list(proxydb.ListOptions{CreatedBefore: &created}))
+		assert.Equal(t, []string{"pin-hash-2"},
+			list(proxydb.ListOptions{RemovedAfter: &removed}))
+
+		// Check the limit.
+		_, err = db.ListContent(ctx, proxydb.ListOptions{})
+		require.Error(t, err)
+		_, err = db.ListContent(ctx, proxydb.ListOptions{Limit: proxydb.MaxListLimit + 1})
+		require.Error(t, err)
+	})
+}
+
+func TestListCursor(t *testing.T) {
+	_, err := proxydb.ParseListCursor("invalid")
+	require.Error(t, err)
+
+	// Cursors without the user are rejected.
+	_, err = proxydb.ParseListCursor(base64.RawURLEncoding.EncodeToString([]byte("2023-01-02T03:04:05Z pin-hash-1")))
+	require.Error(t, err)
+
+	// User names can contain spaces.
+	cursor := proxydb.ListCursor{
+		Created: time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC),
+		Hash:    "pin-hash-1",
+		User:    "john smith",
+	}
+	parsed, err := proxydb.ParseListCursor(cursor.String())
+	require.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+}
+
+func TestPinLsHandle_Pagination(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-3", Name: "third.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-4", Name: "forth.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// Get the first page.
+		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+"?limit=2", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.JSONEq(t, `{"Keys":{"pin-hash-1":{"Type":"recursive"},"pin-hash-3":{"Type":"recursive"}}}`, string(respBody))
+
+		cursor := resp.Header.Get(proxy.NextCursorHeader)
+		require.NotEmpty(t, cursor)
+
+		// Get the last page.
+		req, err = pinLsRequest(server.URL+proxy.PinLsEndpoint+"?limit=2&cursor="+url.QueryEscape(cursor), "john")
+		require.NoError(t, err)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		respBody, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.JSONEq(t, `{"Keys":{"pin-hash-4":{"Type":"recursive"}}}`, string(respBody))
+		assert.Empty(t, resp.Header.Get(proxy.NextCursorHeader))
+
+		// Check invalid options.
+		for _, query := range []string{"?limit=0", "?limit=x", "?cursor=invalid", "?limit=1&arg=pin-hash-1"} {
+			req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+query, "john")
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
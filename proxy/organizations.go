@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// OrganizationHeader is the header of requests that act on behalf of an
+// organization. The content of such requests is owned by the organization
+// and all its members can list and remove it.
+const OrganizationHeader = "X-Organization"
+
+// organizationHandlers are the handlers that can act on behalf of an
+// organization. The MFS roots and IPNS keys stay per user.
+var organizationHandlers = map[string]bool{
+	"add":        true,
+	"dag_import": true,
+	"pin_add":    true,
+	"pin_ls":     true,
+	"pin_rm":     true,
+	"pin_update": true,
+	"usage":      true,
+	"cat":        true,
+	"get":        true,
+	"ls":         true,
+	"dag_export": true,
+}
+
+// resolveOwner returns the owner of the content of a request of user with
+// the scopes of its credentials.
+//
+// The organization of the request is taken from the X-Organization header
+// or, without the header, from the only organization scope of the
+// credentials. If the credentials have organization scopes, the header must
+// name one of them. If there is an organization, user must be a member and
+// the owner is the organization. Otherwise, the owner is user.
+//
+// If the owner cannot be resolved, it writes an error response to w and
+// counts the response code under the response codes series of handler.
+func (p *Proxy) resolveOwner(ctx context.Context, w http.ResponseWriter, r *http.Request, user string, scopes []string, handler string) (owner string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	fail := func(code int, err error, fields ...zap.Field) error {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+		p.log.Error("Error resolving owner", append(fields, zap.String("User", user), zap.Error(err))...)
+		http.Error(w, err.Error(), code)
+		return err
+	}
+
+	if db.IsOrganizationOwner(user) {
+		return "", fail(http.StatusForbidden, fmt.Errorf("user names cannot start with %q", db.OrganizationOwnerPrefix))
+	}
+
+	org := strings.TrimSpace(r.Header.Get(OrganizationHeader))
+	granted := auth.Organizations(scopes)
+
+	switch {
+	case org != "" && len(granted) > 0 && !contains(granted, org):
+		return "", fail(http.StatusForbidden, fmt.Errorf("credentials are not scoped to organization %q", org))
+	case org == "" && len(granted) == 1:
+		org = granted[0]
+	case org == "" && len(granted) > 1:
+		return "", fail(http.StatusBadRequest, fmt.Errorf("credentials are scoped to several organizations, the %s header is required", OrganizationHeader))
+	}
+
+	if org == "" {
+		return user, nil
+	}
+
+	if !organizationHandlers[handler] {
+		return "", fail(http.StatusBadRequest, errors.New("the request cannot act on behalf of an organization"), zap.String("Organization", org))
+	}
+
+	member, err := p.db.IsMember(ctx, org, user)
+	if err != nil {
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusInternalServerError))).Inc(1)
+		p.log.Error("Error checking membership", zap.String("User", user), zap.String("Organization", org), zap.Error(err))
+		http.Error(w, "error checking membership", http.StatusInternalServerError)
+		return "", err
+	}
+
+	if !member {
+		return "", fail(http.StatusForbidden, fmt.Errorf("not a member of organization %q", org), zap.String("Organization", org))
+	}
+
+	return db.OrganizationOwner(org), nil
+}
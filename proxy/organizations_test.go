@@ -0,0 +1,235 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestOrganizations_SharedContent(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinAddEndpoint: new(mock.IPFSPinAddHandler),
+		proxy.PinRmEndpoint:  pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		require.NoError(t, db.AddOrganization(ctx, "acme"))
+		require.NoError(t, db.AddMember(ctx, "acme", "john"))
+		require.NoError(t, db.AddMember(ctx, "acme", "shawn"))
+
+		// John pins on behalf of the organization.
+		req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+"?arg=pin-hash-1", "john")
+		require.NoError(t, err)
+		req.Header.Set(proxy.OrganizationHeader, "acme")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		owner := proxydb.OrganizationOwner("acme")
+		hashes, err := db.ListActiveContentByUser(ctx, owner)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+
+		hashes, err = db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+
+		// Shawn sees the content only on behalf of the organization.
+		assert.Equal(t, []string{"pin-hash-1"}, orgPinLs(t, server.URL, "shawn", "acme"))
+		assert.Empty(t, orgPinLs(t, server.URL, "shawn", ""))
+
+		// Shawn unpins the content of the organization.
+		req, err = pinRmRequest(server.URL+proxy.PinRmEndpoint, "shawn", "pin-hash-1")
+		require.NoError(t, err)
+		req.Header.Set(proxy.OrganizationHeader, "acme")
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+
+		hashes, err = db.ListActiveContentByUser(ctx, owner)
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+
+		// The events record the member who made each change.
+		events, err := db.ListContentEventsByUser(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, proxydb.ContentEventAdd, events[0].Type)
+		assert.Equal(t, "john", events[0].Actor)
+		assert.Equal(t, proxydb.ContentEventRemove, events[1].Type)
+		assert.Equal(t, "shawn", events[1].Actor)
+	})
+}
+
+func TestOrganizations_Membership(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		require.NoError(t, db.AddOrganization(ctx, "acme"))
+		require.NoError(t, db.AddMember(ctx, "acme", "john"))
+		assert.True(t, proxydb.ErrNotFound.Has(db.AddMember(ctx, "unknown", "john")))
+
+		for _, tc := range []struct {
+			user     string
+			org      string
+			endpoint string
+			code     int
+		}{
+			{user: "john", org: "acme", endpoint: proxy.UsageEndpoint, code: http.StatusOK},
+			{user: "shawn", org: "acme", endpoint: proxy.UsageEndpoint, code: http.StatusForbidden},
+			{user: "john", org: "unknown", endpoint: proxy.UsageEndpoint, code: http.StatusForbidden},
+			{user: "john", org: "acme", endpoint: proxy.KeyListEndpoint, code: http.StatusBadRequest},
+		} {
+			req, err := pinLsRequest(server.URL+tc.endpoint, tc.user)
+			require.NoError(t, err)
+			req.Header.Set(proxy.OrganizationHeader, tc.org)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tc.code, resp.StatusCode, "%s %s %s", tc.user, tc.org, tc.endpoint)
+		}
+
+		// Removed members cannot act on behalf of the organization anymore.
+		require.NoError(t, db.RemoveMember(ctx, "acme", "john"))
+		assert.True(t, proxydb.ErrNotFound.Has(db.RemoveMember(ctx, "acme", "john")))
+
+		req, err := pinLsRequest(server.URL+proxy.UsageEndpoint, "john")
+		require.NoError(t, err)
+		req.Header.Set(proxy.OrganizationHeader, "acme")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestOrganizations_APIKeyScope(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		require.NoError(t, db.AddOrganization(ctx, "acme"))
+		require.NoError(t, db.AddOrganization(ctx, "other"))
+		require.NoError(t, db.AddMember(ctx, "acme", "john"))
+		require.NoError(t, db.AddMember(ctx, "other", "john"))
+
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: proxydb.OrganizationOwner("acme"), Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+		)
+		require.NoError(t, err)
+
+		key := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "org", User: "john", Scopes: []string{"pin:read", "org:acme"}})
+
+		// The key acts on behalf of its organization without the header.
+		resp := apiKeyRequest(t, server.URL+proxy.UsageEndpoint, key)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage proxy.UsageResponseMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.UsageResponseMessage{User: "org:acme", Bytes: 1024, Pins: 1}, usage)
+
+		// The key cannot act on behalf of other organizations.
+		req, err := http.NewRequest(http.MethodPost, server.URL+proxy.UsageEndpoint, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set(proxy.OrganizationHeader, "other")
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestOrganizations_Remove(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		require.NoError(t, db.AddOrganization(ctx, "acme"))
+		require.NoError(t, db.AddMember(ctx, "acme", "john"))
+		require.NoError(t, db.AddMember(ctx, "acme", "shawn"))
+
+		owner := proxydb.OrganizationOwner("acme")
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: owner, Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: owner, Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+		)
+		require.NoError(t, err)
+
+		expected := proxydb.OrganizationRemoval{
+			Organization: "acme",
+			Members:      []string{"john", "shawn"},
+			Removed:      2,
+			BytesFreed:   1024,
+		}
+
+		// The dry run doesn't change anything.
+		removal, err := db.RemoveOrganization(ctx, "acme", proxydb.EventSource{}, true)
+		require.NoError(t, err)
+		require.Len(t, removal.Exclusive, 1)
+		assert.Equal(t, "pin-hash-1", removal.Exclusive[0].Hash)
+		removal.Exclusive = nil
+		assert.Equal(t, expected, removal)
+
+		member, err := db.IsMember(ctx, "acme", "john")
+		require.NoError(t, err)
+		assert.True(t, member)
+
+		// The content of the organization is removed with it, and the content
+		// that no user has is queued for unpinning.
+		removal, err = db.RemoveOrganization(ctx, "acme", proxydb.EventSource{}, false)
+		require.NoError(t, err)
+		removal.Exclusive = nil
+		assert.Equal(t, expected, removal)
+
+		hashes, err := db.ListActiveContentByUser(ctx, owner)
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+
+		hashes, err = db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, hashes)
+
+		unpins, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, unpins, 1)
+		assert.Equal(t, "pin-hash-1", unpins[0].Hash)
+
+		member, err = db.IsMember(ctx, "acme", "john")
+		require.NoError(t, err)
+		assert.False(t, member)
+
+		_, err = db.RemoveOrganization(ctx, "acme", proxydb.EventSource{}, false)
+		assert.True(t, proxydb.ErrNotFound.Has(err))
+	})
+}
+
+// orgPinLs lists the pins of user on behalf of org, if not empty.
+func orgPinLs(t *testing.T, serverURL, user, org string) []string {
+	req, err := pinLsRequest(serverURL+proxy.PinLsEndpoint, user)
+	require.NoError(t, err)
+	if org != "" {
+		req.Header.Set(proxy.OrganizationHeader, org)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var ls proxy.PinLsResponseMessage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ls))
+
+	var hashes []string
+	for hash := range ls.Keys {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/zeebo/errs"
+)
+
+// errNotOwned is the error class for content that the user doesn't have pinned.
+var errNotOwned = errs.Class("not owned")
+
+// checkOwned returns an errNotOwned error if user doesn't have all hashes
+// active.
+func (p *Proxy) checkOwned(ctx context.Context, user string, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	owners, err := p.db.ListActiveContentByHash(ctx, hashes)
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool, len(owners))
+	for _, owner := range owners {
+		if owner.User == user {
+			owned[owner.Hash] = true
+		}
+	}
+
+	for _, hash := range hashes {
+		if !owned[hash] {
+			return errNotOwned.New("%s", hash)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// PassthroughAction is the action of the passthrough policy for an endpoint.
+type PassthroughAction string
+
+const (
+	// PassthroughPass forwards the requests to the IPFS node unchanged,
+	// without authentication.
+	PassthroughPass PassthroughAction = "pass"
+	// PassthroughBlock rejects the requests.
+	PassthroughBlock PassthroughAction = "block"
+	// PassthroughAdmin forwards the requests of users with the admin role to
+	// the IPFS node unchanged and rejects all others.
+	PassthroughAdmin PassthroughAction = "admin"
+)
+
+// PassthroughEndpoint is the policy for an endpoint of the IPFS HTTP API that
+// is not intercepted by the proxy.
+type PassthroughEndpoint struct {
+	// Path is the path of the endpoint, like /api/v0/version. Paths ending
+	// with a slash match all paths under them.
+	Path string `yaml:"path"`
+	// Action is what to do with the requests to the endpoint.
+	Action PassthroughAction `yaml:"action"`
+	// Methods are the allowed HTTP methods. All methods are allowed if empty.
+	Methods []string `yaml:"methods"`
+	// Params are the allowed query parameters. All parameters are allowed
+	// if empty.
+	Params []string `yaml:"params"`
+}
+
+// PassthroughPolicy is the policy for the endpoints of the IPFS HTTP API that
+// are not intercepted by the proxy. Requests to endpoints not in the policy
+// are rejected with 404 Not Found.
+type PassthroughPolicy struct {
+	// Endpoints are the policies of the endpoints.
+	Endpoints []PassthroughEndpoint `yaml:"endpoints"`
+}
+
+// LoadPassthroughPolicy reads a passthrough policy from a YAML or JSON file.
+func LoadPassthroughPolicy(path string) (*PassthroughPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so the YAML decoder reads both.
+	var policy PassthroughPolicy
+	err = yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passthrough policy %q: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+// WithPassthroughPolicy sets the path to the passthrough policy file. The
+// policy is loaded when the proxy starts and reloaded on SIGHUP.
+func (p *Proxy) WithPassthroughPolicy(path string) *Proxy {
+	p.policyFile = path
+	return p
+}
+
+// ReloadPassthroughPolicy loads the passthrough policy file and replaces the
+// passthrough endpoints of the proxy with it. If the policy is invalid, the
+// current endpoints are kept.
+func (p *Proxy) ReloadPassthroughPolicy(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if p.policyFile == "" {
+		return nil
+	}
+
+	policy, err := LoadPassthroughPolicy(p.policyFile)
+	if err != nil {
+		return err
+	}
+
+	err = p.validatePassthroughPolicy(policy)
+	if err != nil {
+		return fmt.Errorf("invalid passthrough policy %q: %w", p.policyFile, err)
+	}
+
+	p.mux.Store(p.buildMux(policy))
+
+	p.log.Info("Loaded passthrough policy",
+		zap.String("File", p.policyFile),
+		zap.Int("Endpoints", len(policy.Endpoints)))
+
+	return nil
+}
+
+// reloadOnSignal reloads the passthrough policy on every SIGHUP until ctx is
+// canceled.
+func (p *Proxy) reloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			err := p.ReloadPassthroughPolicy(ctx)
+			if err != nil {
+				mon.Counter("passthrough_policy_reload_errors").Inc(1)
+				p.log.Error("Error reloading passthrough policy, keeping the current one", zap.Error(err))
+			}
+		}
+	}
+}
+
+// validatePassthroughPolicy returns an error if policy has unknown actions,
+// duplicate endpoints or endpoints intercepted by the proxy.
+func (p *Proxy) validatePassthroughPolicy(policy *PassthroughPolicy) error {
+	routes := p.routes()
+	seen := make(map[string]bool)
+	for _, endpoint := range policy.Endpoints {
+		switch endpoint.Action {
+		case PassthroughPass, PassthroughBlock, PassthroughAdmin:
+		default:
+			return fmt.Errorf("unknown action %q for endpoint %q", endpoint.Action, endpoint.Path)
+		}
+
+		if !strings.HasPrefix(endpoint.Path, "/") {
+			return fmt.Errorf("endpoint %q must start with a slash", endpoint.Path)
+		}
+
+		if seen[endpoint.Path] {
+			return fmt.Errorf("duplicate endpoint %q", endpoint.Path)
+		}
+		seen[endpoint.Path] = true
+
+		for route := range routes {
+			if overlapsRoute(route, endpoint.Path) {
+				return fmt.Errorf("endpoint %q is intercepted by the proxy", endpoint.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// overlapsRoute returns whether the endpoint path of a policy would match
+// requests to an intercepted route, to paths under it or to its variants
+// with and without a trailing slash. The IPFS node ignores the trailing
+// slash, so such endpoints would bypass the proxy.
+func overlapsRoute(route, path string) bool {
+	trimmed := strings.TrimSuffix(route, "/")
+	switch {
+	case path == route || path == trimmed:
+		return true
+	case strings.HasPrefix(path, trimmed+"/"):
+		// The path is under the route or is its trailing slash variant.
+		return true
+	case strings.HasSuffix(path, "/") && strings.HasPrefix(route, path):
+		// The path matches all paths under it, including the route.
+		return true
+	}
+	return false
+}
+
+// buildMux returns a new mux with the intercepted endpoints and the endpoints
+// of policy. The paths under the intercepted endpoints that are not
+// intercepted themselves return 404 Not Found. The policy must be valid.
+func (p *Proxy) buildMux(policy *PassthroughPolicy) *http.ServeMux {
+	routes := p.routes()
+	mux := http.NewServeMux()
+	for route, handler := range routes {
+		mux.HandleFunc(route, handler)
+
+		// The IPFS node ignores the trailing slash, so the paths under the
+		// intercepted endpoints must not reach it.
+		if _, ok := routes[route+"/"]; !ok && !strings.HasSuffix(route, "/") {
+			mux.Handle(route+"/", http.NotFoundHandler())
+		}
+	}
+
+	if policy == nil {
+		return mux
+	}
+
+	for _, endpoint := range policy.Endpoints {
+		mux.Handle(endpoint.Path, &passthroughHandler{
+			proxy:    p,
+			endpoint: endpoint,
+		})
+	}
+
+	return mux
+}
+
+// passthroughHandler is an HTTP handler that applies the passthrough policy of
+// an endpoint to the requests.
+type passthroughHandler struct {
+	proxy    *Proxy
+	endpoint PassthroughEndpoint
+}
+
+func (h *passthroughHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = h.handle(r.Context(), w, r)
+}
+
+func (h *passthroughHandler) handle(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	p := h.proxy
+	endpointTag := monkit.NewSeriesTag("endpoint", h.endpoint.Path)
+
+	reject := func(code int, msg string) error {
+		mon.Counter("passthrough_handler_response_codes", endpointTag, monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+		writeIPFSError(w, code, ipfsErrClient, msg)
+		return fmt.Errorf("%s (code %d)", msg, code)
+	}
+
+	if h.endpoint.Action == PassthroughBlock {
+		return reject(http.StatusForbidden, fmt.Sprintf("endpoint %s is blocked", r.URL.Path))
+	}
+
+	if len(h.endpoint.Methods) > 0 && !containsFold(h.endpoint.Methods, r.Method) {
+		return reject(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+	}
+
+	if len(h.endpoint.Params) > 0 {
+		params := make([]string, 0, len(r.URL.Query()))
+		for param := range r.URL.Query() {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+
+		for _, param := range params {
+			if !contains(h.endpoint.Params, param) {
+				mon.Counter("passthrough_handler_invalid_query_param", endpointTag, monkit.NewSeriesTag("param", param)).Inc(1)
+				return reject(http.StatusBadRequest, fmt.Sprintf("parameter %q not allowed", param))
+			}
+		}
+	}
+
+	if h.endpoint.Action == PassthroughAdmin {
+		_, err := p.authenticateAdmin(ctx, w, r, "passthrough")
+		if err != nil {
+			return err
+		}
+	}
+
+	wrapper := NewResponseWriterWrapper(w, nil)
+	p.proxy.ServeHTTP(wrapper, r)
+
+	code := wrapper.StatusCode
+	mon.Counter("passthrough_handler_response_codes", endpointTag, monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+
+	if code != http.StatusOK {
+		if code > 400 && code != http.StatusBadGateway {
+			// BadGateway is logged by the proxy error handler
+			p.log.Error("Proxy error",
+				zap.Int("Code", code),
+				zap.ByteString("Body", wrapper.Body))
+		}
+		return fmt.Errorf("Proxy error (code %d)", code)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
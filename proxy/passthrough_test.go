@@ -0,0 +1,141 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestPassthrough(t *testing.T) {
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/version":     new(mock.NoopHandler),
+		"/api/v0/id":          new(mock.NoopHandler),
+		"/api/v0/repo/gc":     new(mock.NoopHandler),
+		"/api/v0/config/show": new(mock.NoopHandler),
+	}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, db *proxydb.DB) {
+		withPasswordVerifier(configured, db)
+		p = configured
+	}
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		policyFile := writePolicy(t, `
+endpoints:
+  - path: /api/v0/version
+    action: pass
+  - path: /api/v0/id
+    action: pass
+    methods: [POST]
+    params: [format]
+  - path: /api/v0/repo/gc
+    action: admin
+  - path: /api/v0/config/show
+    action: block
+`)
+		p.WithAdmins([]string{"admin"})
+		require.NoError(t, p.WithPassthroughPolicy(policyFile).ReloadPassthroughPolicy(ctx))
+
+		for _, tc := range []struct {
+			method string
+			path   string
+			user   string
+			code   int
+		}{
+			{method: http.MethodPost, path: "/api/v0/version", code: http.StatusOK},
+			{method: http.MethodPost, path: "/api/v0/id?format=text", code: http.StatusOK},
+			{method: http.MethodGet, path: "/api/v0/id", code: http.StatusMethodNotAllowed},
+			{method: http.MethodPost, path: "/api/v0/id?peer=abc", code: http.StatusBadRequest},
+			{method: http.MethodPost, path: "/api/v0/repo/gc", code: http.StatusUnauthorized},
+			{method: http.MethodPost, path: "/api/v0/repo/gc", user: "john", code: http.StatusForbidden},
+			{method: http.MethodPost, path: "/api/v0/repo/gc", user: "admin", code: http.StatusOK},
+			{method: http.MethodPost, path: "/api/v0/config/show", user: "admin", code: http.StatusForbidden},
+			{method: http.MethodPost, path: "/api/v0/shutdown", user: "admin", code: http.StatusNotFound},
+			{method: http.MethodPost, path: "/api/v0/pin/rm/?arg=pin-hash-1", user: "admin", code: http.StatusNotFound},
+			{method: http.MethodPost, path: "/api/v0/add/", user: "admin", code: http.StatusNotFound},
+		} {
+			resp := passthroughRequest(t, tc.method, server.URL+tc.path, tc.user)
+			assert.Equal(t, tc.code, resp.StatusCode, tc.method+" "+tc.path+" "+tc.user)
+			require.NoError(t, resp.Body.Close())
+		}
+	})
+}
+
+func TestPassthrough_Reload(t *testing.T) {
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/version": new(mock.NoopHandler),
+		"/api/v0/id":      new(mock.NoopHandler),
+	}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		policyFile := writePolicy(t, `{"endpoints": [{"path": "/api/v0/version", "action": "pass"}]}`)
+		require.NoError(t, p.WithPassthroughPolicy(policyFile).ReloadPassthroughPolicy(ctx))
+
+		assertCode := func(path string, code int) {
+			resp := passthroughRequest(t, http.MethodPost, server.URL+path, "")
+			assert.Equal(t, code, resp.StatusCode, path)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		assertCode("/api/v0/version", http.StatusOK)
+		assertCode("/api/v0/id", http.StatusNotFound)
+
+		require.NoError(t, os.WriteFile(policyFile, []byte(`{"endpoints": [{"path": "/api/v0/id", "action": "pass"}]}`), 0644))
+		require.NoError(t, p.ReloadPassthroughPolicy(ctx))
+
+		assertCode("/api/v0/version", http.StatusNotFound)
+		assertCode("/api/v0/id", http.StatusOK)
+
+		// Invalid policies are rejected and the current one is kept.
+		for _, policy := range []string{
+			`{"endpoints": [{"path": "/api/v0/version", "action": "allow"}]}`,
+			`{"endpoints": [{"path": "/api/v0/pin/ls", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/api/v0/files/chcid", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/api/v0/", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/api/v0/pin/rm/", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/api/v0/files", "action": "pass"}]}`,
+			`{"endpoints": [{"path": "/api/v0/id", "action": "pass"}, {"path": "/api/v0/id", "action": "block"}]}`,
+			`{"endpoints": `,
+		} {
+			require.NoError(t, os.WriteFile(policyFile, []byte(policy), 0644))
+			require.Error(t, p.ReloadPassthroughPolicy(ctx), policy)
+		}
+
+		assertCode("/api/v0/version", http.StatusNotFound)
+		assertCode("/api/v0/id", http.StatusOK)
+	})
+}
+
+func writePolicy(t *testing.T, policy string) string {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(policy), 0644))
+	return path
+}
+
+func passthroughRequest(t *testing.T, method, url, user string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if user != "" {
+		req.SetBasicAuth(user, "somepassword")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
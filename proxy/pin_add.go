@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// PinAddResponseMessage is the JSON object returned to Pin Add requests.
+// With the progress argument, messages with only Progress are streamed before
+// the final message with Pins.
+type PinAddResponseMessage struct {
+	Pins     []string `json:"Pins,omitempty"`
+	Progress int      `json:"Progress,omitempty"`
+}
+
+// HandlePinAdd is an HTTP handler that intercepts
+// the /api/v0/pin/add requests to the IPFS node.
+//
+// It retrieves the authenticated user from the requests and maps it to the
+// pinned content. The mapping is stored in the database.
+func (p *Proxy) HandlePinAdd(w http.ResponseWriter, r *http.Request) {
+	_ = p.handlePinAdd(r.Context(), w, r)
+}
+
+func (p *Proxy) handlePinAdd(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "pin_add")
+	if err != nil {
+		return err
+	}
+
+	var toPin []string
+	var name string
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "arg":
+			toPin = append(toPin, values...)
+			continue
+		case "progress":
+			continue
+		case "name":
+			name = values[len(values)-1]
+			continue
+		case "recursive":
+			if recursive, err := strconv.ParseBool(values[len(values)-1]); err == nil && recursive {
+				continue
+			}
+			mon.Counter("pin_add_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only recursive pins are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		default:
+			mon.Counter("pin_add_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only arg, recursive, progress and name arguments are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	if len(toPin) == 0 {
+		mon.Counter("pin_add_handler_no_args").Inc(1)
+		p.log.Error("No args", zap.String("User", user))
+		err = errors.New(`argument "ipfs-path" is required`)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var messages pinAddResponseMessages
+	wrapper := NewResponseWriterWrapper(w, messages.collect)
+	p.proxy.ServeHTTP(wrapper, r)
+
+	code := wrapper.StatusCode
+	mon.Counter("pin_add_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+
+	if code != http.StatusOK {
+		if code > 400 && code != http.StatusBadGateway {
+			// BadGateway is logged by the proxy error handler
+			p.log.Error("Proxy error",
+				zap.String("User", user),
+				zap.Int("Code", code),
+				zap.ByteString("Body", wrapper.Body))
+		}
+		return fmt.Errorf("Proxy error (code %d)", code)
+	}
+
+	err = wrapper.Finish()
+	if err != nil {
+		mon.Counter("pin_add_handler_error_unmarshal_response").Inc(1)
+		p.log.Error("JSON response unmarshal error",
+			zap.String("User", user),
+			zap.Error(err))
+		return err
+	}
+
+	if len(messages.pins) == 0 {
+		mon.Counter("pin_add_handler_error_no_pins").Inc(1)
+		p.log.Error("No pins in response",
+			zap.String("User", user))
+		return errors.New("no pins in response")
+	}
+
+	sizes := make(map[string]int64, len(messages.pins))
+	for _, cid := range messages.pins {
+		size, err := p.cumulativeSize(ctx, cid)
+		if err != nil {
+			mon.Counter("pin_add_handler_error_backend_stat").Inc(1)
+			p.log.Error("Error getting size of pinned content",
+				zap.String("User", user),
+				zap.String("CID", cid),
+				zap.Error(err))
+			w.Header().Set(http.TrailerPrefix+StreamErrorTrailer, "error getting size of pinned content")
+			// The content cannot be mapped without its size, so it must
+			// not stay pinned on the IPFS node without an owner.
+			for _, cid := range messages.pins {
+				p.unpinUnowned(ctx, "pin_add_handler_error_unpin_unowned", user, cid)
+			}
+			return err
+		}
+		sizes[cid] = size
+	}
+
+	contents := make([]db.Content, 0, len(messages.pins))
+	for _, cid := range messages.pins {
+		contentName := name
+		if contentName == "" {
+			contentName = cid + " (pin add)"
+		}
+		contents = append(contents, db.Content{
+			User: user,
+			Hash: cid,
+			Name: contentName,
+			Size: sizes[cid],
+		})
+	}
+
+	err = p.addWithinQuota(ctx, wrapper, "pin_add", contents, eventSource(ctx))
+	if err != nil {
+		if isQuotaExceeded(err) {
+			return err
+		}
+		mon.Counter("pin_add_handler_error_db_add").Inc(1)
+		p.log.Error("Error adding content to database",
+			zap.String("User", user),
+			zap.Strings("Hashes", messages.pins),
+			zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// pinAddResponseMessages collects the pinned CIDs of a Pin Add response.
+type pinAddResponseMessages struct {
+	pins []string
+}
+
+func (messages *pinAddResponseMessages) collect(data []byte) error {
+	var msg PinAddResponseMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return err
+	}
+
+	for _, pin := range msg.Pins {
+		messages.pins = append(messages.pins, strings.TrimPrefix(pin, "/ipfs/"))
+	}
+
+	return nil
+}
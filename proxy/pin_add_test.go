@@ -0,0 +1,162 @@
+package proxy_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestPinAddHandler_MissingArg(t *testing.T) {
+	pinAdd := new(mock.IPFSPinAddHandler)
+	runTest(t, pinAdd, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		for _, query := range []string{"", "?arg=pin-hash-1&recursive=false", "?arg=pin-hash-1&unknown=1"} {
+			req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+query, "john")
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+		assert.False(t, pinAdd.Invoked)
+	})
+}
+
+func TestPinAddHandler_Basic(t *testing.T) {
+	pinAdd := new(mock.IPFSPinAddHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinAddEndpoint: pinAdd,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+"?arg=pin-hash-1&arg=/ipfs/pin-hash-2&recursive=true", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"pin-hash-1", "/ipfs/pin-hash-2"}, pinAdd.Pinned)
+
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, contents, 2)
+		for _, content := range contents {
+			assert.Equal(t, "john", content.User)
+			assert.Equal(t, content.Hash+" (pin add)", content.Name)
+			assert.EqualValues(t, 2048, content.Size)
+		}
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2"}, []string{contents[0].Hash, contents[1].Hash})
+	})
+}
+
+func TestPinAddHandler_Progress(t *testing.T) {
+	ipfsHandler := mock.ServeMux{
+		proxy.PinAddEndpoint: new(mock.IPFSPinAddHandler),
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+"?arg=pin-hash-1&progress=true&name=first.jpg", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		lines := strings.Split(strings.TrimSpace(string(respBody)), "\n")
+		require.Len(t, lines, 2)
+		assert.JSONEq(t, `{"Progress":1}`, lines[0])
+		assert.JSONEq(t, `{"Pins":["pin-hash-1"]}`, lines[1])
+
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, contents, 1)
+		assert.Equal(t, "pin-hash-1", contents[0].Hash)
+		assert.Equal(t, "first.jpg", contents[0].Name)
+	})
+}
+
+func TestPinAddHandler_QuotaExceeded(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinAddEndpoint: new(mock.IPFSPinAddHandler),
+		proxy.PinRmEndpoint:  pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(1024)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+"?arg=pin-hash-1", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// Check that the content is unpinned and not mapped to the user.
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, contents)
+	})
+}
+
+func TestPinAddHandler_StatError(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinAddEndpoint: new(mock.IPFSPinAddHandler),
+		proxy.PinRmEndpoint:  pinRm,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db, proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 1024})
+		require.NoError(t, err)
+
+		req, err := pinAddRequest(server.URL+proxy.PinAddEndpoint+"?arg=pin-hash-1&arg=pin-hash-2", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.NotEmpty(t, resp.Trailer.Get(proxy.StreamErrorTrailer))
+
+		// Check that only the content without owner is unpinned.
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+	})
+}
+
+func pinAddRequest(url, user string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(user) > 0 {
+		req.SetBasicAuth(user, "somepassword")
+	}
+
+	return req, nil
+}
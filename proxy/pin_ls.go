@@ -4,11 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/spacemonkeygo/monkit/v3"
 	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
 )
 
 // PinLsResponseMessage is the JSON object returned to Pin List requests.
@@ -16,6 +21,13 @@ type PinLsResponseMessage struct {
 	Keys map[string]interface{} `json:"Keys"`
 }
 
+// PinLsStreamMessage is the JSON object returned for each pin to Pin List
+// requests with the stream argument.
+type PinLsStreamMessage struct {
+	Cid  string `json:"Cid"`
+	Type string `json:"Type"`
+}
+
 // HandlePinLs is an HTTP handler that intercepts
 // the /api/v0/pin/ls requests to the IPFS node.
 //
@@ -28,44 +40,247 @@ func (p *Proxy) HandlePinLs(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) handlePinLs(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		mon.Counter("pin_ls_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
-		p.log.Error("No basic auth in request")
-		err = errors.New("no basic auth")
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	ctx, user, err := p.authenticate(ctx, w, r, "pin_ls")
+	if err != nil {
+		return err
+	}
+
+	args, err := p.parsePinLsArgs(user, r.URL.Query())
+	if err != nil {
+		mon.Counter("pin_ls_handler_response_codes", monkit.NewSeriesTag("code", "400")).Inc(1)
+		writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
 		return err
 	}
 
-	for param := range r.URL.Query() {
+	if len(args.cids) > 0 {
+		return p.pinLsCids(ctx, w, user, args)
+	}
+
+	if args.limit > 0 {
+		return p.pinLsPage(ctx, w, user, args)
+	}
+
+	if args.pinType != pinTypeAll && args.pinType != pinTypeRecursive {
+		// All content is pinned recursively.
+		return p.writePinLs(ctx, w, args, func(fn func(hash string) error) error { return nil })
+	}
+
+	return p.writePinLs(ctx, w, args, func(fn func(hash string) error) error {
+		return p.db.IterateActiveContentByUser(ctx, user, fn)
+	})
+}
+
+// DefaultPinLsLimit is the page size of Pin List requests with a cursor, but
+// without a limit.
+const DefaultPinLsLimit = 1000
+
+// NextCursorHeader is the header that carries the cursor of the next page of
+// a paginated listing.
+const NextCursorHeader = "X-Next-Cursor"
+
+// Pin types of the IPFS HTTP API.
+const (
+	pinTypeAll       = "all"
+	pinTypeRecursive = "recursive"
+	pinTypeDirect    = "direct"
+	pinTypeIndirect  = "indirect"
+)
+
+// pinLsArgs are the supported arguments of Pin List requests.
+type pinLsArgs struct {
+	cids    []string
+	pinType string
+	stream  bool
+	limit   int
+	cursor  *db.ListCursor
+}
+
+func (p *Proxy) parsePinLsArgs(user string, query url.Values) (args pinLsArgs, err error) {
+	args.pinType = pinTypeAll
+
+	for param, values := range query {
+		value := values[len(values)-1]
 		switch param {
+		case "arg":
+			for _, arg := range values {
+				args.cids = append(args.cids, strings.TrimPrefix(arg, "/ipfs/"))
+			}
+		case "type":
+			switch value {
+			case pinTypeAll, pinTypeRecursive, pinTypeDirect, pinTypeIndirect:
+				args.pinType = value
+			default:
+				return args, fmt.Errorf("invalid type '%s', must be one of {direct, indirect, recursive, all}", value)
+			}
+		case "quiet":
+			// The quiet argument only affects the text output rendered by
+			// the IPFS client, so it is validated and otherwise ignored.
+			_, err = strconv.ParseBool(value)
+			if err != nil {
+				return args, fmt.Errorf("invalid value %q for option quiet", value)
+			}
+		case "stream":
+			args.stream, err = strconv.ParseBool(value)
+			if err != nil {
+				return args, fmt.Errorf("invalid value %q for option stream", value)
+			}
+		case "limit":
+			args.limit, err = strconv.Atoi(value)
+			if err != nil || args.limit < 1 || args.limit > db.MaxListLimit {
+				return args, fmt.Errorf("invalid value %q for option limit, must be between 1 and %d", value, db.MaxListLimit)
+			}
+		case "cursor":
+			cursor, err := db.ParseListCursor(value)
+			if err != nil {
+				return args, fmt.Errorf("invalid value %q for option cursor", value)
+			}
+			args.cursor = &cursor
 		default:
 			mon.Counter("pin_ls_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
 			p.log.Error("Invalid query param",
 				zap.String("User", user),
 				zap.String("Param", param))
-			err = errors.New("no arguments are allowed")
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			return args, fmt.Errorf("unsupported argument: %s", param)
+		}
+	}
+
+	if args.cursor != nil && args.limit == 0 {
+		args.limit = DefaultPinLsLimit
+	}
+	if args.limit > 0 && len(args.cids) > 0 {
+		return args, errors.New("the limit and cursor options cannot be used with arguments")
+	}
+
+	return args, nil
+}
+
+// pinLsPage lists a page of the user's pins starting after the requested
+// cursor. The cursor of the next page, if any, is returned in the
+// X-Next-Cursor header.
+func (p *Proxy) pinLsPage(ctx context.Context, w http.ResponseWriter, user string, args pinLsArgs) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var page db.ContentPage
+	if args.pinType == pinTypeAll || args.pinType == pinTypeRecursive {
+		page, err = p.db.ListContent(ctx, db.ListOptions{
+			User:   user,
+			Status: db.ActiveStatus,
+			Cursor: args.cursor,
+			Limit:  args.limit,
+		})
+		if err != nil {
+			mon.Counter("pin_ls_handler_error_db_list_content").Inc(1)
+			writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
 			return err
 		}
 	}
 
-	// List the pinned content for this user from the DB.
-	hashes, err := p.db.ListActiveContentByUser(ctx, user)
+	if page.Next != nil {
+		w.Header().Set(NextCursorHeader, page.Next.String())
+	}
+
+	return p.writePinLs(ctx, w, args, func(fn func(hash string) error) error {
+		for _, content := range page.Content {
+			if err := fn(content.Hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pinLsCids lists the CIDs requested with the arg argument. It fails in the
+// same way as the IPFS node if any of them is not pinned by the user.
+func (p *Proxy) pinLsCids(ctx context.Context, w http.ResponseWriter, user string, args pinLsArgs) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	pairs, err := p.db.ListActiveContentByHash(ctx, args.cids)
 	if err != nil {
 		mon.Counter("pin_ls_handler_error_db_list_content").Inc(1)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
 		return err
 	}
 
-	keys := make(map[string]interface{}, len(hashes))
-	for _, hash := range hashes {
-		keys[hash] = map[string]string{
-			"Type": "recursive",
+	owned := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		if pair.User == user {
+			owned[pair.Hash] = true
 		}
 	}
 
-	// Write the response.
+	for _, cid := range args.cids {
+		// All content is pinned recursively, so it's never pinned directly
+		// or indirectly for the user.
+		if !owned[cid] || (args.pinType != pinTypeAll && args.pinType != pinTypeRecursive) {
+			mon.Counter("pin_ls_handler_response_codes", monkit.NewSeriesTag("code", "500")).Inc(1)
+			err = fmt.Errorf("path '%s' is not pinned", cid)
+			writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+			return err
+		}
+	}
+
+	return p.writePinLs(ctx, w, args, func(fn func(hash string) error) error {
+		for _, cid := range args.cids {
+			if err := fn(cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writePinLs writes the hashes produced by iterate as a Pin List response.
+//
+// In stream mode, each hash is written as a separate JSON object as soon as it
+// is produced. Errors after the response has started are reported in the
+// X-Stream-Error trailer.
+func (p *Proxy) writePinLs(ctx context.Context, w http.ResponseWriter, args pinLsArgs, iterate func(fn func(hash string) error) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !args.stream {
+		keys := make(map[string]interface{})
+		err = iterate(func(hash string) error {
+			keys[hash] = map[string]string{
+				"Type": pinTypeRecursive,
+			}
+			return nil
+		})
+		if err != nil {
+			mon.Counter("pin_ls_handler_error_db_list_content").Inc(1)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		// Write the response.
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(PinLsResponseMessage{Keys: keys})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(PinLsResponseMessage{Keys: keys})
+	w.Header().Set("X-Chunked-Output", "1")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	err = iterate(func(hash string) error {
+		err := enc.Encode(PinLsStreamMessage{
+			Cid:  hash,
+			Type: pinTypeRecursive,
+		})
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		mon.Counter("pin_ls_handler_error_stream").Inc(1)
+		p.log.Error("Error streaming pins", zap.Error(err))
+		w.Header().Set(http.TrailerPrefix+StreamErrorTrailer, err.Error())
+		return err
+	}
+
+	return nil
 }
@@ -1,6 +1,7 @@
 package proxy_test
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -42,7 +43,7 @@ func TestPinLsHandler_InvalidQueryParams(t *testing.T) {
 		require.NoError(t, err)
 
 		// Pass an invalid query param.
-		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+"?stream", "john")
+		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+"?names=true", "john")
 		require.NoError(t, err)
 
 		resp, err := http.DefaultClient.Do(req)
@@ -51,6 +52,20 @@ func TestPinLsHandler_InvalidQueryParams(t *testing.T) {
 	})
 }
 
+func TestPinLsHandler_InvalidArgValues(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *db.DB) {
+		for _, query := range []string{"?stream", "?stream=yes", "?quiet=no-way", "?type=other"} {
+			req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+query, "john")
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
 func TestPinLsHandle_NoPins(t *testing.T) {
 	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *db.DB) {
 		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint, "john")
@@ -96,7 +111,7 @@ func TestPinLsHandle_RemovedPin(t *testing.T) {
 		require.NoError(t, err)
 
 		// Unpin the file.
-		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-1"})
+		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-1"}, proxydb.EventSource{})
 		require.NoError(t, err)
 
 		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint, "john")
@@ -168,7 +183,7 @@ func TestPinLsHandle_TwoOfThree(t *testing.T) {
 		require.NoError(t, err)
 
 		// Unpin the second file.
-		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-2"})
+		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-2"}, proxydb.EventSource{})
 		require.NoError(t, err)
 
 		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint, "john")
@@ -210,6 +225,101 @@ func TestPinLsHandle_MultiMix(t *testing.T) {
 	})
 }
 
+func TestPinLsHandle_Arg(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *db.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-3", Name: "third.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// List an owned pin.
+		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+"?arg=/ipfs/pin-hash-2&type=recursive", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.JSONEq(t, `{"Keys":{"pin-hash-2":{"Type":"recursive"}}}`, string(respBody))
+
+		// List a pin of someone else.
+		req, err = pinLsRequest(server.URL+proxy.PinLsEndpoint+"?arg=pin-hash-1&arg=pin-hash-3", "john")
+		require.NoError(t, err)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+		respBody, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.JSONEq(t, `{"Message":"path 'pin-hash-3' is not pinned","Code":0,"Type":"error"}`, string(respBody))
+	})
+}
+
+func TestPinLsHandle_Type(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *db.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		for query, expected := range map[string]string{
+			"?type=all":       `{"Keys":{"pin-hash-1":{"Type":"recursive"}}}`,
+			"?type=recursive": `{"Keys":{"pin-hash-1":{"Type":"recursive"}}}`,
+			"?type=direct":    `{"Keys":{}}`,
+			"?type=indirect":  `{"Keys":{}}`,
+		} {
+			req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+query+"&quiet=true", "john")
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, query)
+
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.JSONEq(t, expected, string(respBody), query)
+		}
+	})
+}
+
+func TestPinLsHandle_Stream(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *db.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-3", Name: "third.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		req, err := pinLsRequest(server.URL+proxy.PinLsEndpoint+"?stream=true&type=recursive", "john")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, resp.Body.Close()) }()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var pins []string
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var msg proxy.PinLsStreamMessage
+			require.NoError(t, dec.Decode(&msg))
+			assert.Equal(t, "recursive", msg.Type)
+			pins = append(pins, msg.Cid)
+		}
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2"}, pins)
+		assert.Empty(t, resp.Trailer.Get(proxy.StreamErrorTrailer))
+	})
+}
+
 func pinLsRequest(url, user string) (*http.Request, error) {
 	req, err := http.NewRequest(http.MethodPost, url, nil)
 	if err != nil {
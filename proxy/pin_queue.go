@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+const (
+	// DefaultPinInterval is the default interval of processing the queued
+	// pin requests of the Pinning Service API. New requests are processed
+	// right away in addition.
+	DefaultPinInterval = 10 * time.Second
+
+	// pinBatchSize is the maximum number of pin requests processed at once.
+	pinBatchSize = 10
+	// pinTimeout is the maximum time to pin the content of a pin request.
+	// Requests still pinning after it are claimed again.
+	pinTimeout = time.Hour
+)
+
+// WithPinInterval sets the interval of processing the queued pin requests
+// in Run.
+func (p *Proxy) WithPinInterval(interval time.Duration) *Proxy {
+	p.pinInterval = interval
+	return p
+}
+
+// wakePinWorker makes the pin worker process the queued pin requests without
+// waiting for the next interval.
+func (p *Proxy) wakePinWorker() {
+	select {
+	case p.pinWake <- struct{}{}:
+	default:
+	}
+}
+
+// runPinWorker processes the queued pin requests every pin interval, and
+// when new requests are queued, until ctx is canceled.
+func (p *Proxy) runPinWorker(ctx context.Context) {
+	ticker := time.NewTicker(p.pinInterval)
+	defer ticker.Stop()
+
+	for {
+		err := p.ProcessPinQueue(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Error("Error processing pin queue", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.pinWake:
+		}
+	}
+}
+
+// ProcessPinQueue pins the content of the queued pin requests on the IPFS
+// node until no queued requests are left. The status of each request is
+// pinned if its content was pinned and mapped to the user, and failed
+// otherwise.
+func (p *Proxy) ProcessPinQueue(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for {
+		requests, err := p.db.ClaimQueuedPinRequests(ctx, pinBatchSize, pinTimeout)
+		if err != nil {
+			return err
+		}
+		if len(requests) == 0 {
+			return nil
+		}
+
+		for _, request := range requests {
+			err = p.processPinRequest(ctx, request)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// processPinRequest pins the content of request and updates its status. It
+// returns an error only if the status cannot be updated.
+func (p *Proxy) processPinRequest(ctx context.Context, request db.PinRequest) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// The content events are recorded with the pin request as the request.
+	source := db.EventSource{RequestID: request.ID, Actor: request.User}
+
+	status := db.PinStatusPinned
+	err = p.pinContent(ctx, request, source)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			// The request is claimed again after the pin timeout.
+			return err
+		}
+		status = db.PinStatusFailed
+	}
+
+	err = p.db.UpdatePinRequestStatus(ctx, request.ID, status)
+	if err != nil {
+		if !db.ErrNotFound.Has(err) {
+			mon.Counter("pin_queue_error_db_update_pin_request").Inc(1)
+			return err
+		}
+
+		// The request was deleted while its content was being pinned.
+		if status == db.PinStatusPinned {
+			err = p.releasePinContent(ctx, request, source)
+			if err != nil {
+				mon.Counter("pin_queue_error_release_content").Inc(1)
+				p.log.Error("Error releasing content of deleted pin request",
+					zap.String("User", request.User),
+					zap.String("RequestID", request.ID),
+					zap.Error(err))
+			}
+		}
+		return nil
+	}
+
+	if status == db.PinStatusPinned && request.Replaces != "" {
+		err = p.replacePin(ctx, request, source)
+		if err != nil {
+			mon.Counter("pin_queue_error_replace").Inc(1)
+			p.log.Error("Error removing replaced pin request",
+				zap.String("User", request.User),
+				zap.String("RequestID", request.ID),
+				zap.String("Replaces", request.Replaces),
+				zap.Error(err))
+		}
+	}
+
+	mon.Counter("pin_queue_processed", monkit.NewSeriesTag("status", status)).Inc(1)
+	return nil
+}
+
+// pinContent checks the quota of the user, pins the content of request on
+// the IPFS node and maps it to the user with the add event of source.
+func (p *Proxy) pinContent(ctx context.Context, request db.PinRequest, source db.EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	log := p.log.With(
+		zap.String("User", request.User),
+		zap.String("RequestID", request.ID),
+		zap.String("CID", request.CID))
+
+	// Content that the user already has doesn't count against the quota.
+	_, err = p.db.GetContent(ctx, request.User, request.CID)
+	switch {
+	case db.ErrNotFound.Has(err):
+		err = p.checkQuota(ctx, request.User, 0, 1)
+		switch {
+		case errBytesQuotaExceeded.Has(err):
+			mon.Counter("pins_handler_quota_exceeded", monkit.NewSeriesTag("limit", "bytes")).Inc(1)
+			log.Info("Quota exceeded before pinning", zap.Error(err))
+			return err
+		case errPinsQuotaExceeded.Has(err):
+			mon.Counter("pins_handler_quota_exceeded", monkit.NewSeriesTag("limit", "pins")).Inc(1)
+			log.Info("Quota exceeded before pinning", zap.Error(err))
+			return err
+		case err != nil:
+			mon.Counter("pins_handler_error_db_quota").Inc(1)
+			log.Error("Error checking quota", zap.Error(err))
+			return err
+		}
+	case err != nil:
+		mon.Counter("pins_handler_error_db_get_content").Inc(1)
+		log.Error("Error checking content", zap.Error(err))
+		return err
+	}
+
+	pinCtx, cancel := context.WithTimeout(ctx, pinTimeout)
+	defer cancel()
+
+	err = p.pin(pinCtx, request.CID)
+	if err != nil {
+		mon.Counter("pins_handler_error_backend_pin").Inc(1)
+		log.Error("Error pinning content", zap.Error(err))
+		return err
+	}
+
+	size, err := p.cumulativeSize(pinCtx, request.CID)
+	if err != nil {
+		mon.Counter("pins_handler_error_backend_stat").Inc(1)
+		log.Error("Error getting size of pinned content", zap.Error(err))
+		p.unpinUnowned(ctx, "pins_handler_error_unpin_unowned", request.User, request.CID)
+		return err
+	}
+
+	name := request.Name
+	if name == "" {
+		name = request.CID + " (remote pin)"
+	}
+
+	err = p.addWithinQuota(ctx, nil, "pins", []db.Content{{
+		User:            request.User,
+		Hash:            request.CID,
+		Name:            name,
+		Size:            size,
+		PinRequestsOnly: true,
+	}}, source)
+	if err != nil {
+		if isQuotaExceeded(err) {
+			return err
+		}
+		mon.Counter("pins_handler_error_db_add").Inc(1)
+		log.Error("Error adding content to database",
+			zap.String("Name", name),
+			zap.Int64("Size", size),
+			zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// replacePin removes the pin request that request replaces, if it still
+// exists, with the events of source.
+func (p *Proxy) replacePin(ctx context.Context, request db.PinRequest, source db.EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	replaced, err := p.db.GetPinRequest(ctx, request.User, request.Replaces)
+	if err != nil {
+		if db.ErrNotFound.Has(err) {
+			return nil
+		}
+		return err
+	}
+
+	err = p.removePin(ctx, replaced, source)
+	if db.ErrNotFound.Has(err) {
+		return nil
+	}
+	return err
+}
+
+// unpinUnowned unpins cid from the IPFS node unless a user has it active.
+// It is used when content was pinned for user, but cannot be mapped to the
+// user. Errors are logged and counted with the counter of the given name.
+func (p *Proxy) unpinUnowned(ctx context.Context, counter, user, cid string) {
+	owners, err := p.db.ListActiveContentByHash(ctx, []string{cid})
+	if err == nil && len(owners) > 0 {
+		return
+	}
+	if err == nil {
+		err = p.unpin(ctx, []string{cid})
+	}
+	if err != nil {
+		mon.Counter(counter).Inc(1)
+		p.log.Error("Error unpinning content without owner",
+			zap.String("User", user),
+			zap.String("CID", cid),
+			zap.Error(err))
+	}
+}
@@ -5,10 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
-	"strconv"
 
 	"github.com/spacemonkeygo/monkit/v3"
 	"go.uber.org/zap"
@@ -31,12 +28,8 @@ func (p *Proxy) HandlePinRm(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) handlePinRm(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		mon.Counter("pin_rm_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusUnauthorized))).Inc(1)
-		p.log.Error("No basic auth in request")
-		err = errors.New("no basic auth")
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	ctx, user, err := p.authenticate(ctx, w, r, "pin_rm")
+	if err != nil {
 		return err
 	}
 
@@ -94,8 +87,10 @@ func (p *Proxy) handlePinRm(ctx context.Context, w http.ResponseWriter, r *http.
 		return err
 	}
 
-	// Remove the requested pins from the database.
-	err = p.db.RemoveContentByHashForUser(ctx, user, toRemove)
+	// Remove the requested pins from the database. This also queues the
+	// hashes that nobody else has pinned for unpinning, so they are unpinned
+	// eventually even if the unpin below fails.
+	err = p.db.RemoveContentByHashForUser(ctx, user, toRemove, eventSource(ctx))
 	if err != nil {
 		mon.Counter("pin_rm_handler_error_db_remove_content").Inc(1)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -108,46 +103,16 @@ func (p *Proxy) handlePinRm(ctx context.Context, w http.ResponseWriter, r *http.
 		return writePinRmResponse(w, toRemove)
 	}
 
-	// Request the backend with only the hashes remaining in backendArg.
-	u := r.URL
-	u.Scheme = p.target.Scheme
-	u.Host = p.target.Host
-	u.RawQuery = url.Values(map[string][]string{
-		"arg": setToSlice(backendArgs),
-	}).Encode()
-
-	resp, err := http.DefaultClient.Post(u.String(), "", nil)
+	// Unpin only the hashes remaining in backendArgs. The queue rows of the
+	// hashes are locked while unpinning, so no user can add them meanwhile.
+	err = p.unpinQueued(ctx, setToSlice(backendArgs))
 	if err != nil {
 		// Log the error but don't return error to the client.
-		mon.Counter("pin_rm_handler_error_backend_request").Inc(1)
-		p.log.Error("Error requesting backend", zap.Error(err))
-		// Send a success response.
-		return writePinRmResponse(w, toRemove)
-	}
-
-	code := resp.StatusCode
-	mon.Counter("pin_rm_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
-
-	if code != http.StatusOK {
-		// The backend responded with error - relay it back to the client.
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Set(key, value)
-			}
-		}
-		w.WriteHeader(resp.StatusCode)
-		_, err := io.Copy(w, resp.Body)
-		return err
-	}
-
-	// Discard the response body from the backend.
-	_, err = io.Copy(io.Discard, resp.Body)
-	if err != nil {
-		// Log the error but don't return error to the client.
-		mon.Counter("pin_rm_handler_error_discard_backend_respond").Inc(1)
-		p.log.Error("Error discarding backend response", zap.Error(err))
-		// Send a success response.
-		return writePinRmResponse(w, toRemove)
+		// The unpin queue will retry it.
+		mon.Counter("pin_rm_handler_error_backend_unpin").Inc(1)
+		p.log.Error("Error unpinning content",
+			zap.String("User", user),
+			zap.Error(err))
 	}
 
 	// Send our own success response.
@@ -59,7 +59,7 @@ func TestPinRmHandler_InternalError(t *testing.T) {
 
 		resp, err := http.DefaultClient.Do(req)
 		require.NoError(t, err)
-		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 		// Although the IPFS backend erred, we still mark the content as removed in the proxy DB.
 		contents, err := db.ListAll(ctx)
@@ -67,6 +67,12 @@ func TestPinRmHandler_InternalError(t *testing.T) {
 		require.Len(t, contents, 1)
 		require.NotNil(t, contents[0].Removed)
 		assert.WithinDuration(t, time.Now(), *contents[0].Removed, 1*time.Minute)
+
+		// The hash stays queued for the unpin worker to retry.
+		queued, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+		assert.Equal(t, "pin-hash-1", queued[0].Hash)
 	})
 }
 
@@ -386,7 +392,7 @@ func TestPinRmHandle_MultiMix(t *testing.T) {
 
 func prefillDB(ctx context.Context, db *proxydb.DB, contents ...proxydb.Content) error {
 	for _, content := range contents {
-		err := db.Add(ctx, content)
+		err := db.Add(ctx, content, proxydb.EventSource{})
 		if err != nil {
 			return err
 		}
@@ -394,6 +400,12 @@ func prefillDB(ctx context.Context, db *proxydb.DB, contents ...proxydb.Content)
 	return nil
 }
 
+// removeContent removes the content of user that matches hashes, like
+// removing it outside of a request.
+func removeContent(ctx context.Context, db *proxydb.DB, user string, hashes ...string) error {
+	return db.RemoveContentByHashForUser(ctx, user, hashes, proxydb.EventSource{})
+}
+
 func pinRmRequest(url, user string, hashes ...string) (*http.Request, error) {
 	if len(hashes) > 0 {
 		url += "?arg=" + strings.Join(hashes, "&arg=")
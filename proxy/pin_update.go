@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// PinUpdateResponseMessage is the JSON object returned to Pin Update requests.
+type PinUpdateResponseMessage struct {
+	Pins []string `json:"Pins"`
+}
+
+// HandlePinUpdate is an HTTP handler that intercepts
+// the /api/v0/pin/update requests to the IPFS node.
+//
+// It checks that the authenticated user has the old pin, pins the new one
+// and transfers the user's mapping from the old pin to the new one. The old
+// pin is queued for unpinning from the IPFS node only if no other user has
+// it pinned.
+func (p *Proxy) HandlePinUpdate(w http.ResponseWriter, r *http.Request) {
+	_ = p.handlePinUpdate(r.Context(), w, r)
+}
+
+func (p *Proxy) handlePinUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "pin_update")
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	unpin := true
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "arg":
+			for _, value := range values {
+				args = append(args, strings.TrimPrefix(value, "/ipfs/"))
+			}
+			continue
+		case "unpin":
+			unpin, err = strconv.ParseBool(values[len(values)-1])
+			if err == nil {
+				continue
+			}
+			mon.Counter("pin_update_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			err = errors.New("unpin argument must be a boolean")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		default:
+			mon.Counter("pin_update_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only arg and unpin arguments are allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	if len(args) != 2 {
+		mon.Counter("pin_update_handler_invalid_args").Inc(1)
+		err = errors.New(`arguments "from-path" and "to-path" are required`)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	from, to := args[0], args[1]
+
+	// Check if the user pinned the old content.
+	owners, err := p.db.ListActiveContentByHash(ctx, []string{from})
+	if err != nil {
+		mon.Counter("pin_update_handler_error_db_list_content").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	var ownsFrom bool
+	for _, owner := range owners {
+		if owner.User == user {
+			ownsFrom = true
+		}
+	}
+
+	if !ownsFrom {
+		mon.Counter("pin_update_handler_error_content_not_pinned").Inc(1)
+		err = errors.New("'from' cid was not recursively pinned already")
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	if from == to {
+		return writePinUpdateResponse(w, from, to)
+	}
+
+	old, err := p.db.GetContent(ctx, user, from)
+	if err != nil {
+		mon.Counter("pin_update_handler_error_db_get_content").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	// Pin the new content before getting its size, as getting the size of
+	// content that is not on the IPFS node yet would fetch it without the
+	// progress of a pin. The old pin is kept until the mapping is moved, so
+	// the content stays pinned if the quota is exceeded.
+	err = p.backendCall(ctx, PinUpdateEndpoint, url.Values{
+		"arg":   {from, to},
+		"unpin": {"false"},
+	}, nil)
+	if err != nil {
+		mon.Counter("pin_update_handler_error_backend").Inc(1)
+		p.log.Error("Error updating pin",
+			zap.String("User", user),
+			zap.String("From", from),
+			zap.String("To", to),
+			zap.Error(err))
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	size, err := p.cumulativeSize(ctx, to)
+	if err != nil {
+		mon.Counter("pin_update_handler_error_backend_stat").Inc(1)
+		p.log.Error("Error getting size of content",
+			zap.String("User", user),
+			zap.String("CID", to),
+			zap.Error(err))
+		p.unpinUnowned(ctx, "pin_update_handler_error_unpin_unowned", user, to)
+		writeIPFSError(w, http.StatusInternalServerError, ipfsErrNormal, err.Error())
+		return err
+	}
+
+	// The content is already pinned, so the quota is checked with the
+	// mapping, in one transaction, so concurrent requests of the user cannot
+	// exceed the quota together.
+	var check func(usage, added db.Usage) error
+	quota, limited, err := p.lookupQuota(ctx, user)
+	if err != nil {
+		p.unpinUnowned(ctx, "pin_update_handler_error_unpin_unowned", user, to)
+		p.writeQuotaError(w, user, "pin_update", size, err)
+		return err
+	}
+	if limited {
+		check = quotaCheck(quota)
+	}
+
+	// The old content is queued for unpinning from the IPFS node with the
+	// move of the mapping if no other user has it pinned.
+	content := db.Content{
+		User: user,
+		Hash: to,
+		Name: old.Name,
+		Size: size,
+	}
+	if unpin {
+		err = p.db.UpdateContentWithinQuota(ctx, user, from, content, eventSource(ctx), check)
+	} else {
+		err = p.db.AddWithinQuota(ctx, []db.Content{content}, eventSource(ctx), check)
+	}
+	if isQuotaExceeded(err) {
+		p.unpinUnowned(ctx, "pin_update_handler_error_unpin_unowned", user, to)
+		p.writeQuotaError(w, user, "pin_update", size, err)
+		return err
+	}
+	if err != nil {
+		mon.Counter("pin_update_handler_error_db_update_content").Inc(1)
+		p.log.Error("Error updating content in database",
+			zap.String("User", user),
+			zap.String("From", from),
+			zap.String("To", to),
+			zap.Int64("Size", size),
+			zap.Error(err))
+		p.unpinUnowned(ctx, "pin_update_handler_error_unpin_unowned", user, to)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	mon.Counter("pin_update_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+
+	return writePinUpdateResponse(w, from, to)
+}
+
+func writePinUpdateResponse(w http.ResponseWriter, from, to string) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(PinUpdateResponseMessage{Pins: []string{from, to}})
+}
@@ -0,0 +1,237 @@
+package proxy_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestPinUpdateHandler_NotOwned(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	runTest(t, pinUpdate, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "")
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.JSONEq(t, `{"Message":"'from' cid was not recursively pinned already","Code":0,"Type":"error"}`, readBody(t, resp))
+		assert.False(t, pinUpdate.Invoked)
+	})
+}
+
+func TestPinUpdateHandler_InvalidArgs(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		for _, query := range []string{"", "?arg=pin-hash-1", "?arg=pin-hash-1&arg=pin-hash-2&unpin=maybe", "?arg=pin-hash-1&arg=pin-hash-2&unknown=1"} {
+			req, err := pinLsRequest(server.URL+proxy.PinUpdateEndpoint+query, "john")
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func TestPinUpdateHandler_Exclusive(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinUpdateEndpoint: pinUpdate,
+		"/api/v0/files/stat":    &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "/ipfs/pin-hash-2", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"Pins":["pin-hash-1","pin-hash-2"]}`, readBody(t, resp))
+
+		// Check that the IPFS node added the new pin and kept the old one.
+		assert.Equal(t, [][2]string{{"pin-hash-1", "pin-hash-2"}}, pinUpdate.Updated)
+		assert.Empty(t, pinUpdate.Unpinned)
+
+		// Check that the mapping moved to the new pin.
+		content, err := db.GetContent(ctx, "john", "pin-hash-2")
+		require.NoError(t, err)
+		assert.Equal(t, "site", content.Name)
+		assert.EqualValues(t, 2048, content.Size)
+
+		_, err = db.GetContent(ctx, "john", "pin-hash-1")
+		require.True(t, proxydb.ErrNotFound.Has(err))
+
+		// Check that the old pin is queued for unpinning.
+		unpins, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, unpins, 1)
+		assert.Equal(t, "pin-hash-1", unpins[0].Hash)
+	})
+}
+
+func TestPinUpdateHandler_Shared(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinUpdateEndpoint: pinUpdate,
+		"/api/v0/files/stat":    &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// Check that only the new pin is added to the IPFS node.
+		assert.Equal(t, [][2]string{{"pin-hash-1", "pin-hash-2"}}, pinUpdate.Updated)
+		assert.Empty(t, pinUpdate.Unpinned)
+
+		unpins, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, unpins)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, hashes)
+
+		hashes, err = db.ListActiveContentByUser(ctx, "shawn")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+	})
+}
+
+func TestPinUpdateHandler_NoUnpin(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinUpdateEndpoint: pinUpdate,
+		"/api/v0/files/stat":    &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "false")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, [][2]string{{"pin-hash-1", "pin-hash-2"}}, pinUpdate.Updated)
+		assert.Empty(t, pinUpdate.Unpinned)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"pin-hash-1", "pin-hash-2"}, hashes)
+	})
+}
+
+func TestPinUpdateHandler_QuotaExceeded(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinUpdateEndpoint: pinUpdate,
+		proxy.PinRmEndpoint:     pinRm,
+		"/api/v0/files/stat":    &mock.IPFSFilesStatHandler{CumulativeSize: 4096},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(2048)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// Check that the new pin is removed and the old one is kept.
+		assert.Empty(t, pinUpdate.Unpinned)
+		assert.Equal(t, []string{"pin-hash-2"}, pinRm.Removed)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+	})
+}
+
+func TestPinUpdateHandler_PinsQuotaExceededWithoutUnpin(t *testing.T) {
+	pinUpdate := new(mock.IPFSPinUpdateHandler)
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinUpdateEndpoint: pinUpdate,
+		proxy.PinRmEndpoint:     pinRm,
+		"/api/v0/files/stat":    &mock.IPFSFilesStatHandler{CumulativeSize: 512},
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxPins := int64(1)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxPins: &maxPins})
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// Keeping the old pin adds a pin, which exceeds the quota.
+		resp := updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "false")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, []string{"pin-hash-2"}, pinRm.Removed)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+
+		// Replacing the old pin with smaller content stays within the quota.
+		resp = updatePin(t, server.URL, "john", "pin-hash-1", "pin-hash-2", "true")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		hashes, err = db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, hashes)
+	})
+}
+
+func updatePin(t *testing.T, serverURL, user, from, to, unpin string) *http.Response {
+	url := serverURL + proxy.PinUpdateEndpoint + "?arg=" + from + "&arg=" + to
+	if unpin != "" {
+		url += "&unpin=" + unpin
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
@@ -0,0 +1,396 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/common/uuid"
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+const (
+	// defaultPinsLimit is the default number of results returned by GET /pins.
+	defaultPinsLimit = 10
+	// maxPinsLimit is the maximum number of results returned by GET /pins.
+	maxPinsLimit = 1000
+	// maxPinsCIDs is the maximum number of CIDs in the cid filter of GET /pins.
+	maxPinsCIDs = 10
+	// maxPinNameLength is the maximum length of a pin name.
+	maxPinNameLength = 255
+)
+
+// Pin is the pin object of the IPFS Pinning Service API.
+type Pin struct {
+	CID     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// PinStatus is the pin status object of the IPFS Pinning Service API.
+type PinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       Pin               `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+// PinResults is the response to the list pins requests of the IPFS Pinning Service API.
+type PinResults struct {
+	Count   int         `json:"count"`
+	Results []PinStatus `json:"results"`
+}
+
+// PinsFailure is the error response of the IPFS Pinning Service API.
+type PinsFailure struct {
+	Error PinsFailureError `json:"error"`
+}
+
+// PinsFailureError describes the error of a PinsFailure.
+type PinsFailureError struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// HandlePins is an HTTP handler that implements the /pins endpoint of the
+// IPFS Pinning Service API.
+//
+// It authenticates the user with an API key with the pins scope, or any
+// other credentials of the proxy, and lists or adds pins for that user. New
+// pin requests are queued and pinned in the background by the pin worker,
+// which maps the pinned content to the user in the database.
+func (p *Proxy) HandlePins(w http.ResponseWriter, r *http.Request) {
+	_ = p.handlePins(r.Context(), w, r)
+}
+
+// HandlePin is an HTTP handler that implements the /pins/{requestid}
+// endpoint of the IPFS Pinning Service API.
+//
+// It authenticates the user like HandlePins and gets, replaces or removes
+// the pin request of that user.
+func (p *Proxy) HandlePin(w http.ResponseWriter, r *http.Request) {
+	_ = p.handlePin(r.Context(), w, r)
+}
+
+func (p *Proxy) handlePins(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "pins")
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return p.listPins(ctx, w, r, user)
+	case http.MethodPost:
+		pin, err := decodePin(r)
+		if err != nil {
+			mon.Counter("pins_handler_invalid_pin").Inc(1)
+			return writePinsError(w, http.StatusBadRequest, "BAD_REQUEST", err)
+		}
+
+		status, err := p.addPin(ctx, user, pin, "")
+		if err != nil {
+			return writePinsError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err)
+		}
+		return writePinsResponse(w, http.StatusAccepted, status)
+	default:
+		return writePinsError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (p *Proxy) handlePin(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "pins")
+	if err != nil {
+		return err
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, PinsEndpoint+"/")
+	if id == "" || strings.Contains(id, "/") {
+		return writePinsError(w, http.StatusNotFound, "NOT_FOUND", errors.New("the specified resource was not found"))
+	}
+
+	request, err := p.db.GetPinRequest(ctx, user, id)
+	if err != nil {
+		if db.ErrNotFound.Has(err) {
+			return writePinsError(w, http.StatusNotFound, "NOT_FOUND", errors.New("the specified resource was not found"))
+		}
+		mon.Counter("pin_handler_error_db_get_pin_request").Inc(1)
+		return writePinsError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writePinsResponse(w, http.StatusOK, pinStatus(request))
+	case http.MethodPost:
+		pin, err := decodePin(r)
+		if err != nil {
+			mon.Counter("pin_handler_invalid_pin").Inc(1)
+			return writePinsError(w, http.StatusBadRequest, "BAD_REQUEST", err)
+		}
+
+		// The existing pin request is replaced only once the new one is
+		// pinned.
+		status, err := p.addPin(ctx, user, pin, request.ID)
+		if err != nil {
+			return writePinsError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err)
+		}
+		return writePinsResponse(w, http.StatusAccepted, status)
+	case http.MethodDelete:
+		err = p.removePin(ctx, request, eventSource(ctx))
+		if err != nil {
+			return writePinsError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err)
+		}
+		mon.Counter("pins_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusAccepted))).Inc(1)
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	default:
+		return writePinsError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (p *Proxy) listPins(ctx context.Context, w http.ResponseWriter, r *http.Request, user string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	filter, err := parsePinsFilter(r)
+	if err != nil {
+		mon.Counter("pins_handler_invalid_query_param").Inc(1)
+		p.log.Error("Invalid query param",
+			zap.String("User", user),
+			zap.Error(err))
+		return writePinsError(w, http.StatusBadRequest, "BAD_REQUEST", err)
+	}
+
+	requests, count, err := p.db.ListPinRequests(ctx, user, filter)
+	if err != nil {
+		mon.Counter("pins_handler_error_db_list_pin_requests").Inc(1)
+		p.log.Error("Error listing pin requests",
+			zap.String("User", user),
+			zap.Error(err))
+		return writePinsError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err)
+	}
+
+	results := PinResults{
+		Count:   count,
+		Results: make([]PinStatus, 0, len(requests)),
+	}
+	for _, request := range requests {
+		results.Results = append(results.Results, pinStatus(request))
+	}
+
+	return writePinsResponse(w, http.StatusOK, results)
+}
+
+// addPin queues a new pin request of user for the pin worker, see
+// ProcessPinQueue, and returns its status. If replaces is not empty, the pin
+// request with that ID is removed once the content of the new one is pinned.
+func (p *Proxy) addPin(ctx context.Context, user string, pin Pin, replaces string) (status PinStatus, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	id, err := uuid.New()
+	if err != nil {
+		return PinStatus{}, err
+	}
+
+	request := db.PinRequest{
+		ID:       id.String(),
+		User:     user,
+		CID:      pin.CID,
+		Name:     pin.Name,
+		Status:   db.PinStatusQueued,
+		Meta:     pin.Meta,
+		Replaces: replaces,
+	}
+
+	request.Created, err = p.db.AddPinRequest(ctx, request)
+	if err != nil {
+		mon.Counter("pins_handler_error_db_add_pin_request").Inc(1)
+		p.log.Error("Error adding pin request to database",
+			zap.String("User", user),
+			zap.String("CID", pin.CID),
+			zap.Error(err))
+		return PinStatus{}, err
+	}
+
+	p.wakePinWorker()
+
+	return pinStatus(request), nil
+}
+
+// removePin deletes the pin request. If it was the last pin request of
+// the user for its CID, the content is released with the events of source,
+// see releasePinContent.
+func (p *Proxy) removePin(ctx context.Context, request db.PinRequest, source db.EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = p.db.DeletePinRequest(ctx, request.User, request.ID)
+	if err != nil {
+		mon.Counter("pins_handler_error_db_delete_pin_request").Inc(1)
+		return err
+	}
+
+	return p.releasePinContent(ctx, request, source)
+}
+
+// releasePinContent unmaps the content of request from its user if the user
+// has no other pin requests for it and didn't add it in other ways. The
+// content is unpinned from the IPFS node unless other users have it pinned
+// too. The remove event is recorded with source.
+func (p *Proxy) releasePinContent(ctx context.Context, request db.PinRequest, source db.EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	removed, err := p.db.RemovePinRequestContent(ctx, request.User, request.CID, source)
+	if err != nil {
+		mon.Counter("pins_handler_error_db_remove_content").Inc(1)
+		return err
+	}
+	if !removed {
+		return nil
+	}
+
+	owners, err := p.db.ListActiveContentByHash(ctx, []string{request.CID})
+	if err != nil {
+		mon.Counter("pins_handler_error_db_list_content").Inc(1)
+		return err
+	}
+	if len(owners) > 0 {
+		return nil
+	}
+
+	err = p.unpinQueued(ctx, []string{request.CID})
+	if err != nil {
+		// Log the error but don't return error to the client.
+		// The unpin queue will retry it.
+		mon.Counter("pins_handler_error_backend_unpin").Inc(1)
+		p.log.Error("Error unpinning content",
+			zap.String("User", request.User),
+			zap.String("CID", request.CID),
+			zap.Error(err))
+	}
+
+	return nil
+}
+
+func decodePin(r *http.Request) (pin Pin, err error) {
+	err = json.NewDecoder(r.Body).Decode(&pin)
+	if err != nil {
+		return Pin{}, fmt.Errorf("invalid pin object: %v", err)
+	}
+
+	if pin.CID == "" {
+		return Pin{}, errors.New("cid is required")
+	}
+
+	if len(pin.Name) > maxPinNameLength {
+		return Pin{}, fmt.Errorf("name must not be longer than %d characters", maxPinNameLength)
+	}
+
+	return pin, nil
+}
+
+func parsePinsFilter(r *http.Request) (filter db.PinRequestFilter, err error) {
+	filter.Statuses = []string{db.PinStatusPinned}
+	filter.Limit = defaultPinsLimit
+
+	for param, values := range r.URL.Query() {
+		value := values[0]
+		switch param {
+		case "cid":
+			filter.CIDs = strings.Split(value, ",")
+			if len(filter.CIDs) > maxPinsCIDs {
+				return filter, fmt.Errorf("at most %d CIDs are allowed in cid", maxPinsCIDs)
+			}
+		case "name":
+			if len(value) > maxPinNameLength {
+				return filter, fmt.Errorf("name must not be longer than %d characters", maxPinNameLength)
+			}
+			filter.Name = value
+		case "match":
+			switch value {
+			case db.MatchExact, db.MatchIExact, db.MatchPartial, db.MatchIPartial:
+				filter.Match = value
+			default:
+				return filter, fmt.Errorf("invalid match: %q", value)
+			}
+		case "status":
+			filter.Statuses = strings.Split(value, ",")
+			for _, status := range filter.Statuses {
+				switch status {
+				case db.PinStatusQueued, db.PinStatusPinning, db.PinStatusPinned, db.PinStatusFailed:
+				default:
+					return filter, fmt.Errorf("invalid status: %q", status)
+				}
+			}
+		case "before", "after":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", param, err)
+			}
+			if param == "before" {
+				filter.Before = &t
+			} else {
+				filter.After = &t
+			}
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxPinsLimit {
+				return filter, fmt.Errorf("limit must be between 1 and %d", maxPinsLimit)
+			}
+			filter.Limit = limit
+		case "meta":
+			err := json.Unmarshal([]byte(value), &filter.Meta)
+			if err != nil {
+				return filter, fmt.Errorf("invalid meta: %v", err)
+			}
+		default:
+			return filter, fmt.Errorf("invalid query param: %q", param)
+		}
+	}
+
+	return filter, nil
+}
+
+func pinStatus(request db.PinRequest) PinStatus {
+	return PinStatus{
+		RequestID: request.ID,
+		Status:    request.Status,
+		Created:   request.Created,
+		Pin: Pin{
+			CID:  request.CID,
+			Name: request.Name,
+			Meta: request.Meta,
+		},
+		Delegates: []string{},
+	}
+}
+
+func writePinsResponse(w http.ResponseWriter, code int, v interface{}) error {
+	mon.Counter("pins_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writePinsError(w http.ResponseWriter, code int, reason string, err error) error {
+	_ = writePinsResponse(w, code, PinsFailure{
+		Error: PinsFailureError{
+			Reason:  reason,
+			Details: err.Error(),
+		},
+	})
+	return err
+}
@@ -0,0 +1,335 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestPins_Unauthorized(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		// No bearer token.
+		resp, err := pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint, "", nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// Unknown bearer token.
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint, "unknown", nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// API key without the pins scope.
+		key := addAPIKey(ctx, t, db, proxydb.APIKey{ID: "read", User: "john", Scopes: []string{"pin:read"}})
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint, key, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestPins_AddGetList(t *testing.T) {
+	pinAdd := new(mock.IPFSPinAddHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/pin/add":    pinAdd,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	var p *proxy.Proxy
+	runTestWithConfig(t, ipfsHandler, capturePinsProxy(&p), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		token := createToken(ctx, t, db, "john")
+
+		// The pin request is queued and pinned by the pin worker.
+		status := addPin(ctx, t, server.URL, token, proxy.Pin{CID: "pin-hash-1", Name: "first.jpg", Meta: map[string]string{"app": "test"}})
+		assert.Equal(t, proxydb.PinStatusQueued, status.Status)
+		assert.NotEmpty(t, status.RequestID)
+		assert.Empty(t, pinAdd.Pinned)
+
+		require.NoError(t, p.ProcessPinQueue(ctx))
+		assert.Equal(t, []string{"pin-hash-1"}, pinAdd.Pinned)
+
+		// Check that the content is mapped to the user.
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, contents, 1)
+		assert.Equal(t, "john", contents[0].User)
+		assert.Equal(t, "pin-hash-1", contents[0].Hash)
+		assert.Equal(t, "first.jpg", contents[0].Name)
+		assert.EqualValues(t, 2048, contents[0].Size)
+
+		// Get the pin status by request ID.
+		resp, err := pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"/"+status.RequestID, token, nil)
+		require.NoError(t, err)
+		var got proxy.PinStatus
+		decodePinsResponse(t, resp, http.StatusOK, &got)
+		assert.Equal(t, status.RequestID, got.RequestID)
+		assert.Equal(t, proxydb.PinStatusPinned, got.Status)
+		assert.Equal(t, "test", got.Pin.Meta["app"])
+
+		// List the pins with and without matching filters.
+		var results proxy.PinResults
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"?cid=pin-hash-1", token, nil)
+		require.NoError(t, err)
+		decodePinsResponse(t, resp, http.StatusOK, &results)
+		assert.Equal(t, 1, results.Count)
+		require.Len(t, results.Results, 1)
+		assert.Equal(t, status.RequestID, results.Results[0].RequestID)
+
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"?status=failed", token, nil)
+		require.NoError(t, err)
+		decodePinsResponse(t, resp, http.StatusOK, &results)
+		assert.Equal(t, 0, results.Count)
+
+		// Another user doesn't see the pin request.
+		otherToken := createToken(ctx, t, db, "shawn")
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"/"+status.RequestID, otherToken, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestPins_InvalidQueryParams(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		token := createToken(ctx, t, db, "john")
+
+		for _, query := range []string{"?status=unknown", "?match=regex", "?limit=0", "?before=yesterday", "?meta=invalid", "?unknown=1"} {
+			resp, err := pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+query, token, nil)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func TestPins_Delete(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/pin/add":    new(mock.IPFSPinAddHandler),
+		"/api/v0/pin/rm":     pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 1024},
+	}
+	var p *proxy.Proxy
+	runTestWithConfig(t, ipfsHandler, capturePinsProxy(&p), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		johnToken := createToken(ctx, t, db, "john")
+		shawnToken := createToken(ctx, t, db, "shawn")
+
+		johnStatus := addPin(ctx, t, server.URL, johnToken, proxy.Pin{CID: "pin-hash-1"})
+		shawnStatus := addPin(ctx, t, server.URL, shawnToken, proxy.Pin{CID: "pin-hash-1"})
+		require.NoError(t, p.ProcessPinQueue(ctx))
+
+		// Delete the pin request of the first user.
+		resp, err := pinsRequest(ctx, http.MethodDelete, server.URL+proxy.PinsEndpoint+"/"+johnStatus.RequestID, johnToken, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		// Check that the content is not unpinned as the other user still has it.
+		assert.False(t, pinRm.Invoked)
+		contents, err := db.ListActiveContentByHash(ctx, []string{"pin-hash-1"})
+		require.NoError(t, err)
+		require.Len(t, contents, 1)
+		assert.Equal(t, "shawn", contents[0].User)
+
+		// Delete the pin request of the second user.
+		resp, err = pinsRequest(ctx, http.MethodDelete, server.URL+proxy.PinsEndpoint+"/"+shawnStatus.RequestID, shawnToken, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		// Check that the content is unpinned now.
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+		contents, err = db.ListActiveContentByHash(ctx, []string{"pin-hash-1"})
+		require.NoError(t, err)
+		assert.Empty(t, contents)
+	})
+}
+
+func TestPins_Replace(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/pin/add":    new(mock.IPFSPinAddHandler),
+		"/api/v0/pin/rm":     pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 1024},
+	}
+	var p *proxy.Proxy
+	runTestWithConfig(t, ipfsHandler, capturePinsProxy(&p), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		token := createToken(ctx, t, db, "john")
+
+		oldStatus := addPin(ctx, t, server.URL, token, proxy.Pin{CID: "pin-hash-1"})
+		require.NoError(t, p.ProcessPinQueue(ctx))
+
+		body, err := json.Marshal(proxy.Pin{CID: "pin-hash-2"})
+		require.NoError(t, err)
+
+		resp, err := pinsRequest(ctx, http.MethodPost, server.URL+proxy.PinsEndpoint+"/"+oldStatus.RequestID, token, body)
+		require.NoError(t, err)
+		var newStatus proxy.PinStatus
+		decodePinsResponse(t, resp, http.StatusAccepted, &newStatus)
+		assert.Equal(t, proxydb.PinStatusQueued, newStatus.Status)
+		assert.NotEqual(t, oldStatus.RequestID, newStatus.RequestID)
+
+		// The old pin request stays until the new one is pinned.
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"/"+oldStatus.RequestID, token, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		require.NoError(t, p.ProcessPinQueue(ctx))
+
+		// Check that the old pin request is gone and its content unpinned.
+		resp, err = pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"/"+oldStatus.RequestID, token, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-2"}, hashes)
+	})
+}
+
+func TestPins_DeleteKeepsAddedContent(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/pin/add":    new(mock.IPFSPinAddHandler),
+		"/api/v0/pin/rm":     pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 1024},
+	}
+	var p *proxy.Proxy
+	runTestWithConfig(t, ipfsHandler, capturePinsProxy(&p), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		token := createToken(ctx, t, db, "john")
+
+		// The user uploaded the content before pinning it remotely.
+		err := prefillDB(ctx, db, proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024})
+		require.NoError(t, err)
+
+		status := addPin(ctx, t, server.URL, token, proxy.Pin{CID: "pin-hash-1"})
+		require.NoError(t, p.ProcessPinQueue(ctx))
+
+		resp, err := pinsRequest(ctx, http.MethodDelete, server.URL+proxy.PinsEndpoint+"/"+status.RequestID, token, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		// The uploaded content stays mapped and pinned.
+		assert.False(t, pinRm.Invoked)
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+	})
+}
+
+func TestPins_Quota(t *testing.T) {
+	pinAdd := new(mock.IPFSPinAddHandler)
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		"/api/v0/pin/add":    pinAdd,
+		"/api/v0/pin/rm":     pinRm,
+		"/api/v0/files/stat": &mock.IPFSFilesStatHandler{CumulativeSize: 2048},
+	}
+	var p *proxy.Proxy
+	runTestWithConfig(t, ipfsHandler, capturePinsProxy(&p), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxPins := int64(1)
+		maxBytes := int64(4096)
+		require.NoError(t, db.SetQuota(ctx, proxydb.Quota{User: "john", MaxPins: &maxPins}))
+		require.NoError(t, db.SetQuota(ctx, proxydb.Quota{User: "shawn", MaxBytes: &maxBytes}))
+
+		johnToken := createToken(ctx, t, db, "john")
+		shawnToken := createToken(ctx, t, db, "shawn")
+
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "second.jpg", Size: 3072},
+		)
+		require.NoError(t, err)
+
+		// The pin quota is checked before pinning.
+		johnStatus := addPin(ctx, t, server.URL, johnToken, proxy.Pin{CID: "pin-hash-3"})
+		// The size quota is checked after pinning.
+		shawnStatus := addPin(ctx, t, server.URL, shawnToken, proxy.Pin{CID: "pin-hash-4"})
+		require.NoError(t, p.ProcessPinQueue(ctx))
+
+		for _, tc := range []struct {
+			token  string
+			status proxy.PinStatus
+		}{
+			{token: johnToken, status: johnStatus},
+			{token: shawnToken, status: shawnStatus},
+		} {
+			resp, err := pinsRequest(ctx, http.MethodGet, server.URL+proxy.PinsEndpoint+"/"+tc.status.RequestID, tc.token, nil)
+			require.NoError(t, err)
+			var got proxy.PinStatus
+			decodePinsResponse(t, resp, http.StatusOK, &got)
+			assert.Equal(t, proxydb.PinStatusFailed, got.Status)
+		}
+
+		assert.Equal(t, []string{"pin-hash-4"}, pinAdd.Pinned)
+		assert.Equal(t, []string{"pin-hash-4"}, pinRm.Removed)
+
+		contents, err := db.ListActiveContentByHash(ctx, []string{"pin-hash-3", "pin-hash-4"})
+		require.NoError(t, err)
+		assert.Empty(t, contents)
+	})
+}
+
+// capturePinsProxy returns a configure function of runTestWithConfig that
+// stores the proxy in p, so tests can process the pin queue.
+func capturePinsProxy(p **proxy.Proxy) func(*proxy.Proxy, *proxydb.DB) {
+	return func(configured *proxy.Proxy, _ *proxydb.DB) {
+		*p = configured
+	}
+}
+
+// createToken creates an API key with the pins scope for user.
+func createToken(ctx *testcontext.Context, t *testing.T, db *proxydb.DB, user string) string {
+	return addAPIKey(ctx, t, db, proxydb.APIKey{ID: "pins-" + user, User: user, Scopes: []string{string(auth.ScopePins)}})
+}
+
+func addPin(ctx context.Context, t *testing.T, serverURL, token string, pin proxy.Pin) proxy.PinStatus {
+	body, err := json.Marshal(pin)
+	require.NoError(t, err)
+
+	resp, err := pinsRequest(ctx, http.MethodPost, serverURL+proxy.PinsEndpoint, token, body)
+	require.NoError(t, err)
+
+	var status proxy.PinStatus
+	decodePinsResponse(t, resp, http.StatusAccepted, &status)
+	return status
+}
+
+func pinsRequest(ctx context.Context, method, url, token string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func decodePinsResponse(t *testing.T, resp *http.Response, expectedCode int, v interface{}) {
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+
+	require.Equal(t, expectedCode, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
@@ -2,33 +2,87 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
+	"storj.io/ipfs-user-mapping-proxy/auth"
 	"storj.io/ipfs-user-mapping-proxy/db"
 )
 
 var mon = monkit.Package()
 
 const (
-	AddEndpoint       = "/api/v0/add"
-	DAGImportEndpoint = "/api/v0/dag/import"
-	PinLsEndpoint     = "/api/v0/pin/ls"
-	PinRmEndpoint     = "/api/v0/pin/rm"
+	AddEndpoint          = "/api/v0/add"
+	AdminContentEndpoint = "/admin/content"
+	AdminUsersEndpoint   = "/admin/users"
+	CatEndpoint          = "/api/v0/cat"
+	DAGExportEndpoint    = "/api/v0/dag/export"
+	DAGImportEndpoint    = "/api/v0/dag/import"
+	FilesEndpoint        = "/api/v0/files/"
+	GetEndpoint          = "/api/v0/get"
+	KeyGenEndpoint       = "/api/v0/key/gen"
+	KeyListEndpoint      = "/api/v0/key/list"
+	KeyRmEndpoint        = "/api/v0/key/rm"
+	LsEndpoint           = "/api/v0/ls"
+	NamePublishEndpoint  = "/api/v0/name/publish"
+	PinAddEndpoint       = "/api/v0/pin/add"
+	PinLsEndpoint        = "/api/v0/pin/ls"
+	PinRmEndpoint        = "/api/v0/pin/rm"
+	PinUpdateEndpoint    = "/api/v0/pin/update"
+	PinsEndpoint         = "/pins"
+	UsageEndpoint        = "/api/v0/x/usage"
 )
 
+// DefaultDrainTimeout is the default time to wait for in-flight requests to
+// finish when the proxy is shutting down.
+const DefaultDrainTimeout = 30 * time.Second
+
 // Proxy is a reverse proxy to the IPFS node's HTTP API that
 // maps uploaded content to the authenticated user.
 type Proxy struct {
-	log     *zap.Logger
-	db      *db.DB
-	address string
-	target  *url.URL
-	proxy   *httputil.ReverseProxy
+	log      *zap.Logger
+	db       *db.DB
+	address  string
+	target   *url.URL
+	proxy    *httputil.ReverseProxy
+	verifier auth.Verifier
+	jwt      *auth.JWTVerifier
+	// trustedHeader is the header with the user name authenticated by one
+	// of the trustedProxies.
+	trustedHeader  string
+	trustedProxies []*net.IPNet
+	// tls is the TLS configuration, nil to serve plain HTTP.
+	tls *TLSConfig
+	// tlsFiles are the last loaded *tlsFiles of the TLS configuration.
+	tlsFiles atomic.Value
+	// admins are the users with the admin role from the configuration.
+	admins map[string]bool
+
+	readPolicy    ReadPolicy
+	drainTimeout  time.Duration
+	unpinInterval time.Duration
+	pinInterval   time.Duration
+	// pinWake wakes the pin worker when a pin request is queued.
+	pinWake chan struct{}
+	// policyFile is the path to the passthrough policy file.
+	policyFile string
+	// mux is the current *http.ServeMux, rebuilt when the passthrough
+	// policy is reloaded.
+	mux atomic.Value
+	// mfsRoots are the MFS roots of users already created on the IPFS node.
+	mfsRoots sync.Map
+	// active is the number of requests being handled. Accessed atomically.
+	active int64
 }
 
 // New creates a new Proxy to target. Proxy listens on the provided address
@@ -40,27 +94,194 @@ func New(log *zap.Logger, db *db.DB, address string, target *url.URL) *Proxy {
 		rw.WriteHeader(http.StatusBadGateway)
 	}
 
-	return &Proxy{
+	p := &Proxy{
 		log:     log,
 		db:      db,
 		address: address,
 		target:  target,
 		proxy:   proxy,
+
+		readPolicy:    ReadOwned,
+		drainTimeout:  DefaultDrainTimeout,
+		unpinInterval: DefaultUnpinInterval,
+		pinInterval:   DefaultPinInterval,
+		pinWake:       make(chan struct{}, 1),
 	}
+	p.mux.Store(p.buildMux(nil))
+
+	return p
 }
 
-// Run starts the proxy.
+// WithVerifier sets the verifier for the basic auth credentials of the
+// incoming requests. If not set, the password is not verified.
+func (p *Proxy) WithVerifier(verifier auth.Verifier) *Proxy {
+	p.verifier = verifier
+	return p
+}
+
+// WithDrainTimeout sets the time to wait for in-flight requests to finish
+// when the proxy is shutting down.
+func (p *Proxy) WithDrainTimeout(timeout time.Duration) *Proxy {
+	p.drainTimeout = timeout
+	return p
+}
+
+// Run starts the proxy and serves requests until ctx is canceled.
+//
+// When ctx is canceled, the proxy stops accepting new connections and waits
+// up to the drain timeout for the in-flight requests to finish, so they can
+// complete their database writes. Requests still running after the drain
+// timeout are abandoned.
+//
+// It also runs the workers that pin the content of the queued pin requests
+// and retry the queued unpins in the background.
+// The passthrough policy, if set, is loaded before the proxy starts and is
+// reloaded on SIGHUP. With TLS, the certificate files are loaded before the
+// proxy starts and are reloaded when they change.
 func (p *Proxy) Run(ctx context.Context) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	return http.ListenAndServe(p.address, p.ServeMux())
+	err = p.ReloadPassthroughPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    p.address,
+		Handler: p.trackRequests(p.Handler()),
+	}
+
+	if p.tls != nil {
+		_, err = p.reloadTLS()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = p.tlsConfig()
+	}
+
+	workerCtx, cancelWorker := context.WithCancel(ctx)
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		p.runUnpinWorker(workerCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		p.runPinWorker(workerCtx)
+	}()
+	go p.reloadOnSignal(workerCtx)
+	if p.tls != nil {
+		go p.watchTLS(workerCtx)
+	}
+	defer func() {
+		cancelWorker()
+		workers.Wait()
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if p.tls != nil {
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	return p.shutdown(server, serveErr)
+}
+
+// shutdown gracefully shuts down server and waits for serveErr.
+func (p *Proxy) shutdown(server *http.Server, serveErr <-chan error) (err error) {
+	p.log.Info("Shutting down",
+		zap.Int64("Active", atomic.LoadInt64(&p.active)),
+		zap.Duration("Drain Timeout", p.drainTimeout))
+
+	// The parent context is already canceled, so a new one is needed.
+	ctx, cancel := context.WithTimeout(context.Background(), p.drainTimeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		abandoned := atomic.LoadInt64(&p.active)
+		mon.IntVal("shutdown_abandoned_requests").Observe(abandoned)
+		p.log.Warn("Drain timeout exceeded, abandoning in-flight requests",
+			zap.Int64("Abandoned", abandoned))
+		err = server.Close()
+	} else if err == nil {
+		mon.IntVal("shutdown_abandoned_requests").Observe(0)
+		p.log.Info("All in-flight requests finished")
+	}
+
+	// ListenAndServe and ListenAndServeTLS return ErrServerClosed as soon as
+	// Shutdown is called.
+	if serr := <-serveErr; !errors.Is(serr, http.ErrServerClosed) {
+		err = errs.Combine(err, serr)
+	}
+
+	return err
 }
 
+// trackRequests counts the requests being handled by next.
+func (p *Proxy) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&p.active, 1)
+		defer atomic.AddInt64(&p.active, -1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeMux returns the current mux of the intercepted endpoints and the
+// endpoints of the passthrough policy.
+//
+// The mux is replaced when the passthrough policy is reloaded, and it
+// doesn't assign request IDs or resolve the identity of the requests. Use
+// Handler to serve the proxy's HTTP API.
 func (p *Proxy) ServeMux() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc(AddEndpoint, p.HandleAdd)
-	mux.HandleFunc(DAGImportEndpoint, p.HandleDAGImport)
-	mux.HandleFunc(PinLsEndpoint, p.HandlePinLs)
-	mux.HandleFunc(PinRmEndpoint, p.HandlePinRm)
-	return mux
+	return p.mux.Load().(*http.ServeMux)
+}
+
+// Handler returns the handler of the proxy's HTTP API.
+//
+// The endpoints of the passthrough policy are updated in place when the
+// policy is reloaded. The identity of the requests is resolved before they
+// are routed.
+func (p *Proxy) Handler() http.Handler {
+	return withRequestID(p.withIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeMux().ServeHTTP(w, r)
+	})))
+}
+
+// routes returns the handlers of the endpoints intercepted by the proxy.
+func (p *Proxy) routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		AddEndpoint:                p.HandleAdd,
+		AdminContentEndpoint + "/": p.HandleAdminContent,
+		AdminUsersEndpoint:         p.HandleAdminUsers,
+		AdminUsersEndpoint + "/":   p.HandleAdminUser,
+		CatEndpoint:                p.HandleCat,
+		DAGExportEndpoint:          p.HandleDAGExport,
+		DAGImportEndpoint:          p.HandleDAGImport,
+		FilesEndpoint:              p.HandleFiles,
+		GetEndpoint:                p.HandleGet,
+		KeyGenEndpoint:             p.HandleKeyGen,
+		KeyListEndpoint:            p.HandleKeyList,
+		KeyRmEndpoint:              p.HandleKeyRm,
+		LsEndpoint:                 p.HandleLs,
+		NamePublishEndpoint:        p.HandleNamePublish,
+		PinAddEndpoint:             p.HandlePinAdd,
+		PinLsEndpoint:              p.HandlePinLs,
+		PinRmEndpoint:              p.HandlePinRm,
+		PinUpdateEndpoint:          p.HandlePinUpdate,
+		PinsEndpoint:               p.HandlePins,
+		PinsEndpoint + "/":         p.HandlePin,
+		UsageEndpoint:              p.HandleUsage,
+	}
 }
@@ -0,0 +1,116 @@
+package proxy_test
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestRun_Drain(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, _ *httptest.Server, db *proxydb.DB) {
+		ipfsHandler := &mock.BlockingHandler{Handler: new(mock.IPFSAddHandler)}
+		address, cancel, runErr := runProxy(t, ctx, db, ipfsHandler, time.Minute)
+		defer cancel()
+
+		uploadErr := make(chan error, 1)
+		go func() {
+			uploadErr <- addFile("http://"+address+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		}()
+
+		// Shut down while the upload is in-flight.
+		<-ipfsHandler.Started
+		cancel()
+
+		// Check that new connections are refused while draining.
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", address)
+			if err == nil {
+				_ = conn.Close()
+			}
+			return err != nil
+		}, 10*time.Second, 10*time.Millisecond)
+
+		close(ipfsHandler.Release)
+		require.NoError(t, <-uploadErr)
+		require.NoError(t, <-runErr)
+
+		// Check that the upload was mapped to the user.
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{mock.Hash("first.jpg")}, hashes)
+	})
+}
+
+func TestRun_DrainTimeout(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, _ *httptest.Server, db *proxydb.DB) {
+		ipfsHandler := &mock.BlockingHandler{Handler: new(mock.IPFSAddHandler)}
+		address, cancel, runErr := runProxy(t, ctx, db, ipfsHandler, 100*time.Millisecond)
+		defer cancel()
+		defer close(ipfsHandler.Release)
+
+		uploadErr := make(chan error, 1)
+		go func() {
+			uploadErr <- addFile("http://"+address+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		}()
+
+		<-ipfsHandler.Started
+		cancel()
+
+		// Check that the proxy stops after the drain timeout even though
+		// the upload never finishes.
+		select {
+		case err := <-runErr:
+			require.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("proxy did not stop after the drain timeout")
+		}
+		require.Error(t, <-uploadErr)
+	})
+}
+
+// runProxy runs a proxy to ipfsHandler in the background on a random local
+// port. The proxy stops when the returned cancel function is called.
+func runProxy(t *testing.T, ctx context.Context, db *proxydb.DB, ipfsHandler mock.ResettableHandler, drainTimeout time.Duration) (address string, cancel func(), runErr <-chan error) {
+	ipfsHandler.Reset()
+	ipfsServer := httptest.NewServer(ipfsHandler)
+	t.Cleanup(ipfsServer.Close)
+
+	target, err := url.Parse(ipfsServer.URL)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address = listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	p := proxy.New(zaptest.NewLogger(t), db, address, target).WithDrainTimeout(drainTimeout)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run(runCtx)
+	}()
+
+	// Wait for the proxy to start listening.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, 10*time.Second, 10*time.Millisecond)
+
+	return address, cancel, errCh
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// StreamErrorTrailer is the HTTP trailer used by the IPFS HTTP API to
+// report errors that happen after the response status has been sent.
+const StreamErrorTrailer = "X-Stream-Error"
+
+var (
+	errBytesQuotaExceeded = errs.Class("storage quota exceeded")
+	errPinsQuotaExceeded  = errs.Class("pin quota exceeded")
+)
+
+// quotaHintKey is the context key of the quota hint of a request.
+type quotaHintKey struct{}
+
+// withQuotaHint returns a copy of ctx with quota as the quota of its user,
+// overriding the quota in the database.
+func withQuotaHint(ctx context.Context, quota db.Quota) context.Context {
+	return context.WithValue(ctx, quotaHintKey{}, quota)
+}
+
+// quotaHint returns the quota hint of ctx if it is for user.
+func quotaHint(ctx context.Context, user string) (quota db.Quota, ok bool) {
+	quota, ok = ctx.Value(quotaHintKey{}).(db.Quota)
+	return quota, ok && quota.User == user
+}
+
+// lookupQuota returns the quota of user and whether it limits the user. The
+// quota hint of ctx takes precedence over the quota in the database. Users
+// without a quota are unlimited.
+func (p *Proxy) lookupQuota(ctx context.Context, user string) (quota db.Quota, limited bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	quota, ok := quotaHint(ctx, user)
+	if !ok {
+		quota, err = p.db.GetQuota(ctx, user)
+		if err != nil {
+			if db.ErrNotFound.Has(err) {
+				return db.Quota{}, false, nil
+			}
+			return db.Quota{}, false, err
+		}
+	}
+
+	return quota, quota.MaxBytes != nil || quota.MaxPins != nil, nil
+}
+
+// exceedsQuota returns an errBytesQuotaExceeded or errPinsQuotaExceeded
+// error if adding bytes and pins to usage would exceed quota.
+func exceedsQuota(quota db.Quota, usage db.Usage, bytes, pins int64) error {
+	if quota.MaxBytes != nil && usage.Bytes+bytes > *quota.MaxBytes {
+		return errBytesQuotaExceeded.New("%d of %d bytes used, %d more requested", usage.Bytes, *quota.MaxBytes, bytes)
+	}
+
+	if quota.MaxPins != nil && usage.Pins+pins > *quota.MaxPins {
+		return errPinsQuotaExceeded.New("%d of %d pins used, %d more requested", usage.Pins, *quota.MaxPins, pins)
+	}
+
+	return nil
+}
+
+// quotaCheck returns the check of db.AddWithinQuota and
+// db.UpdateContentWithinQuota for quota. Changes that add neither pins nor
+// bytes are always accepted, so users over their quota can still add content
+// they already have or replace content with smaller content.
+func quotaCheck(quota db.Quota) func(usage, added db.Usage) error {
+	return func(usage, added db.Usage) error {
+		if added.Pins <= 0 && added.Bytes <= 0 {
+			return nil
+		}
+		return exceedsQuota(quota, usage, added.Bytes, added.Pins)
+	}
+}
+
+// isQuotaExceeded returns whether err is an errBytesQuotaExceeded or
+// errPinsQuotaExceeded error.
+func isQuotaExceeded(err error) bool {
+	return errBytesQuotaExceeded.Has(err) || errPinsQuotaExceeded.Has(err)
+}
+
+// checkQuota returns an errBytesQuotaExceeded or errPinsQuotaExceeded error
+// if adding bytes and pins to the active content of user would exceed the
+// user's quota. See lookupQuota for the quota of the user.
+func (p *Proxy) checkQuota(ctx context.Context, user string, bytes, pins int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	quota, limited, err := p.lookupQuota(ctx, user)
+	if err != nil || !limited {
+		return err
+	}
+
+	usage, err := p.db.GetUsage(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return exceedsQuota(quota, usage, bytes, pins)
+}
+
+// precheckQuota checks the quota of user before the request is forwarded to
+// the IPFS node. The request's Content-Length, if known, is taken as the
+// size of the upload, which always adds at least one pin.
+//
+// If the quota would be exceeded, it writes an error response to w.
+func (p *Proxy) precheckQuota(ctx context.Context, w http.ResponseWriter, r *http.Request, user, handler string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	size := r.ContentLength
+	if size < 0 {
+		size = 0
+	}
+
+	quota, limited, err := p.lookupQuota(ctx, user)
+	if err == nil && limited {
+		var usage db.Usage
+		usage, err = p.db.GetUsage(ctx, user)
+		if err == nil {
+			err = exceedsQuota(quota, usage, size, 1)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	p.writeQuotaError(w, user, handler, size, err)
+	return err
+}
+
+// writeQuotaError writes the error returned by checkQuota to w. Exceeded
+// quotas are reported with 413 for bytes and 403 for pins.
+func (p *Proxy) writeQuotaError(w http.ResponseWriter, user, handler string, size int64, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errBytesQuotaExceeded.Has(err):
+		code = http.StatusRequestEntityTooLarge
+		mon.Counter(handler+"_handler_quota_exceeded", monkit.NewSeriesTag("limit", "bytes")).Inc(1)
+	case errPinsQuotaExceeded.Has(err):
+		code = http.StatusForbidden
+		mon.Counter(handler+"_handler_quota_exceeded", monkit.NewSeriesTag("limit", "pins")).Inc(1)
+	default:
+		mon.Counter(handler + "_handler_error_db_quota").Inc(1)
+		p.log.Error("Error checking quota",
+			zap.String("User", user),
+			zap.Error(err))
+		http.Error(w, "error checking quota", code)
+		mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+		return
+	}
+
+	p.log.Info("Quota exceeded",
+		zap.String("User", user),
+		zap.Int64("Size", size),
+		zap.Error(err))
+	http.Error(w, err.Error(), code)
+	mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+}
+
+// addWithinQuota maps contents, which the IPFS node has already added, to
+// their user with the add events of source if the quota of the user allows
+// it. Contents that the user already has are not counted. The check and the
+// mapping run in one transaction, so concurrent requests of the user cannot
+// exceed the quota together.
+//
+// If the quota is exceeded, the hashes that no other user has are unpinned
+// and an errBytesQuotaExceeded or errPinsQuotaExceeded error is returned.
+// The error is reported to the client through w, if not nil, in the
+// X-Stream-Error trailer, as the response is streamed and its status has
+// already been sent. Database errors are returned without reporting them.
+func (p *Proxy) addWithinQuota(ctx context.Context, w *ResponseWriterWrapper, handler string, contents []db.Content, source db.EventSource) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if len(contents) == 0 {
+		return nil
+	}
+	user := contents[0].User
+
+	var check func(usage, added db.Usage) error
+	quota, limited, err := p.lookupQuota(ctx, user)
+	switch {
+	case err != nil:
+		// The content is already pinned, so rather map it to the user than
+		// leave it without an owner.
+		mon.Counter(handler + "_handler_error_db_quota").Inc(1)
+		p.log.Error("Error checking quota",
+			zap.String("User", user),
+			zap.Error(err))
+	case limited:
+		check = quotaCheck(quota)
+	}
+
+	err = p.db.AddWithinQuota(ctx, contents, source, check)
+	switch {
+	case err == nil:
+		return nil
+	case errBytesQuotaExceeded.Has(err):
+		mon.Counter(handler+"_handler_quota_exceeded", monkit.NewSeriesTag("limit", "bytes")).Inc(1)
+	case errPinsQuotaExceeded.Has(err):
+		mon.Counter(handler+"_handler_quota_exceeded", monkit.NewSeriesTag("limit", "pins")).Inc(1)
+	default:
+		return err
+	}
+
+	hashes := make([]string, 0, len(contents))
+	for _, content := range contents {
+		hashes = append(hashes, content.Hash)
+	}
+
+	p.log.Info("Quota exceeded after upload",
+		zap.String("User", user),
+		zap.Strings("Hashes", hashes),
+		zap.Error(err))
+
+	if w != nil {
+		w.Header().Set(http.TrailerPrefix+StreamErrorTrailer, err.Error())
+	}
+
+	owners, ownersErr := p.db.ListActiveContentByHash(ctx, hashes)
+	if ownersErr != nil {
+		mon.Counter(handler + "_handler_error_quota_unpin").Inc(1)
+		p.log.Error("Error checking owners of content over quota",
+			zap.String("User", user),
+			zap.Strings("Hashes", hashes),
+			zap.Error(ownersErr))
+		return err
+	}
+
+	owned := make(map[string]bool)
+	for _, owner := range owners {
+		owned[owner.Hash] = true
+	}
+
+	var toUnpin []string
+	for _, hash := range hashes {
+		if !owned[hash] {
+			toUnpin = append(toUnpin, hash)
+			owned[hash] = true
+		}
+	}
+
+	if len(toUnpin) > 0 {
+		unpinErr := p.unpin(ctx, toUnpin)
+		if unpinErr != nil {
+			mon.Counter(handler + "_handler_error_quota_unpin").Inc(1)
+			p.log.Error("Error unpinning content over quota",
+				zap.String("User", user),
+				zap.Strings("Hashes", toUnpin),
+				zap.Error(unpinErr))
+		}
+	}
+
+	return err
+}
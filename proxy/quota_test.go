@@ -0,0 +1,210 @@
+package proxy_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestQuota_BytesBeforeUpload(t *testing.T) {
+	runTest(t, new(mock.IPFSAddHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(1000)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		req, err := addRequest(server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		// Check that DB is still empty.
+		contents, err := db.ListAll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, contents)
+
+		// Another user is not limited.
+		err = addFile(server.URL+proxy.AddEndpoint, "shawn", 1024, "first.jpg")
+		require.NoError(t, err)
+	})
+}
+
+func TestQuota_PinsBeforeUpload(t *testing.T) {
+	runTest(t, new(mock.IPFSDAGImportHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxPins := int64(1)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxPins: &maxPins})
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		req, err := addRequest(server.URL+proxy.DAGImportEndpoint, "john", 1024, "file.car")
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		// Check that only the prefilled content is in the DB.
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pin-hash-1"}, hashes)
+	})
+}
+
+func TestQuota_BytesAfterUpload(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.AddEndpoint:   new(mock.IPFSAddHandler),
+		proxy.PinRmEndpoint: pinRm,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(1500)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		// Upload a file within the quota without Content-Length.
+		resp := addChunked(t, server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Trailer.Get(proxy.StreamErrorTrailer))
+
+		// Upload the same file again, which doesn't use more quota.
+		resp = addChunked(t, server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Trailer.Get(proxy.StreamErrorTrailer))
+
+		// Upload a second file that exceeds the quota. The response is
+		// streamed, so the error is reported in the trailer.
+		resp = addChunked(t, server.URL+proxy.AddEndpoint, "john", 1024, "second.jpg")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Trailer.Get(proxy.StreamErrorTrailer), "quota")
+
+		// Check that the second file is unpinned and not mapped to the user.
+		assert.Equal(t, []string{mock.Hash("second.jpg")}, pinRm.Removed)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Equal(t, []string{mock.Hash("first.jpg")}, hashes)
+	})
+}
+
+func TestQuota_AfterUploadPinnedByOthers(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.AddEndpoint:   new(mock.IPFSAddHandler),
+		proxy.PinRmEndpoint: pinRm,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxBytes := int64(100)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxBytes: &maxBytes})
+		require.NoError(t, err)
+
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "shawn", Hash: mock.Hash("first.jpg"), Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		resp := addChunked(t, server.URL+proxy.AddEndpoint, "john", 1024, "first.jpg")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Trailer.Get(proxy.StreamErrorTrailer), "quota")
+
+		// Check that the content of the other user is not unpinned.
+		assert.False(t, pinRm.Invoked)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+	})
+}
+
+func TestQuota_ConcurrentUploads(t *testing.T) {
+	ipfsHandler := mock.ServeMux{
+		proxy.AddEndpoint:   new(mock.IPFSAddHandler),
+		proxy.PinRmEndpoint: new(mock.IPFSPinRmHandler),
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		maxPins := int64(1)
+		err := db.SetQuota(ctx, proxydb.Quota{User: "john", MaxPins: &maxPins})
+		require.NoError(t, err)
+
+		// All uploads pass the check before the upload, but only one of
+		// them may be mapped after it.
+		const uploads = 5
+		codes := make([]int, uploads)
+		trailers := make([]string, uploads)
+		uploadErrs := make([]error, uploads)
+		var wg sync.WaitGroup
+		for i := 0; i < uploads; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				req, err := addRequest(server.URL+proxy.AddEndpoint, "john", 1024, fmt.Sprintf("file-%d.jpg", i))
+				if err != nil {
+					uploadErrs[i] = err
+					return
+				}
+				req.ContentLength = -1
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					uploadErrs[i] = err
+					return
+				}
+				codes[i] = resp.StatusCode
+				_, err = io.Copy(io.Discard, resp.Body)
+				trailers[i] = resp.Trailer.Get(proxy.StreamErrorTrailer)
+				uploadErrs[i] = errs.Combine(err, resp.Body.Close())
+			}(i)
+		}
+		wg.Wait()
+
+		var ok int
+		for i, code := range codes {
+			require.NoError(t, uploadErrs[i])
+			assert.Equal(t, http.StatusOK, code)
+			if trailers[i] == "" {
+				ok++
+			}
+		}
+		assert.LessOrEqual(t, ok, 1)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.Len(t, hashes, ok)
+	})
+}
+
+// addChunked uploads files without Content-Length and returns the response
+// with its body read, so that the trailers are available.
+func addChunked(t *testing.T, url, user string, fileSize int, fileNames ...string) *http.Response {
+	req, err := addRequest(url, user, fileSize, fileNames...)
+	require.NoError(t, err)
+	req.ContentLength = -1
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	require.NoError(t, err)
+
+	return resp
+}
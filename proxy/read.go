@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+)
+
+// ReadPolicy controls who can read content through the proxy.
+type ReadPolicy string
+
+const (
+	// ReadPublic allows anyone to read any content, without authentication.
+	ReadPublic ReadPolicy = "public"
+	// ReadOwned allows authenticated users to read only content they have
+	// pinned, including paths under the pinned roots.
+	ReadOwned ReadPolicy = "owned"
+)
+
+// ParseReadPolicy parses a read policy from its name.
+func ParseReadPolicy(s string) (ReadPolicy, error) {
+	switch policy := ReadPolicy(s); policy {
+	case ReadPublic, ReadOwned:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown read policy %q, must be %q or %q", s, ReadPublic, ReadOwned)
+	}
+}
+
+// WithReadPolicy sets the policy of the read requests. The default is
+// ReadOwned.
+func (p *Proxy) WithReadPolicy(policy ReadPolicy) *Proxy {
+	p.readPolicy = policy
+	return p
+}
+
+// HandleCat is an HTTP handler that intercepts
+// the /api/v0/cat requests to the IPFS node.
+//
+// The read policy decides if the request is allowed.
+func (p *Proxy) HandleCat(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleRead(r.Context(), w, r, "cat")
+}
+
+// HandleGet is an HTTP handler that intercepts
+// the /api/v0/get requests to the IPFS node.
+//
+// The read policy decides if the request is allowed.
+func (p *Proxy) HandleGet(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleRead(r.Context(), w, r, "get")
+}
+
+// HandleLs is an HTTP handler that intercepts
+// the /api/v0/ls requests to the IPFS node.
+//
+// The read policy decides if the request is allowed.
+func (p *Proxy) HandleLs(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleRead(r.Context(), w, r, "ls")
+}
+
+// HandleDAGExport is an HTTP handler that intercepts
+// the /api/v0/dag/export requests to the IPFS node.
+//
+// The read policy decides if the request is allowed.
+func (p *Proxy) HandleDAGExport(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleRead(r.Context(), w, r, "dag_export")
+}
+
+// handleRead forwards a read request to the IPFS node if the read policy
+// allows it. The response is streamed to the client without buffering.
+func (p *Proxy) handleRead(ctx context.Context, w http.ResponseWriter, r *http.Request, handler string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if p.readPolicy != ReadPublic {
+		ctx, user, err := p.authenticate(ctx, w, r, handler)
+		if err != nil {
+			return err
+		}
+
+		err = p.checkReadable(ctx, w, user, r.URL.Query()["arg"], handler)
+		if err != nil {
+			return err
+		}
+	}
+
+	wrapper := NewResponseWriterWrapper(w, nil)
+	p.proxy.ServeHTTP(wrapper, r)
+
+	code := wrapper.StatusCode
+	mon.Counter(handler+"_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(code))).Inc(1)
+
+	if code != http.StatusOK {
+		if code > 400 && code != http.StatusBadGateway {
+			// BadGateway is logged by the proxy error handler
+			p.log.Error("Proxy error",
+				zap.Int("Code", code),
+				zap.ByteString("Body", wrapper.Body))
+		}
+		return fmt.Errorf("Proxy error (code %d)", code)
+	}
+
+	return nil
+}
+
+// checkReadable writes an error response and returns an error if user may not
+// read any of the IPFS paths in args. A path is readable if its root CID is
+// pinned by the user.
+func (p *Proxy) checkReadable(ctx context.Context, w http.ResponseWriter, user string, args []string, handler string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if len(args) == 0 {
+		err = errors.New(`argument "ipfs-path" is required`)
+		mon.Counter(handler + "_handler_invalid_path").Inc(1)
+		writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+		return err
+	}
+
+	cids := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "/") && !strings.HasPrefix(arg, "/ipfs/") {
+			// The owner of mutable paths like /ipns/ can't be checked.
+			err = fmt.Errorf("path %q is not an /ipfs/ path", arg)
+			mon.Counter(handler + "_handler_invalid_path").Inc(1)
+			writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+			return err
+		}
+
+		cid, err := ipfsPathRoot(arg)
+		if err != nil {
+			mon.Counter(handler + "_handler_invalid_path").Inc(1)
+			writeIPFSError(w, http.StatusBadRequest, ipfsErrClient, err.Error())
+			return err
+		}
+		cids = append(cids, cid)
+	}
+
+	err = p.checkOwned(ctx, user, cids)
+	if err != nil {
+		if errNotOwned.Has(err) {
+			mon.Counter(handler + "_handler_content_not_owned").Inc(1)
+			writeIPFSError(w, http.StatusForbidden, ipfsErrClient, err.Error())
+			return err
+		}
+		mon.Counter(handler + "_handler_error_db_list_content").Inc(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
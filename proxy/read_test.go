@@ -0,0 +1,88 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestRead_Owned(t *testing.T) {
+	read := new(mock.IPFSReadHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.CatEndpoint:       read,
+		proxy.GetEndpoint:       read,
+		proxy.LsEndpoint:        read,
+		proxy.DAGExportEndpoint: read,
+	}
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "site", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-2", Name: "site", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		for _, endpoint := range []string{proxy.CatEndpoint, proxy.GetEndpoint, proxy.LsEndpoint, proxy.DAGExportEndpoint} {
+			resp := readRequest(t, server.URL+endpoint+"?arg=pin-hash-1", "john")
+			assert.Equal(t, http.StatusOK, resp.StatusCode, endpoint)
+			assert.Equal(t, "pin-hash-1", readBody(t, resp), endpoint)
+
+			resp = readRequest(t, server.URL+endpoint+"?arg=pin-hash-2", "john")
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, endpoint)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		// Sub-paths under an owned root can be read.
+		resp := readRequest(t, server.URL+proxy.CatEndpoint+"?arg=/ipfs/pin-hash-1/dir/index.html", "john")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/ipfs/pin-hash-1/dir/index.html", readBody(t, resp))
+
+		// Every argument must be owned.
+		resp = readRequest(t, server.URL+proxy.CatEndpoint+"?arg=pin-hash-1&arg=pin-hash-2", "john")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// Escapes, mutable paths and missing arguments are rejected.
+		for _, query := range []string{"?arg=/ipfs/pin-hash-1/../pin-hash-2", "?arg=/ipns/example.com", ""} {
+			resp = readRequest(t, server.URL+proxy.CatEndpoint+query, "john")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		assert.Len(t, read.Paths, 5)
+	})
+}
+
+func TestRead_Public(t *testing.T) {
+	read := new(mock.IPFSReadHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.CatEndpoint: read,
+	}
+	configure := func(p *proxy.Proxy, db *proxydb.DB) {
+		p.WithReadPolicy(proxy.ReadPublic)
+	}
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		resp, err := http.Get(server.URL + proxy.CatEndpoint + "?arg=/ipns/example.com/index.html")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/ipns/example.com/index.html", readBody(t, resp))
+	})
+}
+
+func readRequest(t *testing.T, url, user string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// reconcileBatchSize is the maximum number of hashes per repair request.
+const reconcileBatchSize = 100
+
+// ReconcileOptions selects which kinds of drift are repaired by Reconcile.
+type ReconcileOptions struct {
+	// RepairOrphans unpins the orphans from the IPFS node.
+	RepairOrphans bool
+
+	// RepairDangling marks the dangling content as removed in the database.
+	RepairDangling bool
+
+	// RepairRemoved unpins the removed content from the IPFS node.
+	RepairRemoved bool
+}
+
+// ReconcileReport describes the drift between the database and the pins of
+// the IPFS node. All hash lists are sorted.
+type ReconcileReport struct {
+	// Pinned is the number of recursive pins on the IPFS node.
+	Pinned int
+
+	// Orphans are the hashes pinned on the IPFS node but unknown to the database.
+	Orphans []string
+
+	// Dangling are the hashes active in the database but not pinned on the IPFS node.
+	Dangling []string
+
+	// Removed are the hashes removed for every user in the database but still
+	// pinned on the IPFS node.
+	Removed []string
+}
+
+// Reconcile compares the active content in the database with the recursive
+// pins of the IPFS node and repairs the drift selected by opts.
+//
+// The pins are listed before the database is queried, so content added
+// while the pins are listed is not reported as an orphan. Hashes that users
+// added or removed since the start of the run are skipped, as the pins were
+// listed before. Orphans and removed hashes are checked again right before
+// they are unpinned.
+func (p *Proxy) Reconcile(ctx context.Context, opts ReconcileOptions) (report ReconcileReport, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	start := time.Now()
+
+	pinned := make(map[string]bool)
+	err = p.listBackendPins(ctx, func(cid string) {
+		pinned[cid] = true
+	})
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	report.Pinned = len(pinned)
+
+	hashes, err := p.db.ListHashes(ctx)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	changed, err := p.db.ListHashesChangedSince(ctx, start)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	for cid := range pinned {
+		if changed[cid] {
+			continue
+		}
+
+		active, found := hashes[cid]
+		switch {
+		case !found:
+			report.Orphans = append(report.Orphans, cid)
+		case !active:
+			report.Removed = append(report.Removed, cid)
+		}
+	}
+
+	for hash, active := range hashes {
+		if active && !pinned[hash] && !changed[hash] {
+			report.Dangling = append(report.Dangling, hash)
+		}
+	}
+
+	sort.Strings(report.Orphans)
+	sort.Strings(report.Dangling)
+	sort.Strings(report.Removed)
+
+	mon.IntVal("reconcile_orphans").Observe(int64(len(report.Orphans)))
+	mon.IntVal("reconcile_dangling").Observe(int64(len(report.Dangling)))
+	mon.IntVal("reconcile_removed").Observe(int64(len(report.Removed)))
+
+	if opts.RepairOrphans {
+		err = p.unpinInBatches(ctx, report.Orphans)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if opts.RepairDangling {
+		for _, batch := range batches(report.Dangling) {
+			err = p.db.RemoveContentByHash(ctx, batch)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if opts.RepairRemoved {
+		err = p.unpinInBatches(ctx, report.Removed)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// listBackendPins streams the recursive pins of the IPFS node and calls fn for each
+// of them.
+func (p *Proxy) listBackendPins(ctx context.Context, fn func(cid string)) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	resp, err := p.backendRequest(ctx, PinLsEndpoint, url.Values{
+		"type":   {"recursive"},
+		"stream": {"true"},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return backendResponseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var msg PinLsStreamMessage
+		err = json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			return BackendError.Wrap(err)
+		}
+
+		fn(msg.Cid)
+	}
+	if err := scanner.Err(); err != nil {
+		return BackendError.Wrap(err)
+	}
+
+	// The trailer is available only after the body is read.
+	if msg := resp.Trailer.Get(StreamErrorTrailer); msg != "" {
+		return BackendError.New("%s", msg)
+	}
+
+	return nil
+}
+
+// unpinInBatches unpins hashes from the IPFS node in batches of
+// reconcileBatchSize. The hashes of each batch that a user has active by
+// then are not unpinned.
+func (p *Proxy) unpinInBatches(ctx context.Context, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, batch := range batches(hashes) {
+		owners, err := p.db.ListActiveContentByHash(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		owned := make(map[string]bool, len(owners))
+		for _, owner := range owners {
+			owned[owner.Hash] = true
+		}
+
+		var toUnpin []string
+		for _, hash := range batch {
+			if owned[hash] {
+				mon.Counter("reconcile_skipped_active").Inc(1)
+				continue
+			}
+			toUnpin = append(toUnpin, hash)
+		}
+		if len(toUnpin) == 0 {
+			continue
+		}
+
+		err = p.unpin(ctx, toUnpin)
+		if err != nil {
+			p.log.Error("Error unpinning content",
+				zap.Strings("Hashes", toUnpin),
+				zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// batches splits hashes into batches of reconcileBatchSize.
+func batches(hashes []string) (result [][]string) {
+	for len(hashes) > reconcileBatchSize {
+		result = append(result, hashes[:reconcileBatchSize])
+		hashes = hashes[reconcileBatchSize:]
+	}
+	if len(hashes) > 0 {
+		result = append(result, hashes)
+	}
+	return result
+}
@@ -0,0 +1,150 @@
+package proxy_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestReconcile(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{
+		proxy.PinLsEndpoint: &mock.IPFSPinLsHandler{
+			Pins: []string{"active-hash", "orphan-hash", "removed-hash"},
+		},
+		proxy.PinRmEndpoint: pinRm,
+	}
+
+	for _, tt := range []struct {
+		name     string
+		opts     proxy.ReconcileOptions
+		unpinned []string
+		active   []string
+	}{
+		{
+			name:   "report only",
+			active: []string{"active-hash", "dangling-hash"},
+		},
+		{
+			name:     "repair orphans",
+			opts:     proxy.ReconcileOptions{RepairOrphans: true},
+			unpinned: []string{"orphan-hash"},
+			active:   []string{"active-hash", "dangling-hash"},
+		},
+		{
+			name:   "repair dangling",
+			opts:   proxy.ReconcileOptions{RepairDangling: true},
+			active: []string{"active-hash"},
+		},
+		{
+			name:     "repair removed",
+			opts:     proxy.ReconcileOptions{RepairRemoved: true},
+			unpinned: []string{"removed-hash"},
+			active:   []string{"active-hash", "dangling-hash"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var p *proxy.Proxy
+			configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+			runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+				err := prefillDB(ctx, db,
+					proxydb.Content{User: "john", Hash: "active-hash", Name: "active.jpg", Size: 1024},
+					proxydb.Content{User: "john", Hash: "dangling-hash", Name: "dangling.jpg", Size: 1024},
+					proxydb.Content{User: "john", Hash: "removed-hash", Name: "removed.jpg", Size: 1024},
+					proxydb.Content{User: "shawn", Hash: "removed-hash", Name: "removed.jpg", Size: 1024},
+				)
+				require.NoError(t, err)
+
+				err = db.RemoveContentByHashForUser(ctx, "john", []string{"removed-hash"}, proxydb.EventSource{})
+				require.NoError(t, err)
+				err = db.RemoveContentByHashForUser(ctx, "shawn", []string{"removed-hash"}, proxydb.EventSource{})
+				require.NoError(t, err)
+
+				report, err := p.Reconcile(ctx, tt.opts)
+				require.NoError(t, err)
+
+				assert.Equal(t, 3, report.Pinned)
+				assert.Equal(t, []string{"orphan-hash"}, report.Orphans)
+				assert.Equal(t, []string{"dangling-hash"}, report.Dangling)
+				assert.Equal(t, []string{"removed-hash"}, report.Removed)
+
+				assert.Equal(t, tt.unpinned, pinRm.Removed)
+
+				var active []string
+				contents, err := db.ListAll(ctx)
+				require.NoError(t, err)
+				for _, content := range contents {
+					if content.Removed == nil {
+						active = append(active, content.Hash)
+					}
+				}
+				assert.ElementsMatch(t, tt.active, active)
+			})
+		})
+	}
+}
+
+func TestReconcile_ChangesDuringRun(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	pinLs := &mock.IPFSPinLsHandler{
+		Pins: []string{"uploaded-hash", "removed-hash"},
+	}
+	ipfsHandler := mock.ServeMux{
+		proxy.PinLsEndpoint: pinLs,
+		proxy.PinRmEndpoint: pinRm,
+	}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "removed-hash", Name: "removed.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// Users change the content between listing the pins and querying
+		// the database.
+		pinLs.OnList = func() {
+			// The upload was pinned before the pins are listed, but mapped
+			// only after.
+			err := db.Add(ctx, proxydb.Content{User: "john", Hash: "uploaded-hash", Name: "uploaded.jpg", Size: 1024}, proxydb.EventSource{})
+			require.NoError(t, err)
+
+			// The upload was pinned after the pins are listed.
+			err = db.Add(ctx, proxydb.Content{User: "john", Hash: "new-hash", Name: "new.jpg", Size: 1024}, proxydb.EventSource{})
+			require.NoError(t, err)
+
+			// The content was removed after the pins are listed, and the
+			// unpin queue takes care of it.
+			err = db.RemoveContentByHashForUser(ctx, "john", []string{"removed-hash"}, proxydb.EventSource{})
+			require.NoError(t, err)
+		}
+
+		report, err := p.Reconcile(ctx, proxy.ReconcileOptions{
+			RepairOrphans:  true,
+			RepairDangling: true,
+			RepairRemoved:  true,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, report.Pinned)
+		assert.Empty(t, report.Orphans)
+		assert.Empty(t, report.Dangling)
+		assert.Empty(t, report.Removed)
+		assert.False(t, pinRm.Invoked)
+
+		hashes, err := db.ListActiveContentByUser(ctx, "john")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"uploaded-hash", "new-hash"}, hashes)
+	})
+}
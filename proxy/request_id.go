@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"storj.io/common/uuid"
+)
+
+// RequestIDHeader is the header that carries the ID of a request. The ID is
+// recorded with the content events written while handling the request.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the maximum length of a request ID accepted from the
+// client. Longer IDs are replaced with a generated one.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the ID of a request.
+type requestIDKey struct{}
+
+// requestID returns the ID that withRequestID assigned to the request of ctx.
+// Empty if none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID assigns an ID to every request handled by next. The ID is
+// taken from the request's X-Request-Id header if present, or generated
+// otherwise, and is echoed back in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = ""
+			if generated, err := uuid.New(); err == nil {
+				id = generated.String()
+			}
+		}
+
+		if id != "" {
+			r.Header.Set(RequestIDHeader, id)
+			w.Header().Set(RequestIDHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
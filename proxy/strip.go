@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// prefixStripper is a ResponseWriter that removes a path prefix from the
+// response body as it passes through to the client. The prefix is replaced
+// with a slash when it is followed by a slash or by the end of the path.
+//
+// Only the bytes that may be the beginning of the prefix are held back, so
+// the memory use does not depend on the size of the response.
+type prefixStripper struct {
+	http.ResponseWriter
+	StatusCode int
+
+	prefix      []byte
+	keepOK      bool
+	strip       bool
+	wroteHeader bool
+	pending     []byte
+}
+
+// newPrefixStripper wraps w to strip prefix from the response body. If keepOK
+// is true, the body of OK responses is passed through unchanged.
+func newPrefixStripper(w http.ResponseWriter, prefix string, keepOK bool) *prefixStripper {
+	return &prefixStripper{ResponseWriter: w, StatusCode: http.StatusOK, prefix: []byte(prefix), keepOK: keepOK}
+}
+
+func (s *prefixStripper) WriteHeader(statusCode int) {
+	s.StatusCode = statusCode
+	s.wroteHeader = true
+	s.strip = !(s.keepOK && statusCode == http.StatusOK)
+	if s.strip {
+		// The length of the body changes.
+		s.Header().Del("Content-Length")
+	}
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *prefixStripper) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if !s.strip {
+		return s.ResponseWriter.Write(b)
+	}
+
+	s.pending = append(s.pending, b...)
+	_, err := s.ResponseWriter.Write(s.process(false))
+	return len(b), err
+}
+
+// Flush implements http.Flusher so that streamed responses reach the client
+// without delay.
+func (s *prefixStripper) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Finish writes the bytes held back at the end of the body.
+func (s *prefixStripper) Finish() error {
+	if !s.strip || len(s.pending) == 0 {
+		return nil
+	}
+	_, err := s.ResponseWriter.Write(s.process(true))
+	return err
+}
+
+// process returns the pending bytes with the prefix stripped, except the ones
+// that need more input to decide, unless final is true.
+func (s *prefixStripper) process(final bool) (out []byte) {
+	for {
+		i := bytes.Index(s.pending, s.prefix)
+		if i < 0 {
+			keep := 0
+			if !final {
+				keep = partialPrefixLen(s.pending, s.prefix)
+			}
+			out = append(out, s.pending[:len(s.pending)-keep]...)
+			s.pending = append(s.pending[:0], s.pending[len(s.pending)-keep:]...)
+			return out
+		}
+
+		end := i + len(s.prefix)
+		if end == len(s.pending) && !final {
+			// The next byte decides if this is the prefix.
+			out = append(out, s.pending[:i]...)
+			s.pending = append(s.pending[:0], s.pending[i:]...)
+			return out
+		}
+
+		out = append(out, s.pending[:i]...)
+		switch {
+		case end == len(s.pending):
+			out = append(out, '/')
+			s.pending = s.pending[:0]
+		case s.pending[end] == '/':
+			out = append(out, '/')
+			s.pending = s.pending[end+1:]
+		case isPathByte(s.pending[end]):
+			// A longer name that starts with the prefix.
+			out = append(out, s.prefix...)
+			s.pending = s.pending[end:]
+		default:
+			out = append(out, '/')
+			s.pending = s.pending[end:]
+		}
+	}
+}
+
+// partialPrefixLen returns the length of the longest suffix of b that is the
+// beginning of prefix.
+func partialPrefixLen(b, prefix []byte) int {
+	n := len(prefix) - 1
+	if n > len(b) {
+		n = len(b)
+	}
+	for ; n > 0; n-- {
+		if bytes.HasSuffix(b, prefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// isPathByte returns whether c can continue a path segment in a response.
+func isPathByte(c byte) bool {
+	switch c {
+	case '"', '\'', '\\', ' ', '\t', '\r', '\n', ',', ':', ')', ']', '}':
+		return false
+	}
+	return true
+}
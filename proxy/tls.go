@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultTLSReloadInterval is the default interval to check the TLS files
+// for changes.
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// TLSConfig configures the TLS listener of the proxy.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM files of the server certificate and
+	// its key.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM file of the CAs that issue client
+	// certificates. If set, clients can authenticate with a certificate.
+	ClientCAFile string
+	// RequireClientCert rejects the connections of clients without a valid
+	// certificate.
+	RequireClientCert bool
+	// ClientURIPrefix, if set, makes the user of a client certificate the
+	// rest of its first SAN URI with the prefix, like spiffe://example.com/user/.
+	// Otherwise, the user is the common name of the certificate.
+	ClientURIPrefix string
+	// ReloadInterval is the interval to check the files for changes.
+	// Defaults to DefaultTLSReloadInterval.
+	ReloadInterval time.Duration
+}
+
+// tlsFiles are the loaded TLS files.
+type tlsFiles struct {
+	cert      tls.Certificate
+	clientCAs *x509.CertPool
+	// versions are the modification times and sizes of the files, to detect
+	// changes.
+	versions []string
+}
+
+// WithTLS makes the proxy serve HTTPS with the certificate of config. The
+// files are reloaded when they change.
+func (p *Proxy) WithTLS(config TLSConfig) *Proxy {
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = DefaultTLSReloadInterval
+	}
+	p.tls = &config
+	return p
+}
+
+// tlsConfig returns the TLS configuration of the server. The certificate
+// and client CAs are taken from the last loaded files for each connection.
+func (p *Proxy) tlsConfig() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &p.tlsFiles.Load().(*tlsFiles).cert, nil
+	}
+
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: getCertificate,
+	}
+	if p.tls.ClientCAFile != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if p.tls.RequireClientCert {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := base.Clone()
+			config.ClientCAs = p.tlsFiles.Load().(*tlsFiles).clientCAs
+			return config, nil
+		},
+	}
+}
+
+// reloadTLS loads the TLS files if they changed since they were last loaded.
+// If loading fails, the previous files stay in use.
+func (p *Proxy) reloadTLS() (changed bool, err error) {
+	paths := []string{p.tls.CertFile, p.tls.KeyFile}
+	if p.tls.ClientCAFile != "" {
+		paths = append(paths, p.tls.ClientCAFile)
+	}
+
+	versions := make([]string, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		versions = append(versions, fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()))
+	}
+
+	if current, ok := p.tlsFiles.Load().(*tlsFiles); ok && strings.Join(current.versions, ",") == strings.Join(versions, ",") {
+		return false, nil
+	}
+
+	files := &tlsFiles{versions: versions}
+
+	files.cert, err = tls.LoadX509KeyPair(p.tls.CertFile, p.tls.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	if p.tls.ClientCAFile != "" {
+		pem, err := os.ReadFile(p.tls.ClientCAFile)
+		if err != nil {
+			return false, err
+		}
+
+		files.clientCAs = x509.NewCertPool()
+		if !files.clientCAs.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificates in %s", p.tls.ClientCAFile)
+		}
+	}
+
+	p.tlsFiles.Store(files)
+	return true, nil
+}
+
+// watchTLS reloads the TLS files when they change until ctx is canceled.
+func (p *Proxy) watchTLS(ctx context.Context) {
+	ticker := time.NewTicker(p.tls.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := p.reloadTLS()
+		if err != nil {
+			mon.Counter("tls_reload_errors").Inc(1)
+			p.log.Error("Error reloading TLS files, keeping the previous ones", zap.Error(err))
+			continue
+		}
+		if changed {
+			mon.Counter("tls_reloads").Inc(1)
+			p.log.Info("Reloaded TLS files")
+		}
+	}
+}
+
+// clientCertUser returns the user of the verified client certificate of r.
+func (p *Proxy) clientCertUser(r *http.Request) string {
+	if p.tls == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	if p.tls.ClientURIPrefix == "" {
+		return cert.Subject.CommonName
+	}
+
+	for _, uri := range cert.URIs {
+		if user := strings.TrimPrefix(uri.String(), p.tls.ClientURIPrefix); user != uri.String() {
+			return user
+		}
+	}
+
+	return ""
+}
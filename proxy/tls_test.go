@@ -0,0 +1,309 @@
+package proxy_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestTLS_ClientCertificate(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, _ *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		ca := newTestCA(t)
+		config := ca.serverConfig(t)
+		config.ClientCAFile = ca.writeCert(t)
+
+		address, stop := runTLSProxy(t, ctx, db, config)
+		defer stop()
+
+		// The user is the common name of the client certificate.
+		usage, code := tlsUsage(t, ca.client(t, ca.issueClient(t, "john", nil)), address, "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john", Bytes: 1024, Pins: 1}, usage)
+
+		// The client certificate wins over basic auth.
+		usage, code = tlsUsage(t, ca.client(t, ca.issueClient(t, "john", nil)), address, "shawn")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "john", usage.User)
+
+		// Clients without a certificate authenticate as usual.
+		_, code = tlsUsage(t, ca.client(t, nil), address, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		usage, code = tlsUsage(t, ca.client(t, nil), address, "shawn")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "shawn", usage.User)
+
+		// Certificates of other CAs are rejected.
+		other := newTestCA(t)
+		assert.Error(t, tlsRequestError(t, ca.client(t, other.issueClient(t, "john", nil)), address))
+	})
+}
+
+func TestTLS_ClientURIPrefix(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, _ *httptest.Server, db *proxydb.DB) {
+		ca := newTestCA(t)
+		config := ca.serverConfig(t)
+		config.ClientCAFile = ca.writeCert(t)
+		config.ClientURIPrefix = "spiffe://example.com/user/"
+		config.RequireClientCert = true
+
+		address, stop := runTLSProxy(t, ctx, db, config)
+		defer stop()
+
+		uri, err := url.Parse("spiffe://example.com/user/shawn")
+		require.NoError(t, err)
+
+		usage, code := tlsUsage(t, ca.client(t, ca.issueClient(t, "john", uri)), address, "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "shawn", usage.User)
+
+		// The common name is not used with a URI prefix.
+		_, code = tlsUsage(t, ca.client(t, ca.issueClient(t, "john", nil)), address, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		// Clients without a certificate are rejected.
+		assert.Error(t, tlsRequestError(t, ca.client(t, nil), address))
+	})
+}
+
+func TestTLS_Reload(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, _ *httptest.Server, db *proxydb.DB) {
+		ca := newTestCA(t)
+		config := ca.serverConfig(t)
+
+		address, stop := runTLSProxy(t, ctx, db, config)
+		defer stop()
+
+		serial := func() *big.Int {
+			conn, err := tls.Dial("tcp", address, &tls.Config{RootCAs: ca.pool()})
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+			return conn.ConnectionState().PeerCertificates[0].SerialNumber
+		}
+
+		first := serial()
+
+		// Renew the server certificate in place.
+		renewed := ca.serverConfig(t)
+		certPEM, err := os.ReadFile(renewed.CertFile)
+		require.NoError(t, err)
+		keyPEM, err := os.ReadFile(renewed.KeyFile)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(config.KeyFile, keyPEM, 0o600))
+		require.NoError(t, os.WriteFile(config.CertFile, certPEM, 0o600))
+
+		require.Eventually(t, func() bool {
+			return serial().Cmp(first) != 0
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+}
+
+// runTLSProxy runs a proxy with config in the background on a random local
+// port. The proxy stops when the returned stop function is called.
+func runTLSProxy(t *testing.T, ctx context.Context, db *proxydb.DB, config proxy.TLSConfig) (address string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address = listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	target, err := url.Parse("http://127.0.0.1:1")
+	require.NoError(t, err)
+
+	config.ReloadInterval = 10 * time.Millisecond
+	p := proxy.New(zaptest.NewLogger(t), db, address, target).WithTLS(config)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run(runCtx)
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, 10*time.Second, 10*time.Millisecond)
+
+	return address, func() {
+		cancel()
+		require.NoError(t, <-errCh)
+	}
+}
+
+// tlsUsage requests the usage of the authenticated user over HTTPS.
+func tlsUsage(t *testing.T, client *http.Client, address, basicAuthUser string) (usage proxy.UsageResponseMessage, code int) {
+	req, err := http.NewRequest(http.MethodPost, "https://"+address+proxy.UsageEndpoint, nil)
+	require.NoError(t, err)
+	if basicAuthUser != "" {
+		req.SetBasicAuth(basicAuthUser, "somepassword")
+	}
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	}
+
+	return usage, resp.StatusCode
+}
+
+// tlsRequestError returns the error of a usage request over HTTPS that is
+// expected to fail.
+func tlsRequestError(t *testing.T, client *http.Client, address string) error {
+	req, err := http.NewRequest(http.MethodPost, "https://"+address+proxy.UsageEndpoint, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("john", "somepassword")
+
+	resp, err := client.Do(req)
+	if err == nil {
+		require.NoError(t, resp.Body.Close())
+	}
+	return err
+}
+
+// testCA is a locally generated CA for server and client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          newSerial(t),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, dir: t.TempDir()}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) writeCert(t *testing.T) string {
+	path := filepath.Join(ca.dir, "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	return path
+}
+
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = newSerial(t)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serverConfig issues a server certificate for 127.0.0.1 and returns a
+// config with its files.
+func (ca *testCA) serverConfig(t *testing.T) proxy.TLSConfig {
+	cert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	config := proxy.TLSConfig{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	require.NoError(t, os.WriteFile(config.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(config.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return config
+}
+
+// issueClient issues a client certificate with commonName and an optional
+// SAN URI.
+func (ca *testCA) issueClient(t *testing.T, commonName string, uri *url.URL) *tls.Certificate {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if uri != nil {
+		template.URIs = []*url.URL{uri}
+	}
+
+	cert := ca.issue(t, template)
+	return &cert
+}
+
+// client returns an HTTPS client that trusts ca and authenticates with
+// cert, if not nil.
+func (ca *testCA) client(t *testing.T, cert *tls.Certificate) *http.Client {
+	config := &tls.Config{RootCAs: ca.pool()}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	transport := &http.Transport{TLSClientConfig: config}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return &http.Client{Transport: transport}
+}
+
+func newSerial(t *testing.T) *big.Int {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	return serial
+}
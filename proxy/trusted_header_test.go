@@ -0,0 +1,101 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+const testTrustedHeader = "X-Authenticated-User"
+
+func TestTrustedHeader_TrustedProxy(t *testing.T) {
+	runTestWithConfig(t, nil, configureTrustedHeader(t, "127.0.0.0/8", "::1"), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// The header takes precedence over basic auth.
+		resp := trustedHeaderRequest(t, server.URL+proxy.UsageEndpoint, "john", "shawn")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage proxy.UsageResponseMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john", Bytes: 1024, Pins: 1}, usage)
+
+		// Without the header, the request is authenticated as usual.
+		resp = trustedHeaderRequest(t, server.URL+proxy.UsageEndpoint, "", "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestTrustedHeader_UntrustedClient(t *testing.T) {
+	runTestWithConfig(t, nil, configureTrustedHeader(t, "10.0.0.0/8"), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// The header is ignored and the request has no other credentials.
+		resp := trustedHeaderRequest(t, server.URL+proxy.UsageEndpoint, "john", "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		// The header is ignored in favor of basic auth.
+		resp = trustedHeaderRequest(t, server.URL+proxy.UsageEndpoint, "john", "shawn")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var usage proxy.UsageResponseMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, proxy.UsageResponseMessage{User: "shawn"}, usage)
+	})
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := proxy.ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.10", "::1", ""})
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.168.1.10/32", networks[1].String())
+	assert.Equal(t, "::1/128", networks[2].String())
+
+	_, err = proxy.ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = proxy.ParseTrustedProxies([]string{"example.com"})
+	assert.Error(t, err)
+}
+
+func configureTrustedHeader(t *testing.T, cidrs ...string) func(*proxy.Proxy, *proxydb.DB) {
+	networks, err := proxy.ParseTrustedProxies(cidrs)
+	require.NoError(t, err)
+
+	return func(configured *proxy.Proxy, _ *proxydb.DB) {
+		configured.WithTrustedHeader(testTrustedHeader, networks)
+	}
+}
+
+func trustedHeaderRequest(t *testing.T, url, headerUser, basicAuthUser string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	require.NoError(t, err)
+	if headerUser != "" {
+		req.Header.Set(testTrustedHeader, headerUser)
+	}
+	if basicAuthUser != "" {
+		req.SetBasicAuth(basicAuthUser, "somepassword")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultUnpinInterval is the default interval of processing the unpin queue.
+	DefaultUnpinInterval = time.Minute
+
+	// unpinBatchSize is the maximum number of queued unpins processed at once.
+	unpinBatchSize = 100
+	// unpinRetryBaseDelay is the delay after the first failed unpin attempt.
+	// It doubles with every following attempt.
+	unpinRetryBaseDelay = time.Minute
+	// unpinRetryMaxDelay is the maximum delay between unpin attempts.
+	unpinRetryMaxDelay = 6 * time.Hour
+)
+
+// WithUnpinInterval sets the interval of processing the unpin queue in Run.
+func (p *Proxy) WithUnpinInterval(interval time.Duration) *Proxy {
+	p.unpinInterval = interval
+	return p
+}
+
+// runUnpinWorker processes the unpin queue and the cleanup queue every unpin
+// interval until ctx is canceled.
+func (p *Proxy) runUnpinWorker(ctx context.Context) {
+	ticker := time.NewTicker(p.unpinInterval)
+	defer ticker.Stop()
+
+	for {
+		err := p.ProcessUnpinQueue(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Error("Error processing unpin queue", zap.Error(err))
+		}
+
+		err = p.ProcessCleanupQueue(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Error("Error processing cleanup queue", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessUnpinQueue unpins the due hashes in the unpin queue from the IPFS
+// node. Hashes that a user added again in the meantime are dropped from the
+// queue without unpinning. Failed unpins are retried with exponential backoff.
+func (p *Proxy) ProcessUnpinQueue(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	depth, age, err := p.db.UnpinQueueStats(ctx)
+	if err != nil {
+		return err
+	}
+	mon.IntVal("unpin_queue_depth").Observe(depth)
+	mon.FloatVal("unpin_queue_oldest_age_seconds").Observe(age.Seconds())
+
+	unpins, err := p.db.ListDueUnpins(ctx, unpinBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, unpin := range unpins {
+		unpinned, err := p.db.UnpinQueued(ctx, []string{unpin.Hash}, p.unpinIgnoringNotPinned)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || !BackendError.Has(err) {
+				return err
+			}
+
+			mon.Counter("unpin_queue_error_backend_unpin").Inc(1)
+			p.log.Error("Error unpinning queued content",
+				zap.String("Hash", unpin.Hash),
+				zap.Int("Attempts", unpin.Attempts+1),
+				zap.Error(err))
+
+			err = p.db.RetryQueuedUnpin(ctx, unpin.Hash, unpinRetryDelay(unpin.Attempts), err.Error())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(unpinned) > 0 {
+			mon.Counter("unpin_queue_unpinned").Inc(1)
+		} else {
+			// Someone added the content again after it was queued.
+			mon.Counter("unpin_queue_skipped_active").Inc(1)
+		}
+	}
+
+	return nil
+}
+
+// unpinQueued unpins hashes queued by RemoveContentByHashForUser right away
+// and removes them from the queue. If the unpin fails, the hashes are left in
+// the queue to be retried by the unpin worker.
+func (p *Proxy) unpinQueued(ctx context.Context, hashes []string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = p.db.UnpinQueued(ctx, hashes, p.unpinIgnoringNotPinned)
+	return err
+}
+
+// unpinIgnoringNotPinned unpins hashes from the IPFS node. Hashes that are
+// not pinned anymore are considered unpinned.
+func (p *Proxy) unpinIgnoringNotPinned(ctx context.Context, hashes []string) error {
+	err := p.unpin(ctx, hashes)
+	if err != nil && !isNotPinned(err) {
+		return err
+	}
+	return nil
+}
+
+// unpinRetryDelay returns the delay before the next unpin attempt after the
+// given number of failed attempts.
+func unpinRetryDelay(attempts int) time.Duration {
+	delay := unpinRetryBaseDelay
+	for i := 0; i < attempts && delay < unpinRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > unpinRetryMaxDelay {
+		delay = unpinRetryMaxDelay
+	}
+	return delay
+}
+
+// isNotPinned returns whether err is the error of the IPFS node for unpinning
+// content that is not pinned. Such unpins are considered done.
+func isNotPinned(err error) bool {
+	var respErr *backendResponseErr
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.msg.Code == ipfsErrNormal &&
+		respErr.msg.Type == "error" &&
+		respErr.msg.Message == ipfsErrNotPinnedMessage
+}
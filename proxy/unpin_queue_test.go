@@ -0,0 +1,195 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/mock"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestUnpinQueue_RetryFailedUnpin(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{proxy.PinRmEndpoint: new(mock.ErrorHandler)}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		// Unpin with a failing backend.
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		// Check that the hash is queued for unpinning.
+		unpins, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, unpins, 1)
+		assert.Equal(t, "pin-hash-1", unpins[0].Hash)
+
+		// Process the queue with a working backend.
+		ipfsHandler[proxy.PinRmEndpoint] = pinRm
+		err = p.ProcessUnpinQueue(ctx)
+		require.NoError(t, err)
+
+		// Check that the hash is unpinned and removed from the queue.
+		assert.Equal(t, []string{"pin-hash-1"}, pinRm.Removed)
+
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestUnpinQueue_Backoff(t *testing.T) {
+	ipfsHandler := mock.ServeMux{proxy.PinRmEndpoint: new(mock.ErrorHandler)}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		// Process the queue with the failing backend.
+		err = p.ProcessUnpinQueue(ctx)
+		require.NoError(t, err)
+
+		// Check that the hash is still queued, but not due anymore.
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, depth)
+
+		unpins, err := db.ListDueUnpins(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, unpins)
+	})
+}
+
+func TestUnpinQueue_SkipAddedAgain(t *testing.T) {
+	pinRm := new(mock.IPFSPinRmHandler)
+	ipfsHandler := mock.ServeMux{proxy.PinRmEndpoint: new(mock.ErrorHandler)}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		// Another user adds the same content before the queue is processed.
+		err = prefillDB(ctx, db,
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		ipfsHandler[proxy.PinRmEndpoint] = pinRm
+		err = p.ProcessUnpinQueue(ctx)
+		require.NoError(t, err)
+
+		// Check that the hash is not unpinned, but removed from the queue.
+		assert.False(t, pinRm.Invoked)
+
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestUnpinQueue_NotPinned(t *testing.T) {
+	pinRm := &mock.IPFSPinRmHandler{NotPinned: []string{"pin-hash-1"}}
+	ipfsHandler := mock.ServeMux{proxy.PinRmEndpoint: new(mock.ErrorHandler)}
+
+	var p *proxy.Proxy
+	configure := func(configured *proxy.Proxy, _ *proxydb.DB) { p = configured }
+
+	runTestWithConfig(t, ipfsHandler, configure, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		// The IPFS node has unpinned the hash in the meantime.
+		ipfsHandler[proxy.PinRmEndpoint] = pinRm
+		err = p.ProcessUnpinQueue(ctx)
+		require.NoError(t, err)
+
+		// Check that the unpin is considered done.
+		assert.True(t, pinRm.Invoked)
+		assert.Empty(t, pinRm.Removed)
+
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestUnpinQueue_DequeuedOnAdd(t *testing.T) {
+	ipfsHandler := mock.ServeMux{proxy.PinRmEndpoint: new(mock.ErrorHandler)}
+
+	runTest(t, ipfsHandler, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		removePins(t, server.URL, "john", "pin-hash-1")
+
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, depth)
+
+		// Adding the content again removes it from the queue.
+		err = db.Add(ctx, proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024}, proxydb.EventSource{})
+		require.NoError(t, err)
+
+		depth, _, err = db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestUnpinQueue_NotQueuedIfPinnedByOthers(t *testing.T) {
+	runTest(t, new(mock.IPFSPinRmHandler), func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-1"}, proxydb.EventSource{})
+		require.NoError(t, err)
+
+		depth, _, err := db.UnpinQueueStats(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func removePins(t *testing.T, serverURL, user string, hashes ...string) {
+	req, err := pinRmRequest(serverURL+proxy.PinRmEndpoint, user, hashes...)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
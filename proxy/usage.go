@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+)
+
+// UsageResponseMessage is the JSON object returned to Usage requests.
+type UsageResponseMessage struct {
+	User    string `json:"User"`
+	Bytes   int64  `json:"Bytes"`
+	Pins    int64  `json:"Pins"`
+	Removed int64  `json:"Removed"`
+}
+
+// HandleUsage is an HTTP handler that serves the /api/v0/x/usage endpoint.
+//
+// It retrieves the authenticated user from the requests and responds with
+// the total size and number of the user's active content, and the number of
+// the user's removed content. The optional as-of argument takes an RFC 3339
+// timestamp to get the usage at that time.
+func (p *Proxy) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	_ = p.handleUsage(r.Context(), w, r)
+}
+
+func (p *Proxy) handleUsage(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, user, err := p.authenticate(ctx, w, r, "usage")
+	if err != nil {
+		return err
+	}
+
+	var asOf *time.Time
+	for param, values := range r.URL.Query() {
+		switch param {
+		case "as-of":
+			t, err := time.Parse(time.RFC3339, values[0])
+			if err != nil {
+				mon.Counter("usage_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+				err = fmt.Errorf("as-of must be an RFC 3339 timestamp: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+			asOf = &t
+		default:
+			mon.Counter("usage_handler_invalid_query_param", monkit.NewSeriesTag("param", param)).Inc(1)
+			p.log.Error("Invalid query param",
+				zap.String("User", user),
+				zap.String("Param", param))
+			err = errors.New("only as-of argument is allowed")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	var usage db.Usage
+	if asOf == nil {
+		usage, err = p.db.GetUsage(ctx, user)
+	} else {
+		usage, err = p.db.GetUsageAsOf(ctx, user, *asOf)
+	}
+	if err != nil {
+		mon.Counter("usage_handler_error_db_get_usage").Inc(1)
+		p.log.Error("Error getting usage",
+			zap.String("User", user),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	mon.Counter("usage_handler_response_codes", monkit.NewSeriesTag("code", strconv.Itoa(http.StatusOK))).Inc(1)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(UsageResponseMessage{
+		User:    usage.User,
+		Bytes:   usage.Bytes,
+		Pins:    usage.Pins,
+		Removed: usage.Removed,
+	})
+}
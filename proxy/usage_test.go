@@ -0,0 +1,93 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	proxydb "storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestUsageHandler_MissingBasicAuth(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+proxy.UsageEndpoint, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestUsageHandler_Basic(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		before := time.Now().Add(-time.Minute)
+
+		err := prefillDB(ctx, db,
+			proxydb.Content{User: "john", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+			proxydb.Content{User: "john", Hash: "pin-hash-2", Name: "second.jpg", Size: 2048},
+			proxydb.Content{User: "john", Hash: "pin-hash-3", Name: "third.jpg", Size: 4096},
+			proxydb.Content{User: "shawn", Hash: "pin-hash-1", Name: "first.jpg", Size: 1024},
+		)
+		require.NoError(t, err)
+
+		err = db.RemoveContentByHashForUser(ctx, "john", []string{"pin-hash-3"}, proxydb.EventSource{})
+		require.NoError(t, err)
+
+		usage := getUsage(t, server.URL, "john", "")
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john", Bytes: 3072, Pins: 2, Removed: 1}, usage)
+
+		usage = getUsage(t, server.URL, "shawn", "")
+		assert.Equal(t, proxy.UsageResponseMessage{User: "shawn", Bytes: 1024, Pins: 1}, usage)
+
+		usage = getUsage(t, server.URL, "nobody", "")
+		assert.Equal(t, proxy.UsageResponseMessage{User: "nobody"}, usage)
+
+		// Nothing was added before the content was prefilled.
+		usage = getUsage(t, server.URL, "john", before.Format(time.RFC3339))
+		assert.Equal(t, proxy.UsageResponseMessage{User: "john"}, usage)
+	})
+}
+
+func TestUsageHandler_InvalidQueryParams(t *testing.T) {
+	runTest(t, nil, func(t *testing.T, ctx *testcontext.Context, server *httptest.Server, db *proxydb.DB) {
+		for _, query := range []string{"?as-of=yesterday", "?user=shawn"} {
+			req, err := http.NewRequest(http.MethodPost, server.URL+proxy.UsageEndpoint+query, nil)
+			require.NoError(t, err)
+			req.SetBasicAuth("john", "somepassword")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func getUsage(t *testing.T, serverURL, user, asOf string) (usage proxy.UsageResponseMessage) {
+	u := serverURL + proxy.UsageEndpoint
+	if asOf != "" {
+		u += "?as-of=" + url.QueryEscape(asOf)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(user, "somepassword")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, resp.Body.Close()) }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	return usage
+}
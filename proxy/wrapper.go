@@ -1,28 +1,115 @@
 package proxy
 
 import (
+	"bytes"
 	"net/http"
 )
 
+// maxBodySize is the maximum number of bytes of a non-OK response body that
+// ResponseWriterWrapper keeps for logging.
+const maxBodySize = 4 * 1024
+
 // ResponseWriterWrapper wraps a ResponseWriter and makes a copy of its
-// StatusCode and Body in public fields.
+// StatusCode in a public field.
+//
+// The body of an OK response is passed through to the client and split into
+// newline-delimited messages that are fed to the message callback as they
+// arrive. Only the current incomplete message is kept in memory, so the
+// memory use does not depend on the size of the response.
 type ResponseWriterWrapper struct {
 	http.ResponseWriter
 	StatusCode int
-	Body       []byte
+
+	// Body holds the beginning of a non-OK response body, up to maxBodySize bytes.
+	Body []byte
+
+	onMessage func(msg []byte) error
+	partial   []byte
+	err       error
 }
 
 // NewResponseWriterWrapper wraps the provided ResponseWrapper.
-func NewResponseWriterWrapper(w http.ResponseWriter) *ResponseWriterWrapper {
-	return &ResponseWriterWrapper{ResponseWriter: w, StatusCode: http.StatusOK}
+//
+// If onMessage is not nil, it is called for each newline-delimited message
+// of an OK response body. After the first error returned by onMessage, the
+// remaining messages are passed to the client, but not to onMessage.
+func NewResponseWriterWrapper(w http.ResponseWriter, onMessage func(msg []byte) error) *ResponseWriterWrapper {
+	return &ResponseWriterWrapper{ResponseWriter: w, StatusCode: http.StatusOK, onMessage: onMessage}
 }
 
 func (rww *ResponseWriterWrapper) WriteHeader(statusCode int) {
 	rww.StatusCode = statusCode
+	if rww.onMessage != nil && statusCode == http.StatusOK {
+		// Errors found in the messages are reported in the X-Stream-Error
+		// trailer after the body, which needs a chunked response.
+		rww.ResponseWriter.Header().Del("Content-Length")
+	}
 	rww.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (rww *ResponseWriterWrapper) Write(b []byte) (int, error) {
-	rww.Body = append(rww.Body, b...)
+	if rww.StatusCode != http.StatusOK {
+		if n := maxBodySize - len(rww.Body); n > 0 {
+			if n > len(b) {
+				n = len(b)
+			}
+			rww.Body = append(rww.Body, b[:n]...)
+		}
+	} else {
+		rww.feed(b)
+	}
 	return rww.ResponseWriter.Write(b)
 }
+
+// Flush implements http.Flusher so that streamed responses reach the client
+// without delay.
+func (rww *ResponseWriterWrapper) Flush() {
+	if flusher, ok := rww.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Finish feeds the last message if the body does not end with a newline.
+// It returns the first error returned by the message callback.
+func (rww *ResponseWriterWrapper) Finish() error {
+	if rww.StatusCode == http.StatusOK && len(bytes.TrimSpace(rww.partial)) > 0 {
+		rww.handle(rww.partial)
+	}
+	rww.partial = nil
+	return rww.err
+}
+
+func (rww *ResponseWriterWrapper) feed(b []byte) {
+	if rww.onMessage == nil || rww.err != nil {
+		return
+	}
+
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			rww.partial = append(rww.partial, b...)
+			return
+		}
+
+		msg := b[:i]
+		if len(rww.partial) > 0 {
+			rww.partial = append(rww.partial, msg...)
+			msg = rww.partial
+		}
+
+		if len(bytes.TrimSpace(msg)) > 0 {
+			rww.handle(msg)
+			if rww.err != nil {
+				rww.partial = nil
+				return
+			}
+		}
+
+		rww.partial = rww.partial[:0]
+		b = b[i+1:]
+	}
+}
+
+func (rww *ResponseWriterWrapper) handle(msg []byte) {
+	rww.err = rww.onMessage(msg)
+}
@@ -0,0 +1,83 @@
+package proxy_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+)
+
+func TestResponseWriterWrapper_Messages(t *testing.T) {
+	body := "{\"Name\":\"a\"}\n{\"Name\":\"b\"}\n\n{\"Name\":\"c\"}"
+
+	// Feed the body in chunks of every possible size.
+	for chunkSize := 1; chunkSize <= len(body); chunkSize++ {
+		recorder := httptest.NewRecorder()
+
+		var messages []string
+		wrapper := proxy.NewResponseWriterWrapper(recorder, func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		})
+
+		for i := 0; i < len(body); i += chunkSize {
+			end := i + chunkSize
+			if end > len(body) {
+				end = len(body)
+			}
+			_, err := wrapper.Write([]byte(body[i:end]))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, wrapper.Finish())
+		assert.Equal(t, []string{`{"Name":"a"}`, `{"Name":"b"}`, `{"Name":"c"}`}, messages, chunkSize)
+		assert.Equal(t, body, recorder.Body.String())
+		assert.Empty(t, wrapper.Body)
+	}
+}
+
+func TestResponseWriterWrapper_MessageError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	var calls int
+	wrapper := proxy.NewResponseWriterWrapper(recorder, func(msg []byte) error {
+		calls++
+		return errors.New("bad message")
+	})
+
+	_, err := wrapper.Write([]byte("first\nsecond\nthird\n"))
+	require.NoError(t, err)
+
+	require.EqualError(t, wrapper.Finish(), "bad message")
+	assert.Equal(t, 1, calls)
+
+	// The whole body is still passed to the client.
+	assert.Equal(t, "first\nsecond\nthird\n", recorder.Body.String())
+}
+
+func TestResponseWriterWrapper_ErrorBody(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	wrapper := proxy.NewResponseWriterWrapper(recorder, func(msg []byte) error {
+		t.Fatal("messages of non-OK responses must not be decoded")
+		return nil
+	})
+
+	body := strings.Repeat("x", 10*1024)
+
+	wrapper.WriteHeader(http.StatusInternalServerError)
+	_, err := wrapper.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, wrapper.Finish())
+
+	assert.Equal(t, http.StatusInternalServerError, wrapper.StatusCode)
+	assert.Equal(t, body, recorder.Body.String())
+	assert.Less(t, len(wrapper.Body), len(body))
+	assert.True(t, strings.HasPrefix(body, string(wrapper.Body)))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	quotasCmd = &cobra.Command{
+		Use:   "quotas",
+		Short: "Manage the storage quotas of users",
+	}
+
+	quotasSetCmd = &cobra.Command{
+		Use:   "set <user>",
+		Short: "Set the quota of a user",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdQuotasSet,
+	}
+
+	quotasRmCmd = &cobra.Command{
+		Use:   "rm <user>",
+		Short: "Remove the quota of a user, which makes the user unlimited",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdQuotasRm,
+	}
+
+	quotasLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List quotas with the current usage",
+		Args:  cobra.NoArgs,
+		RunE:  cmdQuotasLs,
+	}
+
+	quotasConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+	}
+
+	quotasSetConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		MaxBytes    int64  `help:"maximum total size in bytes of the user's content, negative for unlimited" default:"-1"`
+		MaxPins     int64  `help:"maximum number of the user's pinned content, negative for unlimited" default:"-1"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(quotasCmd)
+	quotasCmd.AddCommand(quotasSetCmd)
+	process.Bind(quotasSetCmd, &quotasSetConfig)
+	for _, cmd := range []*cobra.Command{quotasRmCmd, quotasLsCmd} {
+		quotasCmd.AddCommand(cmd)
+		process.Bind(cmd, &quotasConfig)
+	}
+}
+
+func cmdQuotasSet(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	quota := db.Quota{User: args[0]}
+	if quotasSetConfig.MaxBytes >= 0 {
+		quota.MaxBytes = &quotasSetConfig.MaxBytes
+	}
+	if quotasSetConfig.MaxPins >= 0 {
+		quota.MaxPins = &quotasSetConfig.MaxPins
+	}
+
+	db, err := openDB(ctx, quotasSetConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.SetQuota(ctx, quota)
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %v", err)
+	}
+
+	return nil
+}
+
+func cmdQuotasRm(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, quotasConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.RemoveQuota(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to remove quota: %v", err)
+	}
+
+	return nil
+}
+
+func cmdQuotasLs(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, quotasConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	quotas, err := db.ListQuotas(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list quotas: %v", err)
+	}
+
+	fmt.Println("USER\tBYTES\tMAX BYTES\tPINS\tMAX PINS\tUPDATED")
+	for _, quota := range quotas {
+		usage, err := db.GetUsage(ctx, quota.User)
+		if err != nil {
+			return fmt.Errorf("failed to get usage: %v", err)
+		}
+
+		fmt.Printf("%s\t%d\t%s\t%d\t%s\t%s\n",
+			quota.User,
+			usage.Bytes, formatLimit(quota.MaxBytes),
+			usage.Pins, formatLimit(quota.MaxPins),
+			quota.Updated.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func formatLimit(limit *int64) string {
+	if limit == nil {
+		return "unlimited"
+	}
+	return strconv.FormatInt(*limit, 10)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"storj.io/ipfs-user-mapping-proxy/proxy"
+	"storj.io/private/process"
+)
+
+var (
+	reconcileCmd = &cobra.Command{
+		Use:   "reconcile",
+		Short: "Compare the content in the database with the pins of the IPFS node",
+		Long: "Compare the content in the database with the recursive pins of the IPFS node and report:\n" +
+			"  orphans:  hashes pinned on the node, but unknown to the database\n" +
+			"  dangling: hashes active in the database, but not pinned on the node\n" +
+			"  removed:  hashes removed for every user in the database, but still pinned on the node",
+		Args: cobra.NoArgs,
+		RunE: cmdReconcile,
+	}
+
+	reconcileConfig struct {
+		Target         string `help:"target url of the IPFS HTTP API"`
+		DatabaseURL    string `help:"database url to store user to content mappings"`
+		RepairOrphans  bool   `help:"unpin the orphans from the IPFS node" default:"false"`
+		RepairDangling bool   `help:"mark the dangling content as removed in the database" default:"false"`
+		RepairRemoved  bool   `help:"unpin the removed content from the IPFS node" default:"false"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	process.Bind(reconcileCmd, &reconcileConfig)
+}
+
+func cmdReconcile(cmd *cobra.Command, args []string) error {
+	ctx, cancel := process.Ctx(cmd)
+	defer cancel()
+
+	target, err := url.Parse(reconcileConfig.Target)
+	if err != nil {
+		return fmt.Errorf("failed to parse target url: %v", err)
+	}
+
+	db, err := openDB(ctx, reconcileConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	p := proxy.New(zap.NewNop(), db, "", target)
+
+	report, err := p.Reconcile(ctx, proxy.ReconcileOptions{
+		RepairOrphans:  reconcileConfig.RepairOrphans,
+		RepairDangling: reconcileConfig.RepairDangling,
+		RepairRemoved:  reconcileConfig.RepairRemoved,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile: %v", err)
+	}
+
+	for _, hash := range report.Orphans {
+		fmt.Printf("orphan\t%s\n", hash)
+	}
+	for _, hash := range report.Dangling {
+		fmt.Printf("dangling\t%s\n", hash)
+	}
+	for _, hash := range report.Removed {
+		fmt.Printf("removed\t%s\n", hash)
+	}
+
+	fmt.Printf("pinned: %d, orphans: %d (repaired: %t), dangling: %d (repaired: %t), removed: %d (repaired: %t)\n",
+		report.Pinned,
+		len(report.Orphans), reconcileConfig.RepairOrphans,
+		len(report.Dangling), reconcileConfig.RepairDangling,
+		len(report.Removed), reconcileConfig.RepairRemoved)
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/db"
+	"storj.io/private/process"
+)
+
+var (
+	usageCmd = &cobra.Command{
+		Use:   "usage [user]",
+		Short: "Report the active bytes, pins and removed pins of users",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cmdUsage,
+	}
+
+	usageConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+		AsOf        string `help:"RFC 3339 timestamp to report the usage at, instead of now" default:""`
+		Output      string `help:"output format: table, csv or json" default:"table"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	process.Bind(usageCmd, &usageConfig)
+}
+
+func cmdUsage(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	var asOf *time.Time
+	if usageConfig.AsOf != "" {
+		t, err := time.Parse(time.RFC3339, usageConfig.AsOf)
+		if err != nil {
+			return fmt.Errorf("failed to parse as-of timestamp: %v", err)
+		}
+		asOf = &t
+	}
+
+	switch usageConfig.Output {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unsupported output format: %q", usageConfig.Output)
+	}
+
+	db, err := openDB(ctx, usageConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	var user string
+	if len(args) > 0 {
+		user = args[0]
+	}
+
+	usages, err := listUsage(ctx, db, user, asOf)
+	if err != nil {
+		return fmt.Errorf("failed to get usage: %v", err)
+	}
+
+	return printUsage(usages, usageConfig.Output)
+}
+
+// listUsage returns the usage of user, or of all users if empty, as of the
+// given time, or now if nil.
+func listUsage(ctx context.Context, proxyDB *db.DB, user string, asOf *time.Time) ([]db.Usage, error) {
+	if user == "" {
+		return proxyDB.ListUsage(ctx, asOf)
+	}
+
+	var usage db.Usage
+	var err error
+	if asOf == nil {
+		usage, err = proxyDB.GetUsage(ctx, user)
+	} else {
+		usage, err = proxyDB.GetUsageAsOf(ctx, user, *asOf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []db.Usage{usage}, nil
+}
+
+func printUsage(usages []db.Usage, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if usages == nil {
+			usages = []db.Usage{}
+		}
+		return enc.Encode(usages)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"user", "bytes", "pins", "removed"})
+		for _, usage := range usages {
+			_ = w.Write([]string{
+				usage.User,
+				strconv.FormatInt(usage.Bytes, 10),
+				strconv.FormatInt(usage.Pins, 10),
+				strconv.FormatInt(usage.Removed, 10),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tBYTES\tPINS\tREMOVED")
+		for _, usage := range usages {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", usage.User, usage.Bytes, usage.Pins, usage.Removed)
+		}
+		return w.Flush()
+	}
+}
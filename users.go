@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/ipfs-user-mapping-proxy/auth"
+	"storj.io/private/process"
+)
+
+var (
+	usersCmd = &cobra.Command{
+		Use:   "users",
+		Short: "Manage the users verified against the database",
+	}
+
+	usersSetCmd = &cobra.Command{
+		Use:   "set <user>",
+		Short: "Create a user or change its password. The password is read from the standard input.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdUsersSet,
+	}
+
+	usersRmCmd = &cobra.Command{
+		Use:   "rm <user>",
+		Short: "Remove a user",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdUsersRm,
+	}
+
+	usersLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE:  cmdUsersLs,
+	}
+
+	usersConfig struct {
+		DatabaseURL string `help:"database url to store user to content mappings"`
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(usersCmd)
+	for _, cmd := range []*cobra.Command{usersSetCmd, usersRmCmd, usersLsCmd} {
+		usersCmd.AddCommand(cmd)
+		process.Bind(cmd, &usersConfig)
+	}
+}
+
+func cmdUsersSet(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && password != "") {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	db, err := openDB(ctx, usersConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.SetUserPassword(ctx, args[0], hash)
+	if err != nil {
+		return fmt.Errorf("failed to set user password: %v", err)
+	}
+
+	return nil
+}
+
+func cmdUsersRm(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, usersConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	err = db.RemoveUser(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to remove user: %v", err)
+	}
+
+	return nil
+}
+
+func cmdUsersLs(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	db, err := openDB(ctx, usersConfig.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %v", err)
+	}
+
+	for _, user := range users {
+		fmt.Printf("%s\t%s\n", user.Name, user.Created.Format(time.RFC3339))
+	}
+
+	return nil
+}